@@ -0,0 +1,220 @@
+package commands
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/geoip"
+	"github.com/sheb-gregor/uwatch/logparser"
+	"github.com/sheb-gregor/uwatch/sources"
+	"github.com/sheb-gregor/uwatch/workers"
+	"github.com/sirupsen/logrus"
+)
+
+// BackfillReport counts the processed lines of all files.
+type BackfillReport struct {
+	Files        int `json:"files"`
+	SkippedFiles int `json:"skipped_files"`
+	Lines        int `json:"lines"`
+	Imported     int `json:"imported"`
+	// Skipped lines were imported before.
+	Skipped int `json:"skipped"`
+	// Ignored lines have no supported events or are filtered by the config.
+	Ignored int `json:"ignored"`
+	Failed  int `json:"failed"`
+}
+
+// Backfill imports the rotated copies of the file sources, like auth.log.1 and auth.log.2.gz,
+// into the storage without notifications. The live files, the rotated file the watcher
+// has not finished yet and the files it has read to the end are left out.
+// The progress is saved per file, so the repeated run imports only new files.
+func Backfill(cfg config.Config, storage db.StorageI, logger *logrus.Entry) (BackfillReport, error) {
+	report := BackfillReport{}
+
+	var geo *geoip.Resolver
+	if cfg.GeoIP != nil {
+		var err error
+		if geo, err = geoip.Open(*cfg.GeoIP); err != nil {
+			return report, err
+		}
+		defer func() { _ = geo.Close() }()
+	}
+
+	for _, srcCfg := range cfg.Sources {
+		if srcCfg.Type != config.SourceFile {
+			continue
+		}
+
+		parsers := logparser.Default()
+		if len(srcCfg.Parsers) > 0 {
+			var err error
+			if parsers, err = parsers.Subset(srcCfg.Parsers...); err != nil {
+				return report, err
+			}
+		}
+
+		files, err := sources.RotatedFiles(srcCfg.Path)
+		if err != nil {
+			return report, err
+		}
+
+		watchPos, err := storage.Positions().GetPosition(srcCfg.Path)
+		if err != nil {
+			return report, err
+		}
+
+		b := &backfill{
+			cfg:      cfg,
+			source:   srcCfg,
+			storage:  storage,
+			geo:      geo,
+			parsers:  parsers,
+			report:   &report,
+			watchPos: watchPos,
+		}
+
+		for _, path := range files {
+			fileLogger := logger.WithField("file", path)
+			if err := b.importFile(path); err != nil {
+				fileLogger.WithError(err).Error("failed to import file")
+				return report, err
+			}
+			fileLogger.Info("file imported")
+		}
+	}
+
+	return report, nil
+}
+
+// importBatchLines is the number of lines between the saves of the import progress,
+// the interrupted import repeats at most that many lines.
+const importBatchLines = 1000
+
+type backfill struct {
+	cfg      config.Config
+	source   config.SourceConfig
+	storage  db.StorageI
+	geo      *geoip.Resolver
+	parsers  *logparser.Registry
+	report   *BackfillReport
+	watchPos *db.FilePosition
+}
+
+func (b *backfill) importFile(path string) error {
+	fileID, err := logFileID(path)
+	if err != nil {
+		return err
+	}
+
+	state, err := b.storage.Imports().GetImport(fileID)
+	if err != nil {
+		return err
+	}
+	if state == nil {
+		state = &db.ImportState{FileID: fileID}
+	}
+	if state.Done {
+		b.report.SkippedFiles++
+		return nil
+	}
+	state.Path = path
+
+	// the watcher reads the rest of the file after the restart
+	if inode, err := sources.FileInode(path); err == nil && b.watchPos != nil && inode != 0 && inode == b.watchPos.Inode {
+		b.report.SkippedFiles++
+		return nil
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	headers := logparser.NewHeaderParser(b.cfg.Location, info.ModTime)
+	settings := sources.Settings{Name: b.source.Name, Labels: b.source.Labels, Parsers: b.parsers}
+
+	file, err := sources.OpenLog(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	b.report.Files++
+	var lineNo int64
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadString('\n')
+		if line == "" && err == io.EOF {
+			break
+		}
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		lineNo++
+		b.report.Lines++
+		if lineNo <= state.Lines {
+			b.report.Skipped++
+			continue
+		}
+
+		b.importLine(headers, settings, line)
+		if lineNo-state.Lines >= importBatchLines {
+			state.Lines = lineNo
+			state.UpdatedAt = time.Now()
+			if err := b.storage.Imports().SaveImport(*state); err != nil {
+				return err
+			}
+		}
+	}
+
+	state.Lines = lineNo
+	state.Done = true
+	state.UpdatedAt = time.Now()
+	return b.storage.Imports().SaveImport(*state)
+}
+
+func (b *backfill) importLine(headers *logparser.HeaderParser, settings sources.Settings, line string) {
+	rec, err := headers.Split(strings.TrimRight(line, "\r\n"))
+	if err != nil {
+		b.report.Ignored++
+		return
+	}
+
+	event, err := settings.Parse(rec)
+	if err != nil {
+		b.report.Ignored++
+		return
+	}
+
+	if event.Type == logparser.EventAuth {
+		if err = b.geo.Enrich(event.Auth); err != nil {
+			b.report.Failed++
+			return
+		}
+	}
+
+	msg, err := workers.StoreEvent(b.storage, b.cfg, event)
+	switch {
+	case err != nil:
+		b.report.Failed++
+	case msg == nil:
+		b.report.Ignored++
+	default:
+		b.report.Imported++
+	}
+}
+
+func logFileID(path string) (string, error) {
+	file, err := sources.OpenLog(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	return sources.FileID(file)
+}
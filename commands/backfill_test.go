@@ -0,0 +1,171 @@
+package commands
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sirupsen/logrus"
+)
+
+func writeLog(t *testing.T, path, data string, gz bool) {
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if !gz {
+		if _, err := file.WriteString(data); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	w := gzip.NewWriter(file)
+	if _, err := w.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "auth.log")
+	writeLog(t, logPath+".2.gz", `Dec 30 10:00:00 teamo sshd[1]: Accepted publickey for sheb from 10.0.0.1 port 1000 ssh2
+Dec 30 10:00:05 teamo CRON[2]: pam_unix(cron:session): session opened for user root by (uid=0)
+`, true)
+	writeLog(t, logPath+".1", `Jan  2 10:00:00 teamo sshd[3]: Accepted publickey for sheb from 10.0.0.1 port 1001 ssh2
+Jan  2 10:00:01 teamo sshd[4]: Failed password for root from 10.0.0.9 port 1002 ssh2
+`, false)
+	writeLog(t, logPath, `Jan  3 10:00:00 teamo sshd[5]: Accepted publickey for sheb from 10.0.0.1 port 1003 ssh2
+`, false)
+
+	// the year is inferred from the file modification time
+	mtime := time.Date(2020, 1, 2, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(logPath+".2.gz", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(logPath+".1", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := db.NewStorage(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	cfg := config.Config{
+		IgnoreFails: true,
+		Location:    time.UTC,
+		Sources:     []config.SourceConfig{{Name: "auth", Type: config.SourceFile, Path: logPath}},
+	}
+
+	report, err := Backfill(cfg, storage, logrus.NewEntry(logger))
+	if err != nil {
+		t.Fatalf("Backfill() error = %v", err)
+	}
+	want := BackfillReport{Files: 2, Lines: 4, Imported: 2, Ignored: 2}
+	if report != want {
+		t.Errorf("Backfill() report = %+v, want %+v", report, want)
+	}
+
+	sessions, err := storage.Auth().GetUserSessions("sheb")
+	if err != nil || len(sessions) != 1 {
+		t.Fatalf("GetUserSessions() = %v, %v", sessions, err)
+	}
+	if got := sessions[0].AuthMethods["publickey"]; got != 2 {
+		t.Errorf("session publickey logins = %d, want 2", got)
+	}
+	if got := *sessions[0].FirstLogInTime; !got.Equal(time.Date(2019, 12, 30, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("session first login = %v, want 2019-12-30 10:00:00", got)
+	}
+
+	// the repeated run skips the imported files, even after the rotation
+	if err := os.Rename(logPath+".2.gz", logPath+".3.gz"); err != nil {
+		t.Fatal(err)
+	}
+	report, err = Backfill(cfg, storage, logrus.NewEntry(logger))
+	if err != nil {
+		t.Fatalf("Backfill() error = %v", err)
+	}
+	want = BackfillReport{SkippedFiles: 2}
+	if report != want {
+		t.Errorf("Backfill() repeated report = %+v, want %+v", report, want)
+	}
+}
+
+type countingImports struct {
+	db.ImportStorage
+	saved []db.ImportState
+}
+
+func (c *countingImports) SaveImport(state db.ImportState) error {
+	c.saved = append(c.saved, state)
+	return c.ImportStorage.SaveImport(state)
+}
+
+type countingStorage struct {
+	db.StorageI
+	imports *countingImports
+}
+
+func (c countingStorage) Imports() db.ImportStorage {
+	return c.imports
+}
+
+func TestBackfill_batches(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logPath := filepath.Join(dir, "auth.log")
+	var data strings.Builder
+	for i := 0; i < 2*importBatchLines+500; i++ {
+		data.WriteString("Jan  2 10:00:05 teamo CRON[2]: pam_unix(cron:session): session closed for user root\n")
+	}
+	data.WriteString("Jan  2 10:00:00 teamo sshd[3]: Accepted publickey for sheb from 10.0.0.1 port 1001 ssh2\n")
+	writeLog(t, logPath+".1", data.String(), false)
+	writeLog(t, logPath, "", false)
+
+	memory := db.NewMemoryStorage()
+	storage := countingStorage{StorageI: memory, imports: &countingImports{ImportStorage: memory.Imports()}}
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	cfg := config.Config{
+		Location: time.UTC,
+		Sources:  []config.SourceConfig{{Name: "auth", Type: config.SourceFile, Path: logPath}},
+	}
+
+	report, err := Backfill(cfg, storage, logrus.NewEntry(logger))
+	if err != nil {
+		t.Fatalf("Backfill() error = %v", err)
+	}
+	if report.Lines != 2*importBatchLines+501 || report.Imported != 1 {
+		t.Errorf("Backfill() report = %+v", report)
+	}
+
+	// the progress is saved once per batch and at the end of the file
+	saved := storage.imports.saved
+	if len(saved) != 3 || saved[0].Lines != importBatchLines || saved[1].Lines != 2*importBatchLines ||
+		saved[2].Lines != 2*importBatchLines+501 || !saved[2].Done {
+		t.Errorf("SaveImport() calls = %+v", saved)
+	}
+}
@@ -0,0 +1,217 @@
+package commands
+
+import (
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/logparser"
+	"github.com/sheb-gregor/uwatch/sources"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	pathToWtmp = "/var/log/wtmp"
+	// crossCheckSkew is the allowed delay between the sshd log line and the login record.
+	crossCheckSkew = time.Minute
+)
+
+// CrossCheckReport compares the wtmp logins with the sessions stored from the sshd logs.
+type CrossCheckReport struct {
+	Files  int `json:"files"`
+	Logins int `json:"logins"`
+	// Confirmed logins have their own accepted sshd authentication from the same address.
+	Confirmed int `json:"confirmed"`
+	// Unconfirmed logins are missing in the auth logs, which might be rotated away or edited.
+	Unconfirmed []db.AuthInfo `json:"unconfirmed"`
+	// LastlogMismatches are the users whose last login is missing in wtmp.
+	LastlogMismatches []LastlogMismatch `json:"lastlog_mismatches"`
+}
+
+type LastlogMismatch struct {
+	Username  string    `json:"username"`
+	Lastlog   time.Time `json:"lastlog"`
+	LastLogin time.Time `json:"last_login"`
+}
+
+// lookupUID returns the uid of the user for the lastlog lookup.
+var lookupUID = func(username string) (uint32, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return 0, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	return uint32(uid), err
+}
+
+// CrossCheck reads the wtmp files of the utmp sources, /var/log/wtmp by default, with the rotated
+// copies and reports the remote logins which the sshd parser has not seen. The last logins of the users
+// are compared with the lastlog file in the same directory to spot the removed wtmp records.
+func CrossCheck(cfg config.Config, storage db.StorageI, logger *logrus.Entry) (CrossCheckReport, error) {
+	report := CrossCheckReport{}
+
+	paths := []string{}
+	for _, srcCfg := range cfg.Sources {
+		if srcCfg.Type == config.SourceUtmp && logparser.UtmpKind(srcCfg.Format) == logparser.UtmpWtmp {
+			paths = append(paths, srcCfg.Path)
+		}
+	}
+	if len(paths) == 0 {
+		paths = append(paths, pathToWtmp)
+	}
+
+	for _, path := range paths {
+		c := &crossCheck{
+			storage:    storage,
+			report:     &report,
+			lastLogins: map[string]time.Time{},
+			used:       map[string]bool{},
+		}
+
+		files, err := sources.RotatedFiles(path)
+		if err != nil {
+			return report, err
+		}
+
+		for _, file := range append(files, path) {
+			if err := c.checkFile(file); err != nil {
+				logger.WithField("file", file).WithError(err).Error("failed to check file")
+				return report, err
+			}
+		}
+
+		lastlogPath := filepath.Join(filepath.Dir(path), "lastlog")
+		if err := c.checkLastlog(lastlogPath); err != nil {
+			logger.WithField("file", lastlogPath).WithError(err).Error("failed to check lastlog")
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+type crossCheck struct {
+	storage db.StorageI
+	report  *CrossCheckReport
+	// since is the time of the first record, older logins are not covered by the files
+	since      time.Time
+	lastLogins map[string]time.Time
+	// used are the IDs of the accepted events which confirmed the logins
+	used map[string]bool
+}
+
+func (c *crossCheck) checkFile(path string) error {
+	file, err := sources.OpenLog(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	c.report.Files++
+	reader := logparser.NewUtmpReader(file)
+	sessions := logparser.NewUtmpSessions(logparser.UtmpWtmp)
+	for {
+		rec, err := reader.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if c.since.IsZero() || rec.Time.Before(c.since) {
+			c.since = rec.Time
+		}
+		if rec.Type == logparser.UtmpUserProcess && rec.Time.After(c.lastLogins[rec.User]) {
+			c.lastLogins[rec.User] = rec.Time
+		}
+
+		event, err := sessions.Event(rec)
+		if err != nil || event.Auth.Status != db.AuthSessionOpened {
+			continue
+		}
+
+		c.report.Logins++
+		confirmed, err := c.confirmed(*event.Auth)
+		if err != nil {
+			return err
+		}
+		if confirmed {
+			c.report.Confirmed++
+			continue
+		}
+		c.report.Unconfirmed = append(c.report.Unconfirmed, *event.Auth)
+	}
+}
+
+// confirmed reports whether the sshd accepted the user from the address within the skew of the login,
+// every accepted event confirms one login only.
+func (c *crossCheck) confirmed(login db.AuthInfo) (bool, error) {
+	page, err := c.storage.Auth().Events(db.EventFilter{
+		Username:   login.Username,
+		RemoteAddr: login.RemoteAddr,
+		Statuses:   []db.AuthStatus{db.AuthAccepted},
+		From:       login.Date.Add(-crossCheckSkew),
+		To:         login.Date.Add(crossCheckSkew),
+		Limit:      db.MaxEventsLimit,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	for _, event := range page.Events {
+		if c.used[event.ID] {
+			continue
+		}
+		c.used[event.ID] = true
+		return true, nil
+	}
+	return false, nil
+}
+
+func (c *crossCheck) checkLastlog(path string) error {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	usernames := make([]string, 0, len(c.lastLogins))
+	for username := range c.lastLogins {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	for _, username := range usernames {
+		lastLogin := c.lastLogins[username]
+		uid, err := lookupUID(username)
+		if err != nil {
+			// the user is removed
+			continue
+		}
+
+		rec, err := logparser.ReadLastlog(file, uid)
+		if err != nil {
+			return err
+		}
+		if rec == nil || rec.Time.Before(c.since) {
+			continue
+		}
+
+		// lastlog has the precision of a second
+		if rec.Time.After(lastLogin.Add(time.Second)) {
+			c.report.LastlogMismatches = append(c.report.LastlogMismatches,
+				LastlogMismatch{Username: username, Lastlog: rec.Time, LastLogin: lastLogin})
+		}
+	}
+	return nil
+}
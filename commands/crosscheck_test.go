@@ -0,0 +1,151 @@
+package commands
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/logparser"
+	"github.com/sirupsen/logrus"
+)
+
+func writeUtmp(t *testing.T, path string, records ...logparser.UtmpRecord) {
+	var data []byte
+	for _, rec := range records {
+		data = append(data, logparser.EncodeUtmp(rec)...)
+	}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCrossCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	known, unknown := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	login := func(user string, addr net.IP, at time.Duration) logparser.UtmpRecord {
+		return logparser.UtmpRecord{Type: logparser.UtmpUserProcess, Line: "pts/0", User: user,
+			Host: addr.String(), Addr: addr, Time: start.Add(at)}
+	}
+
+	wtmpPath := filepath.Join(dir, "wtmp")
+	writeUtmp(t, wtmpPath+".1", login("sheb", known, 0))
+	writeUtmp(t, wtmpPath,
+		login("sheb", known, time.Hour),
+		login("sheb", unknown, 2*time.Hour),
+		// console login
+		logparser.UtmpRecord{Type: logparser.UtmpUserProcess, Line: "tty1", User: "root", Time: start.Add(3 * time.Hour)},
+	)
+
+	// lastlog of sheb (uid 1) is newer than wtmp, root (uid 0) matches
+	lastlog := make([]byte, 2*logparser.LastlogRecordSize)
+	binary.LittleEndian.PutUint32(lastlog, uint32(start.Add(3*time.Hour).Unix()))
+	binary.LittleEndian.PutUint32(lastlog[logparser.LastlogRecordSize:], uint32(start.Add(4*time.Hour).Unix()))
+	if err := ioutil.WriteFile(filepath.Join(dir, "lastlog"), lastlog, 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(lookup func(string) (uint32, error)) { lookupUID = lookup }(lookupUID)
+	lookupUID = func(username string) (uint32, error) {
+		switch username {
+		case "root":
+			return 0, nil
+		case "sheb":
+			return 1, nil
+		}
+		return 0, fmt.Errorf("unknown user %s", username)
+	}
+
+	storage, err := db.NewStorage(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, at := range []time.Duration{-time.Second, time.Hour} {
+		_, err = storage.Auth().UpsetAuthEvent(db.AuthInfo{Status: db.AuthAccepted, Username: "sheb",
+			AuthMethod: "publickey", RemoteAddr: known, Date: start.Add(at)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the session created by the wtmp source itself is not a confirmation
+	_, err = storage.Auth().UpsetAuthEvent(db.AuthInfo{Status: db.AuthSessionOpened, Username: "sheb",
+		RemoteAddr: unknown, Date: start.Add(2 * time.Hour)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	cfg := config.Config{Sources: []config.SourceConfig{
+		{Type: config.SourceUtmp, Path: wtmpPath, Format: string(logparser.UtmpWtmp)}}}
+
+	report, err := CrossCheck(cfg, storage, logrus.NewEntry(logger))
+	if err != nil {
+		t.Fatalf("CrossCheck() error = %v", err)
+	}
+
+	if report.Files != 2 || report.Logins != 3 || report.Confirmed != 2 {
+		t.Errorf("CrossCheck() report = %+v", report)
+	}
+	if len(report.Unconfirmed) != 1 || !report.Unconfirmed[0].RemoteAddr.Equal(unknown) {
+		t.Errorf("CrossCheck() unconfirmed = %+v", report.Unconfirmed)
+	}
+	if len(report.LastlogMismatches) != 1 || report.LastlogMismatches[0].Username != "sheb" {
+		t.Errorf("CrossCheck() lastlog mismatches = %+v", report.LastlogMismatches)
+	}
+}
+
+func TestCrossCheck_sameAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	addr := net.ParseIP("10.0.0.1")
+	login := func(at time.Duration) logparser.UtmpRecord {
+		return logparser.UtmpRecord{Type: logparser.UtmpUserProcess, Line: "pts/0", User: "sheb",
+			Host: addr.String(), Addr: addr, Time: start.Add(at)}
+	}
+	wtmpPath := filepath.Join(dir, "wtmp")
+	writeUtmp(t, wtmpPath, login(0), login(10*time.Second), login(24*time.Hour))
+
+	storage, err := db.NewStorage(filepath.Join(dir, "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+	// the old login is in auth.log, the later ones from the same address are removed from it
+	_, err = storage.Auth().UpsetAuthEvent(db.AuthInfo{Status: db.AuthAccepted, Username: "sheb",
+		AuthMethod: "publickey", RemoteAddr: addr, Date: start})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	cfg := config.Config{Sources: []config.SourceConfig{
+		{Type: config.SourceUtmp, Path: wtmpPath, Format: string(logparser.UtmpWtmp)}}}
+
+	report, err := CrossCheck(cfg, storage, logrus.NewEntry(logger))
+	if err != nil {
+		t.Fatalf("CrossCheck() error = %v", err)
+	}
+	if report.Logins != 3 || report.Confirmed != 1 || len(report.Unconfirmed) != 2 ||
+		!report.Unconfirmed[0].Date.Equal(start.Add(10*time.Second)) ||
+		!report.Unconfirmed[1].Date.Equal(start.Add(24*time.Hour)) {
+		t.Errorf("CrossCheck() report = %+v", report)
+	}
+}
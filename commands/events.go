@@ -0,0 +1,90 @@
+package commands
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+// timeLayouts of the time range, the ones without zone are read in the config location.
+var timeLayouts = []string{"2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// Events writes the page of the event log matching the args as JSON, like
+// `uwatch events -user sheb -status Accepted -from "2020-01-07 14:00" -to "2020-01-07 16:00"`.
+func Events(args []string, cfg config.Config, storage db.StorageI, out io.Writer) error {
+	flags := flag.NewFlagSet("events", flag.ContinueOnError)
+	user := flags.String("user", "", "username")
+	ip := flags.String("ip", "", "remote address")
+	statuses := flags.String("status", "", "comma-separated statuses, like Accepted,Failed")
+	from := flags.String("from", "", "start of the time range, RFC3339 or \"2006-01-02 15:04\"")
+	to := flags.String("to", "", "end of the time range, exclusive")
+	limit := flags.Int("limit", db.DefaultEventsLimit, "page size")
+	cursor := flags.String("cursor", "", "next value of the previous page")
+	desc := flags.Bool("desc", false, "newest events first")
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	filter := db.EventFilter{
+		Username:   *user,
+		Cursor:     *cursor,
+		Limit:      *limit,
+		Descending: *desc,
+	}
+
+	if *ip != "" {
+		if filter.RemoteAddr = net.ParseIP(*ip); filter.RemoteAddr == nil {
+			return fmt.Errorf("invalid ip %q", *ip)
+		}
+	}
+	if *statuses != "" {
+		for _, status := range strings.Split(*statuses, ",") {
+			filter.Statuses = append(filter.Statuses, db.AuthStatus(strings.TrimSpace(status)))
+		}
+	}
+
+	var err error
+	if filter.From, err = parseTime(*from, cfg.Location); err != nil {
+		return err
+	}
+	if filter.To, err = parseTime(*to, cfg.Location); err != nil {
+		return err
+	}
+
+	page, err := storage.Auth().Events(filter)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(page)
+}
+
+func parseTime(value string, location *time.Location) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if location == nil {
+		location = time.Local
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.ParseInLocation(layout, value, location); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q", value)
+}
@@ -0,0 +1,134 @@
+package commands
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+// maxRecordSize is the longest line of the export, the records with many labels are long.
+const maxRecordSize = 1 << 20
+
+// maxInvalidRecords limits the errors listed by the failed validation.
+const maxInvalidRecords = 20
+
+// ExportReport counts the exported records by type.
+type ExportReport struct {
+	Records map[db.RecordType]int `json:"records"`
+}
+
+// Export writes the storage records as JSONL, one typed record per line.
+func Export(storage db.StorageI, out io.Writer) (ExportReport, error) {
+	report := ExportReport{Records: map[db.RecordType]int{}}
+	w := bufio.NewWriter(out)
+	encoder := json.NewEncoder(w)
+
+	err := db.Export(storage, func(record db.Record) error {
+		report.Records[record.Type]++
+		return encoder.Encode(record)
+	})
+	if err != nil {
+		return report, err
+	}
+	return report, w.Flush()
+}
+
+// ImportConflict is the record skipped because the record with the same ID is stored.
+type ImportConflict struct {
+	Line int           `json:"line"`
+	Type db.RecordType `json:"type"`
+	User string        `json:"user,omitempty"`
+	ID   string        `json:"id"`
+}
+
+// InvalidRecordsError lists the lines failed the validation, nothing is imported then.
+type InvalidRecordsError struct {
+	Count  int
+	Errors []string
+}
+
+func (e *InvalidRecordsError) Error() string {
+	return fmt.Sprintf("%d invalid records: %s", e.Count, strings.Join(e.Errors, "; "))
+}
+
+// ImportReport counts the imported and the conflicting records by type.
+type ImportReport struct {
+	Imported  map[db.RecordType]int `json:"imported"`
+	Conflicts []ImportConflict      `json:"conflicts"`
+}
+
+// Import loads the JSONL export into the storage. All records are validated first,
+// so the invalid file leaves the storage untouched. The stored records are kept,
+// the imported records with the same IDs are reported as the conflicts.
+func Import(path string, storage db.StorageI) (ImportReport, error) {
+	report := ImportReport{Imported: map[db.RecordType]int{}}
+
+	invalid := &InvalidRecordsError{}
+	err := readRecords(path, func(line int, record db.Record, err error) error {
+		if err == nil {
+			err = record.Validate()
+		}
+		if err == nil && (line == 1) != (record.Type == db.RecordHeader) {
+			err = fmt.Errorf("the header must be the first record, got %s", record.Type)
+		}
+		if err != nil {
+			invalid.Count++
+			if len(invalid.Errors) < maxInvalidRecords {
+				invalid.Errors = append(invalid.Errors, fmt.Sprintf("line %d: %s", line, err))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+	if invalid.Count > 0 {
+		return report, invalid
+	}
+
+	err = readRecords(path, func(line int, record db.Record, _ error) error {
+		ok, err := db.Import(storage, record)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", line, err)
+		}
+		if !ok {
+			report.Conflicts = append(report.Conflicts,
+				ImportConflict{Line: line, Type: record.Type, User: record.User, ID: record.ID()})
+			return nil
+		}
+		if record.Type != db.RecordHeader {
+			report.Imported[record.Type]++
+		}
+		return nil
+	})
+	return report, err
+}
+
+// readRecords decodes the lines of the file, the empty lines are skipped.
+func readRecords(path string, fn func(line int, record db.Record, err error) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+
+		var record db.Record
+		err := json.Unmarshal(scanner.Bytes(), &record)
+		if err = fn(line, record, err); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,145 @@
+package commands
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+func TestExportImport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	start := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	source := db.NewMemoryStorage()
+	events := []db.AuthInfo{
+		{Status: db.AuthFailed, Username: "sheb", AuthMethod: "password", RemoteAddr: net.ParseIP("10.0.0.1"), Date: start},
+		{Status: db.AuthAccepted, Username: "sheb", AuthMethod: "publickey", RemoteAddr: net.ParseIP("10.0.0.1"),
+			PublicKey: &db.PublicKey{Type: "ED25519", Fingerprint: "SHA256:abc"}, PID: 100, Date: start.Add(time.Minute)},
+		{Status: db.AuthInvalidUser, Username: "admin", RemoteAddr: net.ParseIP("10.0.0.2"), Date: start.Add(time.Hour)},
+	}
+	for _, event := range events {
+		if _, err := source.Auth().UpsetAuthEvent(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := source.Auth().AddEscalation(db.Escalation{Tool: "sudo", Status: db.EscalationGranted,
+		Username: "sheb", Date: start.Add(2 * time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.TG().AddUser("sheb", 42); err != nil {
+		t.Fatal(err)
+	}
+
+	out := &bytes.Buffer{}
+	exported, err := Export(source, out)
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	wantExported := map[db.RecordType]int{db.RecordHeader: 1, db.RecordTGUser: 1, db.RecordSession: 2,
+		db.RecordKey: 1, db.RecordConnection: 1, db.RecordEscalation: 1, db.RecordEvent: 3}
+	if !reflect.DeepEqual(exported.Records, wantExported) {
+		t.Errorf("Export() got = %v, want %v", exported.Records, wantExported)
+	}
+
+	path := filepath.Join(dir, "export.jsonl")
+	if err := ioutil.WriteFile(path, out.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	target := db.NewMemoryStorage()
+	// the stored escalation conflicts with the imported one
+	if _, err := target.Auth().AddEscalation(db.Escalation{Tool: "su", Status: db.EscalationDenied,
+		Username: "sheb", Date: start}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Import(path, target)
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+	delete(wantExported, db.RecordHeader)
+	delete(wantExported, db.RecordEscalation)
+	if !reflect.DeepEqual(report.Imported, wantExported) {
+		t.Errorf("Import() imported = %v, want %v", report.Imported, wantExported)
+	}
+	wantConflicts := []ImportConflict{{Line: 7, Type: db.RecordEscalation, User: "sheb", ID: "1"}}
+	if !reflect.DeepEqual(report.Conflicts, wantConflicts) {
+		t.Errorf("Import() conflicts = %+v, want %+v", report.Conflicts, wantConflicts)
+	}
+
+	sessions, _ := target.Auth().GetUserSessions("sheb")
+	if len(sessions) != 1 || sessions[0].FailsCount != 1 || sessions[0].AuthMethods["publickey"] != 1 {
+		t.Errorf("imported sessions got = %+v", sessions)
+	}
+	if info, _ := target.TG().GetUser("sheb"); info.ChatID != 42 {
+		t.Errorf("imported tg user got = %+v", info)
+	}
+
+	// the repeated import conflicts on every record
+	report, err = Import(path, target)
+	if err != nil || len(report.Imported) != 0 || len(report.Conflicts) != 9 {
+		t.Errorf("Import() repeated got = %+v, error = %v", report, err)
+	}
+}
+
+func TestImport_invalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		lines   []string
+		wantErr string
+	}{
+		{
+			lines:   []string{`{"type":"tg_user","user":"sheb","tg_user":{"chat_id":42}}`},
+			wantErr: "line 1: the header must be the first record, got tg_user",
+		},
+		{
+			lines: []string{
+				`{"type":"header","header":{"version":1}}`,
+				`{"type":"tg_user","user":"sheb","tg_user":{"chat_id":42}}`,
+				`{"type":"session","user":"sheb"`,
+				``,
+				`{"type":"key","user":"sheb","key":{"type":"ED25519"}}`,
+			},
+			wantErr: "2 invalid records: line 3: unexpected end of JSON input; line 5: key record has no fingerprint",
+		},
+		{
+			lines:   []string{`{"type":"header","header":{"version":2}}`},
+			wantErr: "line 1: unsupported export version 2",
+		},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(dir, "export.jsonl")
+		if err := ioutil.WriteFile(path, []byte(strings.Join(tt.lines, "\n")), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		storage := db.NewMemoryStorage()
+		report, err := Import(path, storage)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("#%d Import() error = %v, want %q", i+1, err, tt.wantErr)
+		}
+		if len(report.Imported) != 0 {
+			t.Errorf("#%d Import() imported = %v, want none", i+1, report.Imported)
+		}
+		if users, _ := storage.TG().GetUsers(); len(users) != 0 {
+			t.Errorf("#%d tg users got = %v, want none", i+1, users)
+		}
+	}
+}
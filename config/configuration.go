@@ -2,29 +2,276 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/lancer-kit/noble"
 )
 
 type Config struct {
-	DB       string `json:"db"`
+	// DB is the storage directory, ":memory:" keeps the records in memory for the dry runs.
+	DB string `json:"db"`
+	// Storage is the backend of the storage: "bolt", the default, or "sqlite".
+	Storage  string `json:"storage,omitempty"`
 	LogLevel string `json:"log_level"`
 
-	AuthLog     string    `json:"auth_log"`
+	Sources []SourceConfig `json:"sources,omitempty"`
+	// AuthLog and Journal are shortcuts for the single source, used when Sources is empty.
+	AuthLog string         `json:"auth_log"`
+	Journal *JournalConfig `json:"journal,omitempty"`
+
 	IgnoreFails bool      `json:"ignore_fails"`
 	TG          *TGConfig `json:"tg,omitempty"`
+
+	// Retention of the stored records, nothing is removed if nil.
+	Retention *RetentionConfig `json:"retention,omitempty"`
+	// GeoIP enriches the events with the location of the address, nothing is looked up if nil.
+	GeoIP *GeoIPConfig `json:"geoip,omitempty"`
+	// Detection of the attacks in the parsed events, nothing is detected if nil.
+	Detection *DetectionConfig `json:"detection,omitempty"`
+	// Backup of the database files, the running uwatch makes them on schedule if set.
+	Backup *BackupConfig `json:"backup,omitempty"`
+
+	// TimeZone of the log timestamps without zone offset, the system one by default.
+	TimeZone string         `json:"time_zone,omitempty"`
+	Location *time.Location `json:"-"`
+}
+
+type SourceType string
+
+const (
+	// SourceFile follows the syslog file.
+	SourceFile SourceType = "file"
+	// SourceJournal reads the journalctl output, e.g. `journalctl -f -o export -u ssh.service | uwatch`.
+	SourceJournal SourceType = "journal"
+	// SourceStdin reads syslog lines from stdin.
+	SourceStdin SourceType = "stdin"
+	// SourceSyslog receives RFC3164 and RFC5424 messages from rsyslog or syslog-ng.
+	SourceSyslog SourceType = "syslog"
+	// SourceUtmp follows the binary login records of /var/log/wtmp or /var/log/btmp.
+	// The btmp records repeat the sshd failures of auth.log, it is for the hosts without the sshd logs.
+	SourceUtmp SourceType = "utmp"
+)
+
+// SourceConfig describes one log source of the Watcher.
+type SourceConfig struct {
+	// Name identifies the source in events, the path by default.
+	Name string     `json:"name"`
+	Type SourceType `json:"type"`
+	// Path to the syslog file, or to the file or named pipe with the journalctl output, "-" for stdin.
+	Path string `json:"path"`
+	// Listen is the syslog receiver address: "udp://:514", "tcp://:514",
+	// "unix:///run/uwatch.sock" or "unixgram:///run/uwatch.sock".
+	Listen string `json:"listen,omitempty"`
+	// Format of the journalctl output: "export" or "json",
+	// or of the utmp file: "wtmp" or "btmp", guessed by the file name.
+	Format string `json:"format,omitempty"`
+	// Units filters journal entries by the _SYSTEMD_UNIT field, all entries pass if empty.
+	Units []string `json:"units,omitempty"`
+	// Parsers applied to the source messages, all registered by default.
+	Parsers []string `json:"parsers,omitempty"`
+	// Labels are attached to every event of the source.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// JournalConfig is the single journal source, e.g. `journalctl -f -o export -u ssh.service | uwatch`.
+type JournalConfig struct {
+	// Path to the file or named pipe with the journalctl output, "-" for stdin.
+	Path string `json:"path"`
+	// Format is "export" or "json".
+	Format string `json:"format"`
+	// Units filters entries by the _SYSTEMD_UNIT field, all entries pass if empty.
+	Units []string `json:"units"`
+}
+
+type RetentionConfig struct {
+	// Failed is the retention of the failed attempts, like "30d", zero keeps them forever.
+	Failed Duration `json:"failed"`
+	// Accepted is the retention of the logins, connections and escalations, like "365d".
+	Accepted Duration `json:"accepted"`
+	// Interval between the pruning runs, a day by default.
+	Interval Duration `json:"interval"`
+	// Compact rewrites the bolt files at the start to return the freed pages to the file system.
+	Compact bool `json:"compact"`
 }
+
+// GeoIPConfig is the local MaxMind format databases, there are no network lookups.
+type GeoIPConfig struct {
+	// City is the path of the GeoLite2-City or GeoLite2-Country database, optional.
+	City string `json:"city"`
+	// ASN is the path of the GeoLite2-ASN database, optional.
+	ASN string `json:"asn"`
+}
+
+type DetectionConfig struct {
+	// BruteForce alerts on the failed attempts, the detector is off if nil.
+	BruteForce *BruteForceConfig `json:"brute_force,omitempty"`
+	// ImpossibleTravel alerts on the consecutive logins too far apart, it needs the GeoIP city database.
+	ImpossibleTravel *ImpossibleTravelConfig `json:"impossible_travel,omitempty"`
+	// Rules alert on the events matched by the expressions, see the rules package for the language.
+	// fails_in() counts the failures seen since the start, so it works with ignore_fails,
+	// the other history functions look up the stored events.
+	Rules []RuleConfig `json:"rules,omitempty"`
+}
+
+type BruteForceConfig struct {
+	// Window of the sliding counters of the failed attempts, ten minutes by default.
+	Window Duration `json:"window"`
+	// PerIP, PerUser and PerSubnet are the thresholds of the failed attempts in the window
+	// from the address, for the username and from the /24 or /64 network, zero disables the counter.
+	PerIP     int `json:"per_ip"`
+	PerUser   int `json:"per_user"`
+	PerSubnet int `json:"per_subnet"`
+	// Cooldown is the quiet time after which the same counter alerts again, an hour by default.
+	Cooldown Duration `json:"cooldown"`
+}
+
+type ImpossibleTravelConfig struct {
+	// MaxSpeed is the fastest possible travel in km/h, 1000 by default.
+	MaxSpeed int `json:"max_speed"`
+}
+
+type RuleConfig struct {
+	// Name of the rule in the alerts, it is unique.
+	Name string `json:"name"`
+	// When is the expression, like `status == "Accepted" && user == "root"`.
+	When string `json:"when"`
+	// Severity is info, warning or critical, warning by default.
+	Severity Severity `json:"severity"`
+	// Channels are the targets of the alerts, tg by default.
+	Channels []Channel `json:"channels"`
+}
+
+// Severity of the alert, the info alerts are sent silently.
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Channel is the target of the alerts, all alerts are logged anyway.
+type Channel string
+
+const (
+	ChannelTG  Channel = "tg"
+	ChannelLog Channel = "log"
+)
+
+type BackupConfig struct {
+	// Dir keeps the backups, every backup is the subdirectory named by its UTC time.
+	Dir string `json:"dir"`
+	// Gzip compresses the copies of the database files.
+	Gzip bool `json:"gzip"`
+	// Keep is the number of the kept backups, the older ones are removed, zero keeps all.
+	Keep int `json:"keep"`
+	// Interval between the scheduled backups, a day by default.
+	Interval Duration `json:"interval"`
+}
+
 type TGConfig struct {
 	APIToken     noble.Secret        `json:"api_token"`
 	AllowedUsers map[string]struct{} `json:"allowed_users"`
+	// RoutineLogins is the delivery of the logins without anything first seen:
+	// "silent", the default, sends them without the notification sound, "notify" as the new ones, "skip" drops them.
+	RoutineLogins RoutineDelivery `json:"routine_logins,omitempty"`
+	// Sessions is the rule of the session notifications, see the rules package for the language,
+	// the accepted logins are sent by default.
+	Sessions string `json:"sessions,omitempty"`
 }
 
+type RoutineDelivery string
+
 const (
-	pathToLog = "/var/log/auth.log"
+	RoutineSilent RoutineDelivery = "silent"
+	RoutineNotify RoutineDelivery = "notify"
+	RoutineSkip   RoutineDelivery = "skip"
 )
 
+const (
+	pathToLog     = "/var/log/auth.log"
+	journalStdin  = "-"
+	journalFormat = "export"
+	syslogListen  = "udp://:514"
+	pathToWtmp    = "/var/log/wtmp"
+	tgSessions    = `status == "Accepted"`
+
+	retentionInterval = 24 * time.Hour
+	backupInterval    = 24 * time.Hour
+
+	bruteForceWindow   = 10 * time.Minute
+	bruteForceCooldown = time.Hour
+	travelMaxSpeed     = 1000
+)
+
+var journalUnits = []string{"ssh.service", "sshd.service"}
+
+func (config Config) defaultSource() SourceConfig {
+	if config.Journal != nil {
+		return SourceConfig{
+			Type:   SourceJournal,
+			Path:   config.Journal.Path,
+			Format: config.Journal.Format,
+			Units:  config.Journal.Units,
+		}
+	}
+
+	return SourceConfig{Type: SourceFile, Path: config.AuthLog}
+}
+
+func (src *SourceConfig) setDefaults() error {
+	switch src.Type {
+	case "", SourceFile:
+		src.Type = SourceFile
+		if src.Path == "" {
+			src.Path = pathToLog
+		}
+	case SourceJournal:
+		if src.Path == "" {
+			src.Path = journalStdin
+		}
+		if src.Format == "" {
+			src.Format = journalFormat
+		}
+		if src.Units == nil {
+			src.Units = journalUnits
+		}
+	case SourceStdin:
+		src.Path = journalStdin
+	case SourceSyslog:
+		if src.Listen == "" {
+			src.Listen = syslogListen
+		}
+		src.Path = src.Listen
+	case SourceUtmp:
+		if src.Path == "" {
+			src.Path = pathToWtmp
+		}
+		if src.Format == "" {
+			src.Format = "wtmp"
+			if strings.HasPrefix(filepath.Base(src.Path), "btmp") {
+				src.Format = "btmp"
+			}
+		}
+		if src.Format != "wtmp" && src.Format != "btmp" {
+			return fmt.Errorf("unknown utmp format %q", src.Format)
+		}
+	default:
+		return fmt.Errorf("unknown source type %q", src.Type)
+	}
+
+	if src.Name == "" {
+		src.Name = string(src.Type) + ":" + src.Path
+	}
+	return nil
+}
+
 func GetConfig(configPath string) (config Config) {
 	cfgFile, err := os.OpenFile(configPath, os.O_RDONLY, 0644)
 	if err != nil {
@@ -37,8 +284,75 @@ func GetConfig(configPath string) (config Config) {
 		log.Fatal("Unable to read config:", err)
 		return
 	}
-	if config.AuthLog == "" {
-		config.AuthLog = pathToLog
+	if len(config.Sources) == 0 {
+		config.Sources = []SourceConfig{config.defaultSource()}
+	}
+
+	for i := range config.Sources {
+		if err = config.Sources[i].setDefaults(); err != nil {
+			log.Fatal("Invalid source:", err)
+			return
+		}
+	}
+
+	config.Location = time.Local
+	if config.TimeZone != "" {
+		config.Location, err = time.LoadLocation(config.TimeZone)
+		if err != nil {
+			log.Fatal("Invalid time_zone:", err)
+			return
+		}
+	}
+
+	if config.Retention != nil && config.Retention.Interval.Duration <= 0 {
+		config.Retention.Interval.Duration = retentionInterval
+	}
+
+	if config.Detection != nil && config.Detection.BruteForce != nil {
+		bruteForce := config.Detection.BruteForce
+		if bruteForce.Window.Duration <= 0 {
+			bruteForce.Window.Duration = bruteForceWindow
+		}
+		if bruteForce.Cooldown.Duration <= 0 {
+			bruteForce.Cooldown.Duration = bruteForceCooldown
+		}
+	}
+
+	if config.Detection != nil && config.Detection.ImpossibleTravel != nil {
+		if config.GeoIP == nil || config.GeoIP.City == "" {
+			log.Fatal("Invalid detection: impossible_travel requires the geoip city database")
+			return
+		}
+		if config.Detection.ImpossibleTravel.MaxSpeed <= 0 {
+			config.Detection.ImpossibleTravel.MaxSpeed = travelMaxSpeed
+		}
+	}
+
+	if config.Detection != nil {
+		// the expressions are compiled by the detection at the start
+		names := map[string]bool{}
+		for i := range config.Detection.Rules {
+			if err = config.Detection.Rules[i].setDefaults(); err != nil {
+				log.Fatal("Invalid rule:", err)
+				return
+			}
+			name := config.Detection.Rules[i].Name
+			if names[name] {
+				log.Fatalf("Invalid rule: duplicate name %q", name)
+				return
+			}
+			names[name] = true
+		}
+	}
+
+	if config.Backup != nil {
+		if config.Backup.Dir == "" {
+			log.Fatal("Invalid backup: dir is required")
+			return
+		}
+		if config.Backup.Interval.Duration <= 0 {
+			config.Backup.Interval.Duration = backupInterval
+		}
 	}
 
 	if config.TG != nil {
@@ -47,7 +361,49 @@ func GetConfig(configPath string) (config Config) {
 			log.Fatal("Secret Error:", err)
 			return
 		}
+
+		if config.TG.Sessions == "" {
+			config.TG.Sessions = tgSessions
+		}
+
+		switch config.TG.RoutineLogins {
+		case "":
+			config.TG.RoutineLogins = RoutineSilent
+		case RoutineSilent, RoutineNotify, RoutineSkip:
+		default:
+			log.Fatalf("Invalid tg routine_logins %q", config.TG.RoutineLogins)
+			return
+		}
 	}
 
 	return
 }
+
+func (rule *RuleConfig) setDefaults() error {
+	if rule.Name == "" {
+		return errors.New("name is required")
+	}
+	if rule.When == "" {
+		return fmt.Errorf("%q: when is required", rule.Name)
+	}
+
+	switch rule.Severity {
+	case "":
+		rule.Severity = SeverityWarning
+	case SeverityInfo, SeverityWarning, SeverityCritical:
+	default:
+		return fmt.Errorf("%q: unknown severity %q", rule.Name, rule.Severity)
+	}
+
+	if len(rule.Channels) == 0 {
+		rule.Channels = []Channel{ChannelTG}
+	}
+	for _, channel := range rule.Channels {
+		switch channel {
+		case ChannelTG, ChannelLog:
+		default:
+			return fmt.Errorf("%q: unknown channel %q", rule.Name, channel)
+		}
+	}
+	return nil
+}
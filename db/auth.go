@@ -2,41 +2,215 @@ package db
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
 	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
 
+// ErrSessionNotFound is returned by UpsetAuthEvent for the event without the address
+// and without the earlier login of the user, the event is stored with no session.
+var ErrSessionNotFound = errors.New("session not found")
+
 type AuthStatus string
 
 const (
 	AuthAccepted     AuthStatus = "Accepted"
 	AuthDisconnected AuthStatus = "Disconnected"
 	AuthFailed       AuthStatus = "Failed"
+
+	AuthInvalidUser        AuthStatus = "InvalidUser"
+	AuthMaxAttempts        AuthStatus = "MaxAttemptsExceeded"
+	AuthPreauthClosed      AuthStatus = "PreauthClosed"
+	AuthReceivedDisconnect AuthStatus = "ReceivedDisconnect"
+	AuthProtocolError      AuthStatus = "ProtocolError"
+
+	AuthSessionOpened AuthStatus = "SessionOpened"
+	AuthSessionClosed AuthStatus = "SessionClosed"
 )
 
+// IsFailure reports whether the status is a failed or aborted authentication attempt.
+func (s AuthStatus) IsFailure() bool {
+	switch s {
+	case AuthFailed, AuthInvalidUser, AuthMaxAttempts, AuthPreauthClosed, AuthProtocolError:
+		return true
+	}
+	return false
+}
+
+// UnknownUser is the bucket name for events logged before the username is known.
+const UnknownUser = "<unknown>"
+
 type AuthInfo struct {
 	Status     AuthStatus `json:"status"`
 	Username   string     `json:"username"`
 	AuthMethod string     `json:"auth_method,omitempty"`
-	RemoteAddr string     `json:"remote_addr"`
+	PublicKey  *PublicKey `json:"public_key,omitempty"`
+	RemoteAddr net.IP     `json:"remote_addr"`
+	Port       int        `json:"port,omitempty"`
+	Protocol   string     `json:"protocol,omitempty"`
 	Date       time.Time  `json:"date"`
+	// PID of the sshd process which logged the event.
+	PID int `json:"pid,omitempty"`
+	// TTY is the terminal line of the utmp records, like "pts/0" or "ssh:notty".
+	TTY string `json:"tty,omitempty"`
+	// Host is the server which logged the event.
+	Host string `json:"host,omitempty"`
+	// Labels of the log source.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Geo is the location of the remote address, nil without the GeoIP databases.
+	Geo *GeoInfo `json:"geo,omitempty"`
+}
+
+// GeoInfo is the location and the network owner of the address from the local GeoIP databases.
+type GeoInfo struct {
+	// Country is the ISO 3166 code, like "DE".
+	Country   string  `json:"country,omitempty"`
+	City      string  `json:"city,omitempty"`
+	Latitude  float64 `json:"latitude,omitempty"`
+	Longitude float64 `json:"longitude,omitempty"`
+	// AccuracyRadius of the location in kilometers.
+	AccuracyRadius uint16 `json:"accuracy_radius,omitempty"`
+	ASN            uint   `json:"asn,omitempty"`
+	ASOrg          string `json:"as_org,omitempty"`
+}
+
+// HasLocation reports whether the coordinates are known.
+func (g GeoInfo) HasLocation() bool {
+	return g.Latitude != 0 || g.Longitude != 0
+}
+
+// String returns the location like "Berlin, DE, AS3320 Deutsche Telekom AG".
+func (g GeoInfo) String() string {
+	var parts []string
+	if g.City != "" {
+		parts = append(parts, g.City)
+	}
+	if g.Country != "" {
+		parts = append(parts, g.Country)
+	}
+	if g.ASN != 0 {
+		parts = append(parts, strings.TrimSpace(fmt.Sprintf("AS%d %s", g.ASN, g.ASOrg)))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// PublicKey is the key or certificate used for the publickey authentication.
+type PublicKey struct {
+	Type          string `json:"type"`
+	Fingerprint   string `json:"fingerprint"`
+	CertID        string `json:"cert_id,omitempty"`
+	CertSerial    uint64 `json:"cert_serial,omitempty"`
+	CAType        string `json:"ca_type,omitempty"`
+	CAFingerprint string `json:"ca_fingerprint,omitempty"`
+}
+
+// ID returns the key identity, certificates with the same key are told apart by the cert ID.
+func (k PublicKey) ID() string {
+	if k.CertID == "" {
+		return k.Fingerprint
+	}
+	return k.Fingerprint + " " + k.CertID
+}
+
+// KeyUsage is the history of the public key usage by the user.
+type KeyUsage struct {
+	PublicKey
+	Username       string    `json:"username"`
+	UsesCount      int32     `json:"uses_count"`
+	FirstUsedTime  time.Time `json:"first_used_time"`
+	LastUsedTime   time.Time `json:"last_used_time"`
+	LastRemoteAddr net.IP    `json:"last_remote_addr"`
+}
+
+// bucketUserKeys is the nested bucket of the user bucket with KeyUsage records.
+const bucketUserKeys = "__keys"
+
+// bucketUsers is the top-level bucket with the user buckets,
+// any username of the log stays apart from the service buckets.
+const bucketUsers = "__users"
+
+// findUserBucket returns the bucket of the user, nil if there is none.
+func findUserBucket(tx *bolt.Tx, username []byte) *bolt.Bucket {
+	users := tx.Bucket([]byte(bucketUsers))
+	if users == nil {
+		return nil
+	}
+	return users.Bucket(username)
+}
+
+func createUserBucket(tx *bolt.Tx, username []byte) (*bolt.Bucket, error) {
+	users, err := tx.CreateBucketIfNotExists([]byte(bucketUsers))
+	if err != nil {
+		return nil, err
+	}
+	return users.CreateBucketIfNotExists(username)
+}
+
+// userNames returns the names of the user buckets.
+func userNames(tx *bolt.Tx) (names [][]byte, err error) {
+	users := tx.Bucket([]byte(bucketUsers))
+	if users == nil {
+		return
+	}
+	err = users.ForEach(func(name, _ []byte) error {
+		names = append(names, name)
+		return nil
+	})
+	return
+}
+
+// addrKey returns the storage key of the address in the canonical text form.
+func addrKey(ip net.IP) []byte {
+	if len(ip) == 0 {
+		return nil
+	}
+	return []byte(ip.String())
 }
 
 type Session struct {
-	ID          uint64           `json:"session_id"`
-	Status      AuthStatus       `json:"status"`
-	Username    string           `json:"username"`
-	AuthMethods map[string]int32 `json:"auth_methods,omitempty"`
-	RemoteAddr  string           `json:"remote_addr"`
+	ID          uint64            `json:"session_id"`
+	Status      AuthStatus        `json:"status"`
+	Username    string            `json:"username"`
+	AuthMethods map[string]int32  `json:"auth_methods,omitempty"`
+	PublicKey   *PublicKey        `json:"public_key,omitempty"`
+	RemoteAddr  net.IP            `json:"remote_addr"`
+	Port        int               `json:"port,omitempty"`
+	Protocol    string            `json:"protocol,omitempty"`
+	TTY         string            `json:"tty,omitempty"`
+	Host        string            `json:"host,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	// Geo is the location of the address, it is updated by the latest event with the location.
+	Geo *GeoInfo `json:"geo,omitempty"`
 
 	ConnsCount     int32      `json:"conns_count"`
 	FirstLogInTime *time.Time `json:"login_time,omitempty"`
-	LastLogInTime  *time.Time `json:"login_time,omitempty"`
+	LastLogInTime  *time.Time `json:"last_login_time,omitempty"`
 	LastLogOutTime *time.Time `json:"logout_time,omitempty"`
 
 	FailsCount      int32      `json:"fails_count,omitempty"`
+	PreauthCount    int32      `json:"preauth_count,omitempty"`
 	LastAttemptTime *time.Time `json:"last_attempt_time,omitempty"`
+
+	// FirstSeen marks the accepted login for the notifiers, it is not stored.
+	FirstSeen *FirstSeen `json:"-"`
+}
+
+// FirstSeen marks the accepted login from the address, the network or with the auth method
+// the user has never logged in with before.
+type FirstSeen struct {
+	IP         bool `json:"ip"`
+	Subnet     bool `json:"subnet"`
+	AuthMethod bool `json:"auth_method"`
+}
+
+// IsNew reports whether anything about the login is new.
+func (f FirstSeen) IsNew() bool {
+	return f.IP || f.Subnet || f.AuthMethod
 }
 
 func NewSession(sessionID uint64, info AuthInfo) Session {
@@ -52,11 +226,46 @@ func NewSession(sessionID uint64, info AuthInfo) Session {
 	return s
 }
 
+// RemoteHostPort returns the remote address with the port, IPv6 addresses are bracketed.
+func (s Session) RemoteHostPort() string {
+	if s.Port == 0 {
+		return s.RemoteAddr.String()
+	}
+	return net.JoinHostPort(s.RemoteAddr.String(), strconv.Itoa(s.Port))
+}
+
 func (s *Session) Update(info AuthInfo) {
+	if info.Port != 0 {
+		s.Port = info.Port
+	}
+	if info.Protocol != "" {
+		s.Protocol = info.Protocol
+	}
+	if info.TTY != "" {
+		s.TTY = info.TTY
+	}
+	if info.Host != "" {
+		s.Host = info.Host
+	}
+	if info.Labels != nil {
+		s.Labels = info.Labels
+	}
+	if info.Geo != nil {
+		s.Geo = info.Geo
+	}
+
+	// the empty methods are omitted in the stored session
+	if s.AuthMethods == nil {
+		s.AuthMethods = map[string]int32{}
+	}
+
 	switch info.Status {
 	case AuthAccepted:
 		s.ConnsCount = s.ConnsCount + 1
 		s.AuthMethods[info.AuthMethod] += 1
+		if info.PublicKey != nil {
+			s.PublicKey = info.PublicKey
+		}
 
 		if s.FirstLogInTime == nil {
 			s.FirstLogInTime = &info.Date
@@ -75,6 +284,11 @@ func (s *Session) Update(info AuthInfo) {
 	case AuthFailed:
 		s.FailsCount += 1
 		s.LastAttemptTime = &info.Date
+	case AuthInvalidUser, AuthMaxAttempts, AuthPreauthClosed, AuthProtocolError:
+		s.PreauthCount += 1
+		s.LastAttemptTime = &info.Date
+	case AuthSessionClosed:
+		s.LastLogOutTime = &info.Date
 	}
 
 	s.Status = info.Status
@@ -90,45 +304,163 @@ func (st *authStorage) UpsetAuthEvent(authInfo AuthInfo) (session Session, err e
 		return
 	}
 	defer func() {
-		if err != nil {
-			err = tx.Rollback()
-		} else {
-			err = tx.Commit()
+		// the event without the session is stored anyway
+		if err != nil && err != ErrSessionNotFound {
+			_ = tx.Rollback()
+			return
+		}
+		if commitErr := tx.Commit(); commitErr != nil {
+			err = commitErr
 		}
 	}()
 
-	userBucket, err := tx.CreateBucketIfNotExists([]byte(authInfo.Username))
+	username := authInfo.Username
+	if username == "" {
+		username = UnknownUser
+	}
+
+	session, err = upsertAuthEvent(&boltRecords{tx: tx, name: []byte(username), bucket: findUserBucket(tx, []byte(username))}, authInfo)
+	return
+}
+
+// boltRecords are the records of the user bucket, the bucket is created by the first write.
+type boltRecords struct {
+	tx     *bolt.Tx
+	name   []byte
+	bucket *bolt.Bucket
+}
+
+func (r *boltRecords) userBucket() (bucket *bolt.Bucket, err error) {
+	if r.bucket == nil {
+		r.bucket, err = createUserBucket(r.tx, r.name)
+	}
+	return r.bucket, err
+}
+
+func (r *boltRecords) connectionAddr(info AuthInfo) (net.IP, error) {
+	if r.bucket == nil {
+		return nil, nil
+	}
+	return connectionAddr(r.bucket, info), nil
+}
+
+func (r *boltRecords) lastLoginAddr() (net.IP, error) {
+	if r.bucket == nil {
+		return nil, nil
+	}
+
+	var addr net.IP
+	var lastLogin time.Time
+	_ = r.bucket.ForEach(func(k, v []byte) error {
+		var session Session
+		if err := json.Unmarshal(v, &session); err != nil {
+			return nil
+		}
+		if session.LastLogInTime != nil && session.LastLogInTime.After(lastLogin) {
+			lastLogin = *session.LastLogInTime
+			addr = net.ParseIP(string(k))
+		}
+		return nil
+	})
+	return addr, nil
+}
+
+func (r *boltRecords) appendEvent(info AuthInfo) error {
+	return appendEvent(r.tx, info)
+}
+
+func (r *boltRecords) nextSessionID() (uint64, error) {
+	bucket, err := r.userBucket()
 	if err != nil {
-		return
+		return 0, err
+	}
+	return bucket.NextSequence()
+}
+
+func (r *boltRecords) session(addr net.IP) (*Session, error) {
+	if r.bucket == nil {
+		return nil, nil
 	}
 
-	sessionID, err := userBucket.NextSequence()
+	raw := r.bucket.Get(addrKey(addr))
+	if raw == nil {
+		return nil, nil
+	}
+	session := &Session{}
+	return session, json.Unmarshal(raw, session)
+}
+
+func (r *boltRecords) putSession(addr net.IP, session Session) error {
+	bucket, err := r.userBucket()
 	if err != nil {
-		return
+		return err
 	}
 
-	rawSession := userBucket.Get([]byte(authInfo.RemoteAddr))
-	if rawSession == nil {
-		session = NewSession(sessionID, authInfo)
-	} else {
-		err = json.Unmarshal(rawSession, &session)
-		if err != nil {
-			return
-		}
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(addrKey(addr), raw)
+}
 
-		session.Update(authInfo)
+func (r *boltRecords) openConnections() ([]Connection, error) {
+	if r.bucket == nil {
+		return nil, nil
 	}
 
-	rawSession, err = json.Marshal(session)
+	c, err := userConnections(r.bucket, false)
+	if err != nil || c == nil {
+		return nil, err
+	}
+	return c.openConnections()
+}
+
+func (r *boltRecords) putConnection(conn *Connection) error {
+	bucket, err := r.userBucket()
 	if err != nil {
-		return
+		return err
 	}
 
-	if err = userBucket.Put([]byte(authInfo.RemoteAddr), rawSession); err != nil {
-		return
+	c, err := userConnections(bucket, true)
+	if err != nil {
+		return err
 	}
+	return c.put(conn)
+}
 
-	return
+func (r *boltRecords) keyUsage(keyID string) (*KeyUsage, error) {
+	if r.bucket == nil {
+		return nil, nil
+	}
+
+	keysBucket := r.bucket.Bucket([]byte(bucketUserKeys))
+	if keysBucket == nil {
+		return nil, nil
+	}
+	raw := keysBucket.Get([]byte(keyID))
+	if raw == nil {
+		return nil, nil
+	}
+	usage := &KeyUsage{}
+	return usage, json.Unmarshal(raw, usage)
+}
+
+func (r *boltRecords) putKeyUsage(keyID string, usage KeyUsage) error {
+	bucket, err := r.userBucket()
+	if err != nil {
+		return err
+	}
+
+	keysBucket, err := bucket.CreateBucketIfNotExists([]byte(bucketUserKeys))
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(usage)
+	if err != nil {
+		return err
+	}
+	return keysBucket.Put([]byte(keyID), raw)
 }
 
 func (st *authStorage) GetUserSessions(username string) (sessions []Session, err error) {
@@ -136,34 +468,55 @@ func (st *authStorage) GetUserSessions(username string) (sessions []Session, err
 	if err != nil {
 		return
 	}
-	defer func() {
-		if err != nil {
-			err = tx.Rollback()
-		} else {
-			err = tx.Commit()
-		}
-	}()
+	defer func() { _ = tx.Rollback() }()
 
-	userBucket := tx.Bucket([]byte(username))
-	if userBucket != nil {
+	userBucket := findUserBucket(tx, []byte(username))
+	if userBucket == nil {
 		return
 	}
 
-	cursor := userBucket.Cursor()
-
-	for {
-		_, rawSession := cursor.Next()
+	err = userBucket.ForEach(func(k, rawSession []byte) error {
+		// nested buckets have no value
 		if rawSession == nil {
-			break
+			return nil
 		}
 
 		var session Session
-		err = json.Unmarshal(rawSession, &session)
-		if err != nil {
-			return
+		if err := json.Unmarshal(rawSession, &session); err != nil {
+			return err
 		}
 		sessions = append(sessions, session)
+		return nil
+	})
+
+	return
+}
+
+func (st *authStorage) GetUserKeys(username string) (keys []KeyUsage, err error) {
+	tx, err := st.db.Begin(false)
+	if err != nil {
+		return
 	}
+	defer func() { _ = tx.Rollback() }()
+
+	userBucket := findUserBucket(tx, []byte(username))
+	if userBucket == nil {
+		return
+	}
+
+	keysBucket := userBucket.Bucket([]byte(bucketUserKeys))
+	if keysBucket == nil {
+		return
+	}
+
+	err = keysBucket.ForEach(func(_, raw []byte) error {
+		var usage KeyUsage
+		if err := json.Unmarshal(raw, &usage); err != nil {
+			return err
+		}
+		keys = append(keys, usage)
+		return nil
+	})
 
 	return
 }
@@ -1,8 +1,13 @@
 package db
 
 import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
+	"time"
 
 	bolt "go.etcd.io/bbolt"
 )
@@ -59,3 +64,123 @@ func Test_authStorage_GetUserSessions(t *testing.T) {
 		})
 	}
 }
+
+func openTestDB(t *testing.T, name string) (*bolt.DB, func()) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := bolt.Open(filepath.Join(dir, name), 0644, nil)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return db, func() {
+		_ = db.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func Test_authStorage_UpsetAuthEvent(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		events  []AuthInfo
+		want    Session
+		wantErr bool
+	}{
+		{
+			name: "pam session joins the last login",
+			events: []AuthInfo{
+				{Status: AuthAccepted, Username: "sheb", AuthMethod: "publickey", RemoteAddr: net.ParseIP("10.0.0.1"), Date: now},
+				{Status: AuthSessionOpened, Username: "sheb", Date: now},
+			},
+			want: Session{Status: AuthSessionOpened, Username: "sheb", RemoteAddr: net.ParseIP("10.0.0.1"), ConnsCount: 1},
+		},
+		{
+			name: "pam session without login",
+			events: []AuthInfo{
+				{Status: AuthSessionOpened, Username: "sheb", Date: now},
+			},
+			wantErr: true,
+		},
+		{
+			name: "preauth without username",
+			events: []AuthInfo{
+				{Status: AuthProtocolError, RemoteAddr: net.ParseIP("10.0.0.1"), Date: now},
+				{Status: AuthPreauthClosed, RemoteAddr: net.ParseIP("10.0.0.1"), Date: now},
+			},
+			want: Session{Status: AuthPreauthClosed, Username: "", RemoteAddr: net.ParseIP("10.0.0.1"), PreauthCount: 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			boltDB, closeDB := openTestDB(t, "auth.db")
+			defer closeDB()
+			st := &authStorage{db: boltDB}
+
+			var got Session
+			var err error
+			for _, event := range tt.events {
+				got, err = st.UpsetAuthEvent(event)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("UpsetAuthEvent() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Status != tt.want.Status || got.Username != tt.want.Username ||
+				!got.RemoteAddr.Equal(tt.want.RemoteAddr) || got.ConnsCount != tt.want.ConnsCount ||
+				got.PreauthCount != tt.want.PreauthCount {
+				t.Errorf("UpsetAuthEvent() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_authStorage_GetUserKeys(t *testing.T) {
+	now := time.Now()
+	aliceKey := &PublicKey{Type: "ED25519-CERT", Fingerprint: "SHA256:aaa", CertID: "alice", CertSerial: 1}
+	bobKey := &PublicKey{Type: "RSA", Fingerprint: "SHA256:bbb"}
+
+	boltDB, closeDB := openTestDB(t, "auth.db")
+	defer closeDB()
+	st := &authStorage{db: boltDB}
+
+	events := []AuthInfo{
+		{Status: AuthAccepted, Username: "deploy", AuthMethod: "publickey", PublicKey: aliceKey, RemoteAddr: net.ParseIP("10.0.0.1"), Date: now},
+		{Status: AuthAccepted, Username: "deploy", AuthMethod: "publickey", PublicKey: bobKey, RemoteAddr: net.ParseIP("10.0.0.2"), Date: now},
+		{Status: AuthAccepted, Username: "deploy", AuthMethod: "publickey", PublicKey: aliceKey, RemoteAddr: net.ParseIP("10.0.0.3"), Date: now},
+		{Status: AuthFailed, Username: "deploy", AuthMethod: "publickey", PublicKey: bobKey, RemoteAddr: net.ParseIP("10.0.0.2"), Date: now},
+	}
+	for _, event := range events {
+		if _, err := st.UpsetAuthEvent(event); err != nil {
+			t.Fatalf("UpsetAuthEvent() error = %v", err)
+		}
+	}
+
+	keys, err := st.GetUserKeys("deploy")
+	if err != nil {
+		t.Fatalf("GetUserKeys() error = %v", err)
+	}
+
+	uses := map[string]int32{}
+	for _, key := range keys {
+		uses[key.CertID] = key.UsesCount
+	}
+	if want := map[string]int32{"alice": 2, "": 1}; !reflect.DeepEqual(uses, want) {
+		t.Errorf("GetUserKeys() uses = %v, want %v", uses, want)
+	}
+
+	sessions, err := st.GetUserSessions("deploy")
+	if err != nil {
+		t.Fatalf("GetUserSessions() error = %v", err)
+	}
+	if len(sessions) != 3 {
+		t.Errorf("GetUserSessions() got %d sessions, want 3", len(sessions))
+	}
+}
@@ -0,0 +1,236 @@
+package db
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ErrNoBackup is returned for the storage without the files, like the memory one.
+var ErrNoBackup = errors.New("storage has no files to back up")
+
+const (
+	// backupPrefix is the prefix of the backup directories, the rotation removes only them.
+	backupPrefix     = "uwatch-"
+	backupTimeFormat = "20060102T150405.000Z"
+	backupTmpSuffix  = ".tmp"
+)
+
+// BackupOptions of the single backup.
+type BackupOptions struct {
+	// Gzip compresses the copies, the files get the .gz suffix.
+	Gzip bool
+	// Keep is the number of the kept backups, the older ones are removed, zero keeps all.
+	Keep int
+}
+
+// BackupReport describes the written backup.
+type BackupReport struct {
+	// Path of the backup directory.
+	Path    string `json:"path"`
+	Files   int    `json:"files"`
+	Size    int64  `json:"size"`
+	Removed int    `json:"removed"`
+}
+
+// Backuper is the storage which can be backed up while it is used.
+type Backuper interface {
+	Backup(dir string, opts BackupOptions) (BackupReport, error)
+}
+
+// backupFile writes the consistent copy of the database file.
+type backupFile struct {
+	name  string
+	write func(w io.Writer) error
+}
+
+// Backup writes the copies of auth.db, tg.db and state.db, every copy is made in the read transaction,
+// so the storage is used as usual meanwhile. The restored state.db keeps the followers from replaying
+// the logs into the restored auth.db.
+func (st *Storage) Backup(dir string, opts BackupOptions) (BackupReport, error) {
+	var files []backupFile
+	for _, file := range []struct {
+		name string
+		db   *bolt.DB
+	}{{"auth.db", st.authDB}, {"tg.db", st.tgDB}, {"state.db", st.stateDB}} {
+		db := file.db
+		files = append(files, backupFile{name: file.name, write: func(w io.Writer) error {
+			return db.View(func(tx *bolt.Tx) error {
+				_, err := tx.WriteTo(w)
+				return err
+			})
+		}})
+	}
+	return backup(dir, opts, time.Now(), files)
+}
+
+// Backup writes the copy of the database made by VACUUM INTO.
+func (st *SQLiteStorage) Backup(dir string, opts BackupOptions) (BackupReport, error) {
+	return backup(dir, opts, time.Now(), []backupFile{{name: SQLiteFile, write: func(w io.Writer) (err error) {
+		tmp, err := ioutil.TempDir("", "uwatch-vacuum")
+		if err != nil {
+			return
+		}
+		defer func() { _ = os.RemoveAll(tmp) }()
+
+		path := filepath.Join(tmp, SQLiteFile)
+		if _, err = st.db.Exec("VACUUM INTO ?", path); err != nil {
+			return
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return
+		}
+		defer func() { _ = file.Close() }()
+		_, err = io.Copy(w, file)
+		return
+	}}})
+}
+
+// Backup backs up the storage, ErrNoBackup is returned for the storage kept in memory.
+func Backup(storage StorageI, dir string, opts BackupOptions) (BackupReport, error) {
+	backuper, ok := storage.(Backuper)
+	if !ok {
+		return BackupReport{}, ErrNoBackup
+	}
+	return backuper.Backup(dir, opts)
+}
+
+// backup writes the files into the new backup directory of the dir and rotates the old backups.
+// The directory is renamed into place when all files are written, so the interrupted backup
+// never looks complete.
+func backup(dir string, opts BackupOptions, now time.Time, files []backupFile) (report BackupReport, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	report.Path = filepath.Join(dir, backupPrefix+now.UTC().Format(backupTimeFormat))
+	tmpPath := report.Path + backupTmpSuffix
+	if err = os.Mkdir(tmpPath, 0755); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(tmpPath)
+		}
+	}()
+
+	for _, file := range files {
+		var size int64
+		if size, err = writeBackupFile(filepath.Join(tmpPath, file.name), opts.Gzip, file.write); err != nil {
+			return
+		}
+		report.Files++
+		report.Size += size
+	}
+
+	if err = os.Rename(tmpPath, report.Path); err != nil {
+		return
+	}
+
+	report.Removed, err = rotateBackups(dir, opts.Keep)
+	return
+}
+
+func writeBackupFile(path string, compress bool, write func(w io.Writer) error) (size int64, err error) {
+	if compress {
+		path += ".gz"
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+
+	if compress {
+		gz := gzip.NewWriter(file)
+		if err = write(gz); err == nil {
+			err = gz.Close()
+		}
+	} else {
+		err = write(file)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	return info.Size(), nil
+}
+
+// LatestBackup returns the time of the latest backup in the dir, zero if there is none.
+func LatestBackup(dir string) (latest time.Time, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return latest, nil
+	}
+	if err != nil {
+		return
+	}
+
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() || !strings.HasPrefix(name, backupPrefix) {
+			continue
+		}
+		date, err := time.Parse(backupTimeFormat, strings.TrimPrefix(name, backupPrefix))
+		if err == nil && date.After(latest) {
+			latest = date
+		}
+	}
+	return latest, nil
+}
+
+// rotateBackups removes all but the keep latest backups and the leftovers of the interrupted ones.
+func rotateBackups(dir string, keep int) (removed int, err error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return
+	}
+
+	var backups []string
+	for _, info := range infos {
+		name := info.Name()
+		if !info.IsDir() || !strings.HasPrefix(name, backupPrefix) {
+			continue
+		}
+		if strings.HasSuffix(name, backupTmpSuffix) {
+			if err = os.RemoveAll(filepath.Join(dir, name)); err != nil {
+				return
+			}
+			continue
+		}
+		backups = append(backups, name)
+	}
+
+	if keep <= 0 || len(backups) <= keep {
+		return
+	}
+
+	// the names are ordered by the time
+	sort.Strings(backups)
+	for _, name := range backups[:len(backups)-keep] {
+		if err = os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			return
+		}
+		removed++
+	}
+	return
+}
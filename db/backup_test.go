@@ -0,0 +1,181 @@
+package db
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gunzipBackup decompresses the files of the backup in place.
+func gunzipBackup(t *testing.T, path string) {
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if !strings.HasSuffix(info.Name(), ".gz") {
+			t.Fatalf("backup file %s is not compressed", info.Name())
+		}
+
+		src, err := os.Open(filepath.Join(path, info.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz, err := gzip.NewReader(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := os.Create(filepath.Join(path, strings.TrimSuffix(info.Name(), ".gz")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = io.Copy(dst, gz); err != nil {
+			t.Fatal(err)
+		}
+		_ = dst.Close()
+		_ = src.Close()
+	}
+}
+
+func TestStorage_Backup(t *testing.T) {
+	for _, backend := range []string{BackendBolt, BackendSQLite} {
+		for _, compress := range []bool{false, true} {
+			name := backend
+			if compress {
+				name += "_gzip"
+			}
+			t.Run(name, func(t *testing.T) {
+				storage, closeStorage := openBackend(t, backend)
+				defer closeStorage()
+
+				dir, err := ioutil.TempDir("", "uwatch")
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer os.RemoveAll(dir)
+
+				runScenario(t, storage)
+				if err = storage.TG().AddUser("sheb", 42); err != nil {
+					t.Fatal(err)
+				}
+				pos := FilePosition{Path: "/var/log/auth.log", Inode: 7, Offset: 1024, UpdatedAt: conformanceStart}
+				if err = storage.Positions().SavePosition(pos); err != nil {
+					t.Fatal(err)
+				}
+
+				report, err := Backup(storage, dir, BackupOptions{Gzip: compress})
+				if err != nil {
+					t.Fatalf("Backup() error = %v", err)
+				}
+				wantFiles := 3
+				if backend == BackendSQLite {
+					wantFiles = 1
+				}
+				if report.Files != wantFiles || report.Size == 0 || filepath.Dir(report.Path) != dir {
+					t.Errorf("Backup() got = %+v", report)
+				}
+
+				// the storage is still in use
+				_, err = storage.Auth().UpsetAuthEvent(AuthInfo{Status: AuthFailed, Username: "sheb",
+					RemoteAddr: net.ParseIP("192.0.2.1"), Date: conformanceStart.Add(time.Hour)})
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if compress {
+					gunzipBackup(t, report.Path)
+				}
+				restored, err := backends[backend](report.Path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer restored.Close()
+
+				page, err := restored.Auth().Events(EventFilter{Limit: MaxEventsLimit})
+				if err != nil || len(page.Events) != len(conformanceEvents()) {
+					t.Errorf("restored Events() got %d events, error = %v", len(page.Events), err)
+				}
+				if info, err := restored.TG().GetUser("sheb"); err != nil || info.ChatID != 42 {
+					t.Errorf("restored GetUser() got = %+v, error = %v", info, err)
+				}
+				// the follower resumes at the saved position
+				if got, err := restored.Positions().GetPosition(pos.Path); err != nil || got == nil || *got != pos {
+					t.Errorf("restored GetPosition() got = %+v, error = %v", got, err)
+				}
+			})
+		}
+	}
+}
+
+func TestBackup_memory(t *testing.T) {
+	if _, err := Backup(NewMemoryStorage(), "", BackupOptions{}); err != ErrNoBackup {
+		t.Errorf("Backup() error = %v, want %v", err, ErrNoBackup)
+	}
+}
+
+func TestBackup_rotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the leftover of the interrupted backup and the foreign directory
+	for _, name := range []string{backupPrefix + "20200101T000000.000Z" + backupTmpSuffix, "other"} {
+		if err = os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := []backupFile{{name: "auth.db", write: func(w io.Writer) error {
+		_, err := w.Write([]byte("auth"))
+		return err
+	}}}
+	start := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	tests := []struct {
+		at          time.Duration
+		wantRemoved int
+	}{
+		{at: 0},
+		{at: time.Hour},
+		{at: 2 * time.Hour},
+		{at: 3 * time.Hour, wantRemoved: 1},
+		{at: 3*time.Hour + time.Millisecond, wantRemoved: 1},
+	}
+	for i, tt := range tests {
+		report, err := backup(dir, BackupOptions{Keep: 3}, start.Add(tt.at), files)
+		if err != nil {
+			t.Fatalf("#%d backup() error = %v", i+1, err)
+		}
+		if report.Removed != tt.wantRemoved || report.Size != 4 {
+			t.Errorf("#%d backup() got = %+v, want %d removed", i+1, report, tt.wantRemoved)
+		}
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	want := "other uwatch-20200106T160000.000Z uwatch-20200106T170000.000Z uwatch-20200106T170000.001Z"
+	if got := strings.Join(names, " "); got != want {
+		t.Errorf("backups got = %s, want %s", got, want)
+	}
+
+	latest, err := LatestBackup(dir)
+	if err != nil || !latest.Equal(start.Add(3*time.Hour+time.Millisecond)) {
+		t.Errorf("LatestBackup() got = %v, error = %v", latest, err)
+	}
+	if latest, err = LatestBackup(filepath.Join(dir, "missing")); err != nil || !latest.IsZero() {
+		t.Errorf("LatestBackup() got = %v, error = %v", latest, err)
+	}
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// CompactReport is the size of the database files before and after the compaction.
+type CompactReport struct {
+	SizeBefore int64 `json:"size_before"`
+	SizeAfter  int64 `json:"size_after"`
+}
+
+// Compact rewrites the bolt files of the storage directory, bolt does not shrink
+// the files after the removal. The files must not be open, e.g. by the running watcher.
+func Compact(dbPath string) (report CompactReport, err error) {
+	for _, name := range []string{"auth.db", "tg.db", "state.db"} {
+		path := filepath.Join(dbPath, name)
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		report.SizeBefore += info.Size()
+
+		if err = compactFile(path); err != nil {
+			return report, err
+		}
+
+		if info, err = os.Stat(path); err != nil {
+			return report, err
+		}
+		report.SizeAfter += info.Size()
+	}
+
+	return report, nil
+}
+
+func compactFile(path string) (err error) {
+	src, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		return
+	}
+	defer func() { _ = src.Close() }()
+
+	// the leftover of the interrupted compaction
+	tmpPath := path + ".compact"
+	_ = os.Remove(tmpPath)
+	dst, err := bolt.Open(tmpPath, 0644, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = dst.Close()
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	err = src.View(func(srcTx *bolt.Tx) error {
+		return dst.Update(func(dstTx *bolt.Tx) error {
+			return srcTx.ForEach(func(name []byte, b *bolt.Bucket) error {
+				nested, err := dstTx.CreateBucket(name)
+				if err != nil {
+					return err
+				}
+				return copyBucket(nested, b)
+			})
+		})
+	})
+	if err != nil {
+		return
+	}
+
+	if err = dst.Close(); err != nil {
+		return
+	}
+	if err = src.Close(); err != nil {
+		return
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func copyBucket(dst, src *bolt.Bucket) error {
+	// the buckets are filled in the key order, full pages take less space
+	dst.FillPercent = 1
+	if err := dst.SetSequence(src.Sequence()); err != nil {
+		return err
+	}
+
+	return src.ForEach(func(k, v []byte) error {
+		if nested := src.Bucket(k); nested != nil {
+			dstNested, err := dst.CreateBucket(k)
+			if err != nil {
+				return err
+			}
+			return copyBucket(dstNested, nested)
+		}
+		return dst.Put(k, v)
+	})
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	for i := 0; i < 500; i++ {
+		_, err := storage.Auth().UpsetAuthEvent(AuthInfo{Status: AuthInvalidUser, Username: fmt.Sprintf("scanner%d", i),
+			RemoteAddr: net.ParseIP("10.0.0.9"), Date: now.AddDate(0, -2, 0)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, err = storage.Auth().UpsetAuthEvent(AuthInfo{Status: AuthAccepted, Username: "sheb", AuthMethod: "publickey",
+		RemoteAddr: net.ParseIP("10.0.0.1"), Port: 1000, PID: 10, Date: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = storage.Auth().Prune(RetentionPolicy{FailedBefore: now.AddDate(0, -1, 0)}); err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Compact(dir)
+	if err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if report.SizeAfter >= report.SizeBefore {
+		t.Errorf("Compact() got = %+v, want smaller files", report)
+	}
+
+	storage, err = NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	conns, err := storage.Auth().GetUserConnections("sheb")
+	if err != nil || len(conns) != 1 || conns[0].ID != 1 {
+		t.Errorf("GetUserConnections() got = %+v, %v", conns, err)
+	}
+
+	// the sequences are kept
+	_, err = storage.Auth().UpsetAuthEvent(AuthInfo{Status: AuthAccepted, Username: "sheb", AuthMethod: "publickey",
+		RemoteAddr: net.ParseIP("10.0.0.1"), Port: 1001, PID: 11, Date: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns, err = storage.Auth().GetUserConnections("sheb")
+	if err != nil || len(conns) != 2 || conns[1].ID != 2 {
+		t.Errorf("GetUserConnections() got = %+v, %v", conns, err)
+	}
+}
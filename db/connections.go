@@ -0,0 +1,208 @@
+package db
+
+import (
+	"encoding/json"
+	"net"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+type ConnectionStatus string
+
+const (
+	ConnectionOpen   ConnectionStatus = "Open"
+	ConnectionClosed ConnectionStatus = "Closed"
+	// ConnectionLost is the connection without the logged end,
+	// its sshd PID is reused by a new connection.
+	ConnectionLost ConnectionStatus = "Lost"
+)
+
+// Connection is a single accepted sshd connection, identified by the sshd PID on the host.
+type Connection struct {
+	ID         uint64            `json:"id"`
+	Status     ConnectionStatus  `json:"status"`
+	PID        int               `json:"pid"`
+	Username   string            `json:"username"`
+	AuthMethod string            `json:"auth_method,omitempty"`
+	PublicKey  *PublicKey        `json:"public_key,omitempty"`
+	RemoteAddr net.IP            `json:"remote_addr"`
+	Port       int               `json:"port,omitempty"`
+	Protocol   string            `json:"protocol,omitempty"`
+	Host       string            `json:"host,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+
+	StartTime time.Time     `json:"start_time"`
+	EndTime   *time.Time    `json:"end_time,omitempty"`
+	Duration  time.Duration `json:"duration,omitempty"`
+}
+
+func NewConnection(info AuthInfo) Connection {
+	return Connection{
+		Status:     ConnectionOpen,
+		PID:        info.PID,
+		Username:   info.Username,
+		AuthMethod: info.AuthMethod,
+		PublicKey:  info.PublicKey,
+		RemoteAddr: info.RemoteAddr,
+		Port:       info.Port,
+		Protocol:   info.Protocol,
+		Host:       info.Host,
+		Labels:     info.Labels,
+		StartTime:  info.Date,
+	}
+}
+
+// Close sets the end of the connection.
+func (c *Connection) Close(date time.Time) {
+	c.Status = ConnectionClosed
+	c.EndTime = &date
+	c.Duration = date.Sub(c.StartTime)
+}
+
+// matches reports whether the event belongs to the connection. The session lines
+// are logged by the same sshd process, the disconnect lines carry the same client port.
+func (c Connection) matches(info AuthInfo) bool {
+	if c.Host != info.Host {
+		return false
+	}
+	if info.Port != 0 {
+		return c.Port == info.Port && c.RemoteAddr.Equal(info.RemoteAddr)
+	}
+	return info.PID != 0 && c.PID == info.PID
+}
+
+const (
+	// bucketUserConnections is the nested bucket of the user bucket with all Connection records.
+	bucketUserConnections = "__connections"
+	// bucketUserOpenConnections is the nested bucket of the user bucket
+	// with the keys of the open connections.
+	bucketUserOpenConnections = "__open"
+)
+
+// connections are the Connection records of the user in the transaction.
+type connections struct {
+	all  *bolt.Bucket
+	open *bolt.Bucket
+}
+
+// userConnections returns the connections of the user, nil if there are none and create is false.
+func userConnections(userBucket *bolt.Bucket, create bool) (*connections, error) {
+	if !create {
+		c := &connections{
+			all:  userBucket.Bucket([]byte(bucketUserConnections)),
+			open: userBucket.Bucket([]byte(bucketUserOpenConnections)),
+		}
+		if c.all == nil || c.open == nil {
+			return nil, nil
+		}
+		return c, nil
+	}
+
+	all, err := userBucket.CreateBucketIfNotExists([]byte(bucketUserConnections))
+	if err != nil {
+		return nil, err
+	}
+
+	open, err := userBucket.CreateBucketIfNotExists([]byte(bucketUserOpenConnections))
+	if err != nil {
+		return nil, err
+	}
+
+	return &connections{all: all, open: open}, nil
+}
+
+// openConnections returns the open connections in the order of the IDs.
+func (c *connections) openConnections() (conns []Connection, err error) {
+	err = c.open.ForEach(func(k, _ []byte) error {
+		conn, err := c.get(k)
+		if err != nil || conn == nil {
+			return err
+		}
+		conns = append(conns, *conn)
+		return nil
+	})
+	return
+}
+
+func (c *connections) get(key []byte) (*Connection, error) {
+	raw := c.all.Get(key)
+	if raw == nil {
+		return nil, nil
+	}
+
+	conn := &Connection{}
+	return conn, json.Unmarshal(raw, conn)
+}
+
+func (c *connections) put(conn *Connection) (err error) {
+	if conn.ID == 0 {
+		if conn.ID, err = c.all.NextSequence(); err != nil {
+			return
+		}
+	}
+
+	raw, err := json.Marshal(conn)
+	if err != nil {
+		return
+	}
+
+	key := sequenceKey(conn.ID)
+	if err = c.all.Put(key, raw); err != nil {
+		return
+	}
+
+	if conn.Status == ConnectionOpen {
+		return c.open.Put(key, []byte{})
+	}
+	return c.open.Delete(key)
+}
+
+func (st *authStorage) GetUserConnections(username string) (conns []Connection, err error) {
+	tx, err := st.db.Begin(false)
+	if err != nil {
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	userBucket := findUserBucket(tx, []byte(username))
+	if userBucket == nil {
+		return
+	}
+
+	bucket := userBucket.Bucket([]byte(bucketUserConnections))
+	if bucket == nil {
+		return
+	}
+
+	err = bucket.ForEach(func(_, raw []byte) error {
+		var conn Connection
+		if err := json.Unmarshal(raw, &conn); err != nil {
+			return err
+		}
+		conns = append(conns, conn)
+		return nil
+	})
+
+	return
+}
+
+// connectionAddr returns the address of the latest connection with the PID of the event.
+func connectionAddr(userBucket *bolt.Bucket, info AuthInfo) net.IP {
+	c, err := userConnections(userBucket, false)
+	if info.PID == 0 || err != nil || c == nil {
+		return nil
+	}
+
+	cursor := c.all.Cursor()
+	for k, raw := cursor.Last(); k != nil; k, raw = cursor.Prev() {
+		var conn Connection
+		if err := json.Unmarshal(raw, &conn); err != nil {
+			continue
+		}
+		if conn.Host == info.Host && conn.PID == info.PID {
+			return conn.RemoteAddr
+		}
+	}
+	return nil
+}
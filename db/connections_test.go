@@ -0,0 +1,77 @@
+package db
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_authStorage_Connections(t *testing.T) {
+	start := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	nat, other := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+
+	boltDB, closeDB := openTestDB(t, "auth.db")
+	defer closeDB()
+	st := &authStorage{db: boltDB}
+
+	events := []struct {
+		event     AuthInfo
+		wantConns int32
+		wantAddr  net.IP
+	}{
+		// two parallel logins from the same NAT address
+		{event: AuthInfo{Status: AuthAccepted, Username: "sheb", AuthMethod: "publickey", RemoteAddr: nat,
+			Port: 1001, PID: 100, Host: "web", Date: start}, wantConns: 1, wantAddr: nat},
+		{event: AuthInfo{Status: AuthAccepted, Username: "sheb", AuthMethod: "password", RemoteAddr: nat,
+			Port: 1002, PID: 200, Host: "web", Date: start.Add(time.Minute)}, wantConns: 2, wantAddr: nat},
+		// the latest login is from the other address, the pam line belongs to the first one by the PID
+		{event: AuthInfo{Status: AuthAccepted, Username: "sheb", AuthMethod: "publickey", RemoteAddr: other,
+			Port: 1003, PID: 300, Host: "web", Date: start.Add(2 * time.Minute)}, wantConns: 1, wantAddr: other},
+		{event: AuthInfo{Status: AuthSessionClosed, Username: "sheb", PID: 100, Host: "web",
+			Date: start.Add(10 * time.Minute)}, wantConns: 1, wantAddr: nat},
+		// the disconnect is logged by the child process, it is matched by the port
+		{event: AuthInfo{Status: AuthDisconnected, Username: "sheb", RemoteAddr: nat, Port: 1002, PID: 201, Host: "web",
+			Date: start.Add(11 * time.Minute)}, wantConns: 0, wantAddr: nat},
+		// the close line after the disconnect is not counted twice
+		{event: AuthInfo{Status: AuthSessionClosed, Username: "sheb", PID: 200, Host: "web",
+			Date: start.Add(11 * time.Minute)}, wantConns: 0, wantAddr: nat},
+		// the disconnect of the PID 300 is missed, the PID is reused
+		{event: AuthInfo{Status: AuthAccepted, Username: "sheb", AuthMethod: "publickey", RemoteAddr: other,
+			Port: 1004, PID: 300, Host: "web", Date: start.Add(time.Hour)}, wantConns: 1, wantAddr: other},
+	}
+
+	for i, tt := range events {
+		session, err := st.UpsetAuthEvent(tt.event)
+		if err != nil {
+			t.Fatalf("UpsetAuthEvent() #%d error = %v", i+1, err)
+		}
+		if session.ConnsCount != tt.wantConns || !session.RemoteAddr.Equal(tt.wantAddr) {
+			t.Errorf("UpsetAuthEvent() #%d got %s with %d conns, want %s with %d",
+				i+1, session.RemoteAddr, session.ConnsCount, tt.wantAddr, tt.wantConns)
+		}
+	}
+
+	conns, err := st.GetUserConnections("sheb")
+	if err != nil {
+		t.Fatalf("GetUserConnections() error = %v", err)
+	}
+
+	want := []struct {
+		pid      int
+		status   ConnectionStatus
+		duration time.Duration
+	}{
+		{pid: 100, status: ConnectionClosed, duration: 10 * time.Minute},
+		{pid: 200, status: ConnectionClosed, duration: 10 * time.Minute},
+		{pid: 300, status: ConnectionLost},
+		{pid: 300, status: ConnectionOpen},
+	}
+	if len(conns) != len(want) {
+		t.Fatalf("GetUserConnections() got %d connections, want %d", len(conns), len(want))
+	}
+	for i, conn := range conns {
+		if conn.PID != want[i].pid || conn.Status != want[i].status || conn.Duration != want[i].duration {
+			t.Errorf("connection #%d got = %+v, want %+v", i+1, conn, want[i])
+		}
+	}
+}
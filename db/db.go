@@ -11,15 +11,42 @@ type StorageI interface {
 	Auth() AuthStorage
 	TG() TGStorage
 	Slack() SlackStorage
+	Positions() PositionStorage
+	Imports() ImportStorage
+	Close() error
 }
 
 // Auth Storage Schema:
 // Bucket<username> -*> Key<ip> -> Value<Session>
+// Bucket<username> -> Bucket<__keys> -*> Key<key_id> -> Value<KeyUsage>
+// Bucket<username> -> Bucket<__connections> -*> Key<sequence> -> Value<Connection>
+// Bucket<username> -> Bucket<__open> -*> Key<sequence> -> Value<>
+// Bucket<username> -> Bucket<__escalations> -*> Key<sequence> -> Value<Escalation>
+// Bucket<__events> -*> Key<unix_nano><sequence> -> Value<AuthInfo>
+// Bucket<__meta> -> Key<schema_version> -> Value<version>
 type AuthStorage interface {
 	UpsetAuthEvent(authInfo AuthInfo) (Session, error)
 	GetUserSessions(username string) ([]Session, error)
+	GetUserKeys(username string) ([]KeyUsage, error)
+	GetUserConnections(username string) ([]Connection, error)
+	// Events returns the page of the event log, all upserted events are kept there.
+	Events(filter EventFilter) (EventsPage, error)
+	// Prune removes the records expired by the policy and the users left without records.
+	Prune(policy RetentionPolicy) (PruneReport, error)
+
+	AddEscalation(escalation Escalation) (Escalation, error)
+	GetUserEscalations(username string) ([]Escalation, error)
+
+	// Users returns the sorted names of the users with the stored records, UnknownUser included.
+	Users() ([]string, error)
+	// ImportRecord stores the exported user or event record as it is,
+	// ok is false if the record with the same ID is already stored.
+	ImportRecord(record Record) (ok bool, err error)
 }
 
+// TG Storage Schema:
+// Bucket<tg_whitelist> -*> Key<username> -> Value<TGChatInfo>
+// Bucket<__meta> -> Key<schema_version> -> Value<version>
 type TGStorage interface {
 	AddUser(username string, chatID int64) error
 	Mute(username string, chatID int64) error
@@ -30,9 +57,24 @@ type TGStorage interface {
 type SlackStorage interface {
 }
 
+// Positions Storage Schema:
+// Bucket<positions> -*> Key<path> -> Value<FilePosition>
+type PositionStorage interface {
+	GetPosition(path string) (*FilePosition, error)
+	SavePosition(pos FilePosition) error
+}
+
+// Imports Storage Schema:
+// Bucket<imports> -*> Key<file_id> -> Value<ImportState>
+type ImportStorage interface {
+	GetImport(fileID string) (*ImportState, error)
+	SaveImport(state ImportState) error
+}
+
 type Storage struct {
-	authDB *bolt.DB
-	tgDB   *bolt.DB
+	authDB  *bolt.DB
+	tgDB    *bolt.DB
+	stateDB *bolt.DB
 }
 
 func NewStorage(dbPath string) (StorageI, error) {
@@ -43,17 +85,22 @@ func NewStorage(dbPath string) (StorageI, error) {
 		}
 	}
 
-	authDB, err := bolt.Open(dbPath+"/auth.db", 0644, &bolt.Options{Timeout: 1 * time.Second})
-	if err != nil {
-		return nil, err
-	}
-
-	tgDB, err := bolt.Open(dbPath+"/tg.db", 0644, &bolt.Options{Timeout: 1 * time.Second})
-	if err != nil {
-		return nil, err
+	dbs := make([]*bolt.DB, 0, len(schemas))
+	for _, s := range schemas {
+		db, err := bolt.Open(dbPath+"/"+s.file, 0644, &bolt.Options{Timeout: 1 * time.Second})
+		if err == nil {
+			_, err = s.migrate(db, false)
+			dbs = append(dbs, db)
+		}
+		if err != nil {
+			for _, db := range dbs {
+				_ = db.Close()
+			}
+			return nil, err
+		}
 	}
 
-	return &Storage{authDB: authDB, tgDB: tgDB}, nil
+	return &Storage{authDB: dbs[0], tgDB: dbs[1], stateDB: dbs[2]}, nil
 }
 
 func (st *Storage) Auth() AuthStorage {
@@ -68,6 +115,27 @@ func (st *Storage) TG() TGStorage {
 	}
 }
 
+func (st *Storage) Positions() PositionStorage {
+	return &positionStorage{
+		db: st.stateDB,
+	}
+}
+
+func (st *Storage) Imports() ImportStorage {
+	return &importStorage{
+		db: st.stateDB,
+	}
+}
+
+func (st *Storage) Close() error {
+	for _, db := range []*bolt.DB{st.authDB, st.tgDB, st.stateDB} {
+		if err := db.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (st *Storage) Slack() SlackStorage {
 	// todo:
 	return nil
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+)
+
+type EscalationStatus string
+
+const (
+	// EscalationGranted is the command run by sudo or the su session opened.
+	EscalationGranted EscalationStatus = "Granted"
+	// EscalationFailed is the wrong password.
+	EscalationFailed EscalationStatus = "Failed"
+	// EscalationDenied is the user or the command not allowed by sudoers.
+	EscalationDenied EscalationStatus = "Denied"
+	// EscalationClosed is the su session closed.
+	EscalationClosed EscalationStatus = "Closed"
+)
+
+// IsFailure reports whether the escalation attempt is rejected.
+func (s EscalationStatus) IsFailure() bool {
+	return s == EscalationFailed || s == EscalationDenied
+}
+
+// Escalation is the attempt of the user to act as the target user by sudo or su.
+type Escalation struct {
+	ID     uint64           `json:"id"`
+	Tool   string           `json:"tool"`
+	Status EscalationStatus `json:"status"`
+	// Username is the invoking user.
+	Username   string `json:"username"`
+	TargetUser string `json:"target_user"`
+	TTY        string `json:"tty,omitempty"`
+	PWD        string `json:"pwd,omitempty"`
+	Command    string `json:"command,omitempty"`
+	// Attempts is the count of incorrect passwords.
+	Attempts int       `json:"attempts,omitempty"`
+	Date     time.Time `json:"date"`
+	Host     string    `json:"host,omitempty"`
+	// Labels of the log source.
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// bucketUserEscalations is the nested bucket of the user bucket with Escalation records.
+const bucketUserEscalations = "__escalations"
+
+func (st *authStorage) AddEscalation(escalation Escalation) (saved Escalation, err error) {
+	tx, err := st.db.Begin(true)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	username := escalation.Username
+	if username == "" {
+		username = UnknownUser
+	}
+
+	userBucket, err := createUserBucket(tx, []byte(username))
+	if err != nil {
+		return
+	}
+
+	bucket, err := userBucket.CreateBucketIfNotExists([]byte(bucketUserEscalations))
+	if err != nil {
+		return
+	}
+
+	escalation.ID, err = bucket.NextSequence()
+	if err != nil {
+		return
+	}
+
+	raw, err := json.Marshal(escalation)
+	if err != nil {
+		return
+	}
+
+	if err = bucket.Put(sequenceKey(escalation.ID), raw); err != nil {
+		return
+	}
+
+	saved = escalation
+	return
+}
+
+func (st *authStorage) GetUserEscalations(username string) (escalations []Escalation, err error) {
+	tx, err := st.db.Begin(false)
+	if err != nil {
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	userBucket := findUserBucket(tx, []byte(username))
+	if userBucket == nil {
+		return
+	}
+
+	bucket := userBucket.Bucket([]byte(bucketUserEscalations))
+	if bucket == nil {
+		return
+	}
+
+	err = bucket.ForEach(func(_, raw []byte) error {
+		var escalation Escalation
+		if err := json.Unmarshal(raw, &escalation); err != nil {
+			return err
+		}
+		escalations = append(escalations, escalation)
+		return nil
+	})
+
+	return
+}
+
+// sequenceKey keeps the records ordered by the sequence.
+func sequenceKey(id uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, id)
+	return key
+}
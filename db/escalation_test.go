@@ -0,0 +1,54 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func Test_authStorage_AddEscalation(t *testing.T) {
+	now := time.Now()
+
+	boltDB, closeDB := openTestDB(t, "auth.db")
+	defer closeDB()
+	st := &authStorage{db: boltDB}
+
+	escalations := []Escalation{
+		{Tool: "sudo", Status: EscalationFailed, Username: "sheb", TargetUser: "root", Command: "/bin/ls", Attempts: 3, Date: now},
+		{Tool: "sudo", Status: EscalationGranted, Username: "sheb", TargetUser: "root", Command: "/bin/ls", Date: now},
+		{Tool: "su", Status: EscalationClosed, TargetUser: "root", Date: now},
+	}
+	for i, escalation := range escalations {
+		saved, err := st.AddEscalation(escalation)
+		if err != nil {
+			t.Fatalf("AddEscalation() error = %v", err)
+		}
+		if saved.ID == 0 {
+			t.Errorf("AddEscalation() #%d has no ID", i+1)
+		}
+	}
+
+	got, err := st.GetUserEscalations("sheb")
+	if err != nil {
+		t.Fatalf("GetUserEscalations() error = %v", err)
+	}
+	if len(got) != 2 || got[0].Status != EscalationFailed || got[1].Status != EscalationGranted {
+		t.Errorf("GetUserEscalations() got = %+v", got)
+	}
+
+	got, err = st.GetUserEscalations(UnknownUser)
+	if err != nil {
+		t.Fatalf("GetUserEscalations() error = %v", err)
+	}
+	if len(got) != 1 {
+		t.Errorf("GetUserEscalations() got %d escalations, want 1", len(got))
+	}
+
+	// the escalations bucket is not a session
+	sessions, err := st.GetUserSessions("sheb")
+	if err != nil {
+		t.Fatalf("GetUserSessions() error = %v", err)
+	}
+	if len(sessions) != 0 {
+		t.Errorf("GetUserSessions() got %d sessions, want 0", len(sessions))
+	}
+}
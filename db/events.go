@@ -0,0 +1,202 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var ErrInvalidCursor = errors.New("invalid events cursor")
+
+// AuthEvent is the record of the append-only event log.
+type AuthEvent struct {
+	// ID is the time-ordered key of the event, it is also the pagination cursor.
+	ID string `json:"id"`
+	AuthInfo
+}
+
+// EventFilter selects the events of the log, the empty fields match any event.
+type EventFilter struct {
+	Username   string
+	RemoteAddr net.IP
+	Statuses   []AuthStatus
+	// From and To limit the event dates to [From, To).
+	From time.Time
+	To   time.Time
+
+	// Cursor is the Next of the previous page.
+	Cursor string
+	// Limit is the page size, DefaultEventsLimit if zero.
+	Limit int
+	// Descending returns the newest events first.
+	Descending bool
+}
+
+// EventsPage is the page of the events, Next is empty on the last page.
+type EventsPage struct {
+	Events []AuthEvent `json:"events"`
+	Next   string      `json:"next,omitempty"`
+}
+
+const (
+	DefaultEventsLimit = 100
+	MaxEventsLimit     = 1000
+)
+
+// bucketEvents is the top-level bucket of the event log.
+const bucketEvents = "__events"
+
+// eventKey orders the events by the date, the sequence makes the keys unique.
+func eventKey(date time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(date.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+func timeKey(date time.Time) []byte {
+	return eventKey(date, 0)
+}
+
+func appendEvent(tx *bolt.Tx, authInfo AuthInfo) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketEvents))
+	if err != nil {
+		return err
+	}
+
+	seq, err := bucket.NextSequence()
+	if err != nil {
+		return err
+	}
+
+	raw, err := json.Marshal(authInfo)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(eventKey(authInfo.Date, seq), raw)
+}
+
+func (filter EventFilter) match(event AuthInfo) bool {
+	if filter.Username != "" && filter.Username != event.Username {
+		return false
+	}
+	if len(filter.RemoteAddr) != 0 && !filter.RemoteAddr.Equal(event.RemoteAddr) {
+		return false
+	}
+	if len(filter.Statuses) == 0 {
+		return true
+	}
+	for _, status := range filter.Statuses {
+		if status == event.Status {
+			return true
+		}
+	}
+	return false
+}
+
+func (st *authStorage) Events(filter EventFilter) (page EventsPage, err error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultEventsLimit
+	}
+	if limit > MaxEventsLimit {
+		limit = MaxEventsLimit
+	}
+
+	var cursorKey []byte
+	if filter.Cursor != "" {
+		cursorKey, err = hex.DecodeString(filter.Cursor)
+		if err != nil || len(cursorKey) != 16 {
+			err = ErrInvalidCursor
+			return
+		}
+	}
+
+	tx, err := st.db.Begin(false)
+	if err != nil {
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	bucket := tx.Bucket([]byte(bucketEvents))
+	if bucket == nil {
+		return
+	}
+
+	// the keys within [fromKey, toKey) belong to the time range
+	fromKey, toKey := []byte(nil), []byte(nil)
+	if !filter.From.IsZero() {
+		fromKey = timeKey(filter.From)
+	}
+	if !filter.To.IsZero() {
+		toKey = timeKey(filter.To)
+	}
+
+	cursor := bucket.Cursor()
+	var k, v []byte
+	var next func() ([]byte, []byte)
+	if filter.Descending {
+		next = cursor.Prev
+		switch {
+		case cursorKey != nil:
+			k, v = seekBefore(cursor, cursorKey)
+		case toKey != nil:
+			k, v = seekBefore(cursor, toKey)
+		default:
+			k, v = cursor.Last()
+		}
+	} else {
+		next = cursor.Next
+		switch {
+		case cursorKey != nil:
+			if k, v = cursor.Seek(cursorKey); bytes.Equal(k, cursorKey) {
+				k, v = cursor.Next()
+			}
+		case fromKey != nil:
+			k, v = cursor.Seek(fromKey)
+		default:
+			k, v = cursor.First()
+		}
+	}
+
+	for ; k != nil; k, v = next() {
+		if filter.Descending && fromKey != nil && bytes.Compare(k, fromKey) < 0 {
+			break
+		}
+		if !filter.Descending && toKey != nil && bytes.Compare(k, toKey) >= 0 {
+			break
+		}
+
+		var event AuthEvent
+		if err = json.Unmarshal(v, &event.AuthInfo); err != nil {
+			return
+		}
+		if !filter.match(event.AuthInfo) {
+			continue
+		}
+
+		if len(page.Events) == limit {
+			page.Next = page.Events[limit-1].ID
+			break
+		}
+
+		event.ID = hex.EncodeToString(k)
+		page.Events = append(page.Events, event)
+	}
+
+	return
+}
+
+// seekBefore moves the cursor to the last key before the key.
+func seekBefore(cursor *bolt.Cursor, key []byte) ([]byte, []byte) {
+	if k, _ := cursor.Seek(key); k == nil {
+		return cursor.Last()
+	}
+	return cursor.Prev()
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_authStorage_Events(t *testing.T) {
+	// Tuesday
+	day := time.Date(2020, 1, 7, 0, 0, 0, 0, time.UTC)
+	alice, bob := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+
+	boltDB, closeDB := openTestDB(t, "auth.db")
+	defer closeDB()
+	st := &authStorage{db: boltDB}
+
+	events := []AuthInfo{
+		{Status: AuthAccepted, Username: "alice", AuthMethod: "publickey", RemoteAddr: alice, Date: day.Add(13 * time.Hour)},
+		{Status: AuthAccepted, Username: "alice", AuthMethod: "publickey", RemoteAddr: alice, Date: day.Add(14 * time.Hour)},
+		{Status: AuthFailed, Username: "bob", AuthMethod: "password", RemoteAddr: bob, Date: day.Add(14*time.Hour + time.Minute)},
+		{Status: AuthAccepted, Username: "bob", AuthMethod: "password", RemoteAddr: bob, Date: day.Add(15 * time.Hour)},
+		// the same time is kept twice
+		{Status: AuthAccepted, Username: "bob", AuthMethod: "password", RemoteAddr: bob, Date: day.Add(15 * time.Hour)},
+		{Status: AuthDisconnected, Username: "alice", RemoteAddr: alice, Date: day.Add(15*time.Hour + 30*time.Minute)},
+		{Status: AuthAccepted, Username: "alice", AuthMethod: "publickey", RemoteAddr: alice, Date: day.Add(16 * time.Hour)},
+	}
+	// the log is ordered by the date, not by the insertion
+	for _, i := range []int{3, 0, 6, 2, 1, 5, 4} {
+		if _, err := st.UpsetAuthEvent(events[i]); err != nil {
+			t.Fatalf("UpsetAuthEvent() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		filter EventFilter
+		// want are the indexes of the events in the pages
+		want [][]int
+	}{
+		{filter: EventFilter{}, want: [][]int{{0, 1, 2, 3, 4, 5, 6}}},
+		{
+			filter: EventFilter{Statuses: []AuthStatus{AuthAccepted}, From: day.Add(14 * time.Hour), To: day.Add(16 * time.Hour)},
+			want:   [][]int{{1, 3, 4}},
+		},
+		{filter: EventFilter{Username: "alice", Limit: 2}, want: [][]int{{0, 1}, {5, 6}}},
+		{filter: EventFilter{RemoteAddr: bob, Limit: 2}, want: [][]int{{2, 3}, {4}}},
+		{filter: EventFilter{Limit: 3, Descending: true}, want: [][]int{{6, 5, 4}, {3, 2, 1}, {0}}},
+		{
+			filter: EventFilter{From: day.Add(14 * time.Hour), To: day.Add(16 * time.Hour), Limit: 2, Descending: true},
+			want:   [][]int{{5, 4}, {3, 2}, {1}},
+		},
+		{filter: EventFilter{From: day.Add(17 * time.Hour)}, want: [][]int{nil}},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			filter := tt.filter
+			for j, want := range tt.want {
+				page, err := st.Events(filter)
+				if err != nil {
+					t.Fatalf("Events() error = %v", err)
+				}
+
+				var got, wantEvents []string
+				for _, event := range page.Events {
+					got = append(got, eventString(event.AuthInfo))
+				}
+				for _, k := range want {
+					wantEvents = append(wantEvents, eventString(events[k]))
+				}
+				if fmt.Sprint(got) != fmt.Sprint(wantEvents) {
+					t.Errorf("Events() page #%d got = %v, want %v", j+1, got, wantEvents)
+				}
+
+				last := j == len(tt.want)-1
+				if (page.Next == "") != last {
+					t.Fatalf("Events() page #%d next = %q", j+1, page.Next)
+				}
+				filter.Cursor = page.Next
+			}
+		})
+	}
+
+	if _, err := st.Events(EventFilter{Cursor: "zz"}); err != ErrInvalidCursor {
+		t.Errorf("Events() error = %v, want %v", err, ErrInvalidCursor)
+	}
+}
+
+func eventString(event AuthInfo) string {
+	return fmt.Sprintf("%s %s %s", event.Date.Format(time.Kitchen), event.Username, event.Status)
+}
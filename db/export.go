@@ -0,0 +1,409 @@
+package db
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ExportVersion is the version of the export format.
+const ExportVersion = 1
+
+type RecordType string
+
+const (
+	RecordHeader     RecordType = "header"
+	RecordTGUser     RecordType = "tg_user"
+	RecordSession    RecordType = "session"
+	RecordKey        RecordType = "key"
+	RecordConnection RecordType = "connection"
+	RecordEscalation RecordType = "escalation"
+	RecordEvent      RecordType = "event"
+)
+
+// ExportHeader is the first record of the export.
+type ExportHeader struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+}
+
+// Record is the exported record, only the field of the Type is set.
+type Record struct {
+	Type RecordType `json:"type"`
+	// User is the owner of the user records and the Telegram user,
+	// UnknownUser owns the records logged before the username is known.
+	User string `json:"user,omitempty"`
+
+	Header     *ExportHeader `json:"header,omitempty"`
+	TGUser     *TGChatInfo   `json:"tg_user,omitempty"`
+	Session    *Session      `json:"session,omitempty"`
+	Key        *KeyUsage     `json:"key,omitempty"`
+	Connection *Connection   `json:"connection,omitempty"`
+	Escalation *Escalation   `json:"escalation,omitempty"`
+	Event      *AuthEvent    `json:"event,omitempty"`
+}
+
+// ID identifies the record within the type and the user, it is the storage key.
+func (r Record) ID() string {
+	switch {
+	case r.Session != nil:
+		return r.Session.RemoteAddr.String()
+	case r.Key != nil:
+		return r.Key.ID()
+	case r.Connection != nil:
+		return strconv.FormatUint(r.Connection.ID, 10)
+	case r.Escalation != nil:
+		return strconv.FormatUint(r.Escalation.ID, 10)
+	case r.Event != nil:
+		return r.Event.ID
+	}
+	return r.User
+}
+
+// Validate checks that the record can be imported.
+func (r Record) Validate() error {
+	set := 0
+	for _, isSet := range []bool{r.Header != nil, r.TGUser != nil, r.Session != nil, r.Key != nil,
+		r.Connection != nil, r.Escalation != nil, r.Event != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set != 1 {
+		return fmt.Errorf("%s record must have the single value, got %d", r.Type, set)
+	}
+
+	switch r.Type {
+	case RecordHeader:
+		if r.Header == nil {
+			break
+		}
+		if r.Header.Version != ExportVersion {
+			return fmt.Errorf("unsupported export version %d", r.Header.Version)
+		}
+		return nil
+	case RecordEvent:
+		if r.Event == nil {
+			break
+		}
+		key, err := eventIDKey(r.Event.ID)
+		if err != nil {
+			return err
+		}
+		if binary.BigEndian.Uint64(key) != uint64(r.Event.Date.UnixNano()) {
+			return fmt.Errorf("event %s does not match the date %s", r.Event.ID, r.Event.Date)
+		}
+		return nil
+	}
+
+	if r.User == "" {
+		return fmt.Errorf("%s record has no user", r.Type)
+	}
+
+	switch r.Type {
+	case RecordTGUser:
+		if r.TGUser == nil {
+			break
+		}
+		if r.TGUser.ChatID == 0 {
+			return errors.New("tg_user record has no chat_id")
+		}
+		return nil
+	case RecordSession:
+		if r.Session == nil {
+			break
+		}
+		if len(r.Session.RemoteAddr) == 0 || r.Session.ID == 0 {
+			return errors.New("session record has no remote_addr or session_id")
+		}
+		return nil
+	case RecordKey:
+		if r.Key == nil {
+			break
+		}
+		if r.Key.Fingerprint == "" {
+			return errors.New("key record has no fingerprint")
+		}
+		return nil
+	case RecordConnection:
+		if r.Connection == nil {
+			break
+		}
+		switch r.Connection.Status {
+		case ConnectionOpen, ConnectionClosed, ConnectionLost:
+		default:
+			return fmt.Errorf("connection record has invalid status %q", r.Connection.Status)
+		}
+		if r.Connection.ID == 0 || r.Connection.StartTime.IsZero() {
+			return errors.New("connection record has no id or start_time")
+		}
+		return nil
+	case RecordEscalation:
+		if r.Escalation == nil {
+			break
+		}
+		switch r.Escalation.Status {
+		case EscalationGranted, EscalationFailed, EscalationDenied, EscalationClosed:
+		default:
+			return fmt.Errorf("escalation record has invalid status %q", r.Escalation.Status)
+		}
+		if r.Escalation.ID == 0 {
+			return errors.New("escalation record has no id")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown record type %q", r.Type)
+	}
+
+	return fmt.Errorf("%s record has no %s value", r.Type, r.Type)
+}
+
+// eventIDKey decodes the event ID into the key of the event log.
+func eventIDKey(id string) ([]byte, error) {
+	key, err := hex.DecodeString(id)
+	if err != nil || len(key) != 16 {
+		return nil, fmt.Errorf("invalid event id %q", id)
+	}
+	return key, nil
+}
+
+// Export walks the records of the storage in the import order:
+// the header, the Telegram users, the records of every user and the event log.
+func Export(storage StorageI, write func(Record) error) error {
+	if err := write(Record{Type: RecordHeader, Header: &ExportHeader{Version: ExportVersion, ExportedAt: time.Now()}}); err != nil {
+		return err
+	}
+
+	tgUsers, err := storage.TG().GetUsers()
+	if err != nil {
+		return err
+	}
+	for _, username := range sortedTGUsers(tgUsers) {
+		info := tgUsers[username]
+		if err = write(Record{Type: RecordTGUser, User: username, TGUser: &info}); err != nil {
+			return err
+		}
+	}
+
+	auth := storage.Auth()
+	users, err := auth.Users()
+	if err != nil {
+		return err
+	}
+	for _, username := range users {
+		if err = exportUser(auth, username, write); err != nil {
+			return err
+		}
+	}
+
+	filter := EventFilter{Limit: MaxEventsLimit}
+	for {
+		page, err := auth.Events(filter)
+		if err != nil {
+			return err
+		}
+		for i := range page.Events {
+			if err = write(Record{Type: RecordEvent, Event: &page.Events[i]}); err != nil {
+				return err
+			}
+		}
+		if page.Next == "" {
+			return nil
+		}
+		filter.Cursor = page.Next
+	}
+}
+
+func exportUser(auth AuthStorage, username string, write func(Record) error) error {
+	sessions, err := auth.GetUserSessions(username)
+	if err != nil {
+		return err
+	}
+	for i := range sessions {
+		if err = write(Record{Type: RecordSession, User: username, Session: &sessions[i]}); err != nil {
+			return err
+		}
+	}
+
+	keys, err := auth.GetUserKeys(username)
+	if err != nil {
+		return err
+	}
+	for i := range keys {
+		if err = write(Record{Type: RecordKey, User: username, Key: &keys[i]}); err != nil {
+			return err
+		}
+	}
+
+	conns, err := auth.GetUserConnections(username)
+	if err != nil {
+		return err
+	}
+	for i := range conns {
+		if err = write(Record{Type: RecordConnection, User: username, Connection: &conns[i]}); err != nil {
+			return err
+		}
+	}
+
+	escalations, err := auth.GetUserEscalations(username)
+	if err != nil {
+		return err
+	}
+	for i := range escalations {
+		if err = write(Record{Type: RecordEscalation, User: username, Escalation: &escalations[i]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import stores the valid record as it is, ok is false if the record with the same ID is stored.
+func Import(storage StorageI, record Record) (ok bool, err error) {
+	switch record.Type {
+	case RecordHeader:
+		return true, nil
+	case RecordTGUser:
+		info, err := storage.TG().GetUser(record.User)
+		if err != nil || info.ChatID != 0 {
+			return false, err
+		}
+		if record.TGUser.Muted {
+			return true, storage.TG().Mute(record.User, record.TGUser.ChatID)
+		}
+		return true, storage.TG().AddUser(record.User, record.TGUser.ChatID)
+	}
+	return storage.Auth().ImportRecord(record)
+}
+
+func (st *authStorage) Users() (users []string, err error) {
+	tx, err := st.db.Begin(false)
+	if err != nil {
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	names, err := userNames(tx)
+	for _, name := range names {
+		users = append(users, string(name))
+	}
+	return
+}
+
+func (st *authStorage) ImportRecord(record Record) (ok bool, err error) {
+	tx, err := st.db.Begin(true)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if record.Type == RecordEvent {
+		return importEvent(tx, *record.Event)
+	}
+
+	userBucket, err := createUserBucket(tx, []byte(record.User))
+	if err != nil {
+		return
+	}
+
+	var bucket *bolt.Bucket
+	var key []byte
+	var value interface{}
+	var seq uint64
+	switch record.Type {
+	case RecordSession:
+		bucket, key, value, seq = userBucket, addrKey(record.Session.RemoteAddr), record.Session, record.Session.ID
+	case RecordKey:
+		if bucket, err = userBucket.CreateBucketIfNotExists([]byte(bucketUserKeys)); err != nil {
+			return
+		}
+		key, value = []byte(record.Key.ID()), record.Key
+	case RecordConnection:
+		var c *connections
+		if c, err = userConnections(userBucket, true); err != nil {
+			return
+		}
+		if c.all.Get(sequenceKey(record.Connection.ID)) != nil {
+			return false, nil
+		}
+		// put keeps the index of the open connections
+		if err = c.put(record.Connection); err != nil {
+			return
+		}
+		return true, bumpSequence(c.all, record.Connection.ID)
+	case RecordEscalation:
+		if bucket, err = userBucket.CreateBucketIfNotExists([]byte(bucketUserEscalations)); err != nil {
+			return
+		}
+		key, value, seq = sequenceKey(record.Escalation.ID), record.Escalation, record.Escalation.ID
+	default:
+		err = fmt.Errorf("unsupported record type %q", record.Type)
+		return
+	}
+
+	if bucket.Get(key) != nil {
+		return false, nil
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	if err = bucket.Put(key, raw); err != nil {
+		return
+	}
+	return true, bumpSequence(bucket, seq)
+}
+
+func importEvent(tx *bolt.Tx, event AuthEvent) (ok bool, err error) {
+	key, err := eventIDKey(event.ID)
+	if err != nil {
+		return
+	}
+
+	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketEvents))
+	if err != nil {
+		return
+	}
+	if bucket.Get(key) != nil {
+		return false, nil
+	}
+
+	raw, err := json.Marshal(event.AuthInfo)
+	if err != nil {
+		return
+	}
+	if err = bucket.Put(key, raw); err != nil {
+		return
+	}
+	return true, bumpSequence(bucket, binary.BigEndian.Uint64(key[8:]))
+}
+
+// bumpSequence keeps the sequence ahead of the imported IDs.
+func bumpSequence(bucket *bolt.Bucket, seq uint64) error {
+	if bucket.Sequence() >= seq {
+		return nil
+	}
+	return bucket.SetSequence(seq)
+}
+
+func sortedTGUsers(users map[string]TGChatInfo) []string {
+	names := make([]string, 0, len(users))
+	for name := range users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
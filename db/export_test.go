@@ -0,0 +1,174 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func exportRecords(t *testing.T, storage StorageI) []Record {
+	var records []Record
+	err := Export(storage, func(record Record) error {
+		records = append(records, record)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Export() error = %v", err)
+	}
+	return records
+}
+
+func TestStorage_conformanceExport(t *testing.T) {
+	for source := range backends {
+		for target := range backends {
+			t.Run(source+"_to_"+target, func(t *testing.T) {
+				from, closeFrom := openBackend(t, source)
+				defer closeFrom()
+				to, closeTo := openBackend(t, target)
+				defer closeTo()
+
+				runScenario(t, from)
+				if err := from.TG().AddUser("sheb", 42); err != nil {
+					t.Fatal(err)
+				}
+				if err := from.TG().Mute("admin", 43); err != nil {
+					t.Fatal(err)
+				}
+
+				records := exportRecords(t, from)
+				if records[0].Type != RecordHeader || records[0].Header.Version != ExportVersion {
+					t.Fatalf("Export() first record got = %+v", records[0])
+				}
+				for i, record := range records {
+					if err := record.Validate(); err != nil {
+						t.Errorf("Validate() #%d error = %v", i+1, err)
+					}
+					ok, err := Import(to, record)
+					if err != nil || !ok {
+						t.Fatalf("Import() #%d got = %v, error = %v", i+1, ok, err)
+					}
+				}
+
+				if got, want := dumpStorage(t, to), dumpStorage(t, from); !reflect.DeepEqual(got, want) {
+					t.Errorf("imported storage differs:\ngot  = %+v\nwant = %+v", got, want)
+				}
+				gotUsers, _ := to.Auth().Users()
+				wantUsers, _ := from.Auth().Users()
+				if !reflect.DeepEqual(gotUsers, wantUsers) {
+					t.Errorf("Users() got = %v, want %v", gotUsers, wantUsers)
+				}
+				gotTG, _ := to.TG().GetUsers()
+				wantTG, _ := from.TG().GetUsers()
+				if !reflect.DeepEqual(gotTG, wantTG) {
+					t.Errorf("GetUsers() got = %v, want %v", gotTG, wantTG)
+				}
+
+				for i, record := range records[1:] {
+					if ok, err := Import(to, record); err != nil || ok {
+						t.Errorf("Import() repeated #%d got = %v, error = %v, want conflict", i+1, ok, err)
+					}
+				}
+
+				// the sequences continue after the imported IDs
+				escalation, err := to.Auth().AddEscalation(Escalation{Tool: "sudo", Status: EscalationGranted,
+					Username: "sheb", Date: conformanceStart.Add(time.Hour)})
+				if err != nil || escalation.ID != 3 {
+					t.Errorf("AddEscalation() got = %+v, error = %v", escalation, err)
+				}
+				_, err = to.Auth().UpsetAuthEvent(AuthInfo{Status: AuthFailed, Username: "sheb",
+					RemoteAddr: net.ParseIP("192.0.2.1"), Date: conformanceStart.Add(time.Hour)})
+				if err != nil {
+					t.Fatal(err)
+				}
+				page, err := to.Auth().Events(EventFilter{Limit: MaxEventsLimit})
+				if err != nil || len(page.Events) != len(conformanceEvents())+1 {
+					t.Errorf("Events() got %d events, error = %v", len(page.Events), err)
+				}
+			})
+		}
+	}
+}
+
+func TestStorage_conformanceImportServiceUsernames(t *testing.T) {
+	usernames := []string{"__events", "__meta"}
+
+	for backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			storage, closeStorage := openBackend(t, backend)
+			defer closeStorage()
+
+			for i, username := range usernames {
+				records := []Record{
+					{Type: RecordSession, User: username, Session: &Session{ID: uint64(i + 1), Status: AuthInvalidUser,
+						Username: username, RemoteAddr: net.ParseIP("10.0.0.2"), AuthMethods: map[string]int32{}}},
+					{Type: RecordEscalation, User: username, Escalation: &Escalation{ID: 1, Tool: "su",
+						Status: EscalationFailed, Username: username, Date: conformanceStart}},
+				}
+				for _, record := range records {
+					if ok, err := Import(storage, record); err != nil || !ok {
+						t.Fatalf("Import() %s got = %v, error = %v", record.Type, ok, err)
+					}
+				}
+			}
+
+			// the user records are not the events
+			page, err := storage.Auth().Events(EventFilter{Limit: MaxEventsLimit})
+			if err != nil || len(page.Events) != 0 {
+				t.Errorf("Events() got = %+v, error = %v, want none", page, err)
+			}
+			for _, username := range usernames {
+				if sessions, err := storage.Auth().GetUserSessions(username); err != nil || len(sessions) != 1 {
+					t.Errorf("GetUserSessions(%q) got = %+v, error = %v, want 1 session", username, sessions, err)
+				}
+				if escalations, err := storage.Auth().GetUserEscalations(username); err != nil || len(escalations) != 1 {
+					t.Errorf("GetUserEscalations(%q) got = %+v, error = %v, want 1 escalation", username, escalations, err)
+				}
+			}
+			if users, err := storage.Auth().Users(); err != nil || len(users) != len(usernames) {
+				t.Errorf("Users() got = %v, error = %v, want %v", users, err, usernames)
+			}
+		})
+	}
+}
+
+func TestRecord_Validate(t *testing.T) {
+	date := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	eventID := fmt.Sprintf("%x", eventKey(date, 1))
+
+	tests := []struct {
+		record  Record
+		wantErr bool
+	}{
+		{record: Record{Type: RecordHeader, Header: &ExportHeader{Version: ExportVersion}}},
+		{record: Record{Type: RecordHeader, Header: &ExportHeader{Version: ExportVersion + 1}}, wantErr: true},
+		{record: Record{Type: RecordTGUser, User: "sheb", TGUser: &TGChatInfo{ChatID: 42}}},
+		{record: Record{Type: RecordTGUser, User: "sheb", TGUser: &TGChatInfo{}}, wantErr: true},
+		{record: Record{Type: RecordSession, User: "sheb",
+			Session: &Session{ID: 1, RemoteAddr: net.ParseIP("10.0.0.1")}}},
+		{record: Record{Type: RecordSession, Session: &Session{ID: 1, RemoteAddr: net.ParseIP("10.0.0.1")}},
+			wantErr: true},
+		{record: Record{Type: RecordSession, User: "sheb", Session: &Session{ID: 1}}, wantErr: true},
+		{record: Record{Type: RecordSession, User: "sheb", Key: &KeyUsage{}}, wantErr: true},
+		{record: Record{Type: RecordKey, User: "sheb", Key: &KeyUsage{PublicKey: PublicKey{Fingerprint: "SHA256:abc"}}}},
+		{record: Record{Type: RecordConnection, User: "sheb",
+			Connection: &Connection{ID: 1, Status: ConnectionOpen, StartTime: date}}},
+		{record: Record{Type: RecordConnection, User: "sheb", Connection: &Connection{ID: 1, Status: "gone", StartTime: date}},
+			wantErr: true},
+		{record: Record{Type: RecordEscalation, User: "sheb", Escalation: &Escalation{ID: 1, Status: EscalationDenied}}},
+		{record: Record{Type: RecordEscalation, User: "sheb", Escalation: &Escalation{Status: EscalationDenied}}, wantErr: true},
+		{record: Record{Type: RecordEvent, Event: &AuthEvent{ID: eventID, AuthInfo: AuthInfo{Date: date}}}},
+		{record: Record{Type: RecordEvent, Event: &AuthEvent{ID: eventID, AuthInfo: AuthInfo{Date: date.Add(1)}}}, wantErr: true},
+		{record: Record{Type: RecordEvent, Event: &AuthEvent{ID: "zz", AuthInfo: AuthInfo{Date: date}}}, wantErr: true},
+		{record: Record{Type: "user", User: "sheb", Key: &KeyUsage{}}, wantErr: true},
+		{record: Record{Type: RecordKey, User: "sheb"}, wantErr: true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			if err := tt.record.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ImportState is the progress of the log file import.
+type ImportState struct {
+	// FileID identifies the file content regardless of its name and compression.
+	FileID string `json:"file_id"`
+	Path   string `json:"path"`
+	// Lines is the number of the processed lines.
+	Lines     int64     `json:"lines"`
+	Done      bool      `json:"done"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const bucketImports = "imports"
+
+type importStorage struct {
+	db *bolt.DB
+}
+
+func (st *importStorage) GetImport(fileID string) (state *ImportState, err error) {
+	tx, err := st.db.Begin(false)
+	if err != nil {
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	bucket := tx.Bucket([]byte(bucketImports))
+	if bucket == nil {
+		return
+	}
+
+	raw := bucket.Get([]byte(fileID))
+	if raw == nil {
+		return
+	}
+
+	state = &ImportState{}
+	err = json.Unmarshal(raw, state)
+	return
+}
+
+func (st *importStorage) SaveImport(state ImportState) (err error) {
+	tx, err := st.db.Begin(true)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketImports))
+	if err != nil {
+		return
+	}
+
+	raw, err := json.Marshal(state)
+	if err != nil {
+		return
+	}
+
+	err = bucket.Put([]byte(state.FileID), raw)
+	return
+}
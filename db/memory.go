@@ -0,0 +1,739 @@
+package db
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryPath is the DB config value of the storage kept in memory, nothing is written to the disk.
+const MemoryPath = ":memory:"
+
+// MemoryStorage keeps the records in memory, it is safe for the concurrent use.
+// The records are kept encoded as in bolt, so the callers never share the maps of the stored records.
+type MemoryStorage struct {
+	mu sync.RWMutex
+
+	users     map[string]*memoryUser
+	events    []memoryEvent
+	eventsSeq uint64
+
+	tgUsers   map[string]TGChatInfo
+	positions map[string]FilePosition
+	imports   map[string]ImportState
+}
+
+// memoryUser is the user bucket of the bolt storage.
+type memoryUser struct {
+	sessions    map[string][]byte
+	keys        map[string][]byte
+	connections map[uint64][]byte
+	escalations map[uint64][]byte
+
+	sessionSeq    uint64
+	connectionSeq uint64
+	escalationSeq uint64
+}
+
+func (u *memoryUser) empty() bool {
+	return len(u.sessions) == 0 && len(u.keys) == 0 && len(u.connections) == 0 && len(u.escalations) == 0
+}
+
+type memoryEvent struct {
+	key []byte
+	raw []byte
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		users:     map[string]*memoryUser{},
+		tgUsers:   map[string]TGChatInfo{},
+		positions: map[string]FilePosition{},
+		imports:   map[string]ImportState{},
+	}
+}
+
+func (st *MemoryStorage) Auth() AuthStorage {
+	return &memoryAuthStorage{st}
+}
+
+func (st *MemoryStorage) TG() TGStorage {
+	return &memoryTGStorage{st}
+}
+
+func (st *MemoryStorage) Slack() SlackStorage {
+	return &memorySlackStorage{}
+}
+
+func (st *MemoryStorage) Positions() PositionStorage {
+	return &memoryPositionStorage{st}
+}
+
+func (st *MemoryStorage) Imports() ImportStorage {
+	return &memoryImportStorage{st}
+}
+
+func (st *MemoryStorage) Close() error {
+	return nil
+}
+
+// user returns the records of the user, nil if there are none and create is false.
+func (st *MemoryStorage) user(username string, create bool) *memoryUser {
+	user, ok := st.users[username]
+	if !ok && create {
+		user = &memoryUser{
+			sessions:    map[string][]byte{},
+			keys:        map[string][]byte{},
+			connections: map[uint64][]byte{},
+			escalations: map[uint64][]byte{},
+		}
+		st.users[username] = user
+	}
+	return user
+}
+
+type memoryAuthStorage struct {
+	st *MemoryStorage
+}
+
+func (s *memoryAuthStorage) UpsetAuthEvent(authInfo AuthInfo) (session Session, err error) {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+
+	username := authInfo.Username
+	if username == "" {
+		username = UnknownUser
+	}
+
+	return upsertAuthEvent(&memoryRecords{st: s.st, username: username, user: s.st.user(username, false)}, authInfo)
+}
+
+// memoryRecords are the records of the user, the user is created by the first write.
+type memoryRecords struct {
+	st       *MemoryStorage
+	username string
+	user     *memoryUser
+}
+
+func (r *memoryRecords) write() *memoryUser {
+	if r.user == nil {
+		r.user = r.st.user(r.username, true)
+	}
+	return r.user
+}
+
+func (r *memoryRecords) connectionAddr(info AuthInfo) (net.IP, error) {
+	if r.user == nil || info.PID == 0 {
+		return nil, nil
+	}
+
+	conns, err := r.user.allConnections()
+	for i := len(conns) - 1; i >= 0 && err == nil; i-- {
+		if conns[i].Host == info.Host && conns[i].PID == info.PID {
+			return conns[i].RemoteAddr, nil
+		}
+	}
+	return nil, err
+}
+
+func (r *memoryRecords) lastLoginAddr() (addr net.IP, err error) {
+	if r.user == nil {
+		return
+	}
+
+	var lastLogin time.Time
+	for _, key := range sortedKeys(r.user.sessions) {
+		var session Session
+		if err = json.Unmarshal(r.user.sessions[key], &session); err != nil {
+			return
+		}
+		if session.LastLogInTime != nil && session.LastLogInTime.After(lastLogin) {
+			lastLogin = *session.LastLogInTime
+			addr = net.ParseIP(key)
+		}
+	}
+	return
+}
+
+func (r *memoryRecords) appendEvent(info AuthInfo) error {
+	return r.st.appendEvent(info)
+}
+
+func (r *memoryRecords) nextSessionID() (uint64, error) {
+	user := r.write()
+	user.sessionSeq++
+	return user.sessionSeq, nil
+}
+
+func (r *memoryRecords) session(addr net.IP) (*Session, error) {
+	if r.user == nil {
+		return nil, nil
+	}
+
+	raw, ok := r.user.sessions[string(addrKey(addr))]
+	if !ok {
+		return nil, nil
+	}
+	session := &Session{}
+	return session, json.Unmarshal(raw, session)
+}
+
+func (r *memoryRecords) putSession(addr net.IP, session Session) (err error) {
+	r.write().sessions[string(addrKey(addr))], err = json.Marshal(session)
+	return
+}
+
+func (r *memoryRecords) openConnections() (open []Connection, err error) {
+	if r.user == nil {
+		return
+	}
+
+	conns, err := r.user.allConnections()
+	for _, conn := range conns {
+		if conn.Status == ConnectionOpen {
+			open = append(open, conn)
+		}
+	}
+	return
+}
+
+func (r *memoryRecords) putConnection(conn *Connection) (err error) {
+	user := r.write()
+	if conn.ID == 0 {
+		user.connectionSeq++
+		conn.ID = user.connectionSeq
+	}
+
+	user.connections[conn.ID], err = json.Marshal(conn)
+	return
+}
+
+func (r *memoryRecords) keyUsage(keyID string) (*KeyUsage, error) {
+	if r.user == nil {
+		return nil, nil
+	}
+
+	raw, ok := r.user.keys[keyID]
+	if !ok {
+		return nil, nil
+	}
+	usage := &KeyUsage{}
+	return usage, json.Unmarshal(raw, usage)
+}
+
+func (r *memoryRecords) putKeyUsage(keyID string, usage KeyUsage) (err error) {
+	r.write().keys[keyID], err = json.Marshal(usage)
+	return
+}
+
+func (u *memoryUser) allConnections() (conns []Connection, err error) {
+	for _, id := range sortedIDs(u.connections) {
+		var conn Connection
+		if err = json.Unmarshal(u.connections[id], &conn); err != nil {
+			return
+		}
+		conns = append(conns, conn)
+	}
+	return
+}
+
+func (st *MemoryStorage) appendEvent(authInfo AuthInfo) error {
+	raw, err := json.Marshal(authInfo)
+	if err != nil {
+		return err
+	}
+
+	st.eventsSeq++
+	event := memoryEvent{key: eventKey(authInfo.Date, st.eventsSeq), raw: raw}
+
+	// the events are ordered by the key
+	i := sort.Search(len(st.events), func(i int) bool { return bytes.Compare(st.events[i].key, event.key) > 0 })
+	st.events = append(st.events, memoryEvent{})
+	copy(st.events[i+1:], st.events[i:])
+	st.events[i] = event
+	return nil
+}
+
+// searchEvents returns the index of the first event with the key not less than the key.
+func (st *MemoryStorage) searchEvents(key []byte) int {
+	return sort.Search(len(st.events), func(i int) bool { return bytes.Compare(st.events[i].key, key) >= 0 })
+}
+
+func (s *memoryAuthStorage) Events(filter EventFilter) (page EventsPage, err error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultEventsLimit
+	}
+	if limit > MaxEventsLimit {
+		limit = MaxEventsLimit
+	}
+
+	var cursorKey []byte
+	if filter.Cursor != "" {
+		cursorKey, err = hex.DecodeString(filter.Cursor)
+		if err != nil || len(cursorKey) != 16 {
+			err = ErrInvalidCursor
+			return
+		}
+	}
+
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+
+	events := s.st.events
+	// the keys within [fromKey, toKey) belong to the time range
+	fromKey, toKey := []byte(nil), []byte(nil)
+	if !filter.From.IsZero() {
+		fromKey = timeKey(filter.From)
+	}
+	if !filter.To.IsZero() {
+		toKey = timeKey(filter.To)
+	}
+
+	var i, step int
+	if filter.Descending {
+		step = -1
+		switch {
+		case cursorKey != nil:
+			i = s.st.searchEvents(cursorKey) - 1
+		case toKey != nil:
+			i = s.st.searchEvents(toKey) - 1
+		default:
+			i = len(events) - 1
+		}
+	} else {
+		step = 1
+		switch {
+		case cursorKey != nil:
+			if i = s.st.searchEvents(cursorKey); i < len(events) && bytes.Equal(events[i].key, cursorKey) {
+				i++
+			}
+		case fromKey != nil:
+			i = s.st.searchEvents(fromKey)
+		}
+	}
+
+	for ; i >= 0 && i < len(events); i += step {
+		k := events[i].key
+		if filter.Descending && fromKey != nil && bytes.Compare(k, fromKey) < 0 {
+			break
+		}
+		if !filter.Descending && toKey != nil && bytes.Compare(k, toKey) >= 0 {
+			break
+		}
+
+		var event AuthEvent
+		if err = json.Unmarshal(events[i].raw, &event.AuthInfo); err != nil {
+			return
+		}
+		if !filter.match(event.AuthInfo) {
+			continue
+		}
+
+		if len(page.Events) == limit {
+			page.Next = page.Events[limit-1].ID
+			break
+		}
+
+		event.ID = hex.EncodeToString(k)
+		page.Events = append(page.Events, event)
+	}
+
+	return
+}
+
+func (s *memoryAuthStorage) GetUserSessions(username string) (sessions []Session, err error) {
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+
+	user := s.st.user(username, false)
+	if user == nil {
+		return
+	}
+
+	for _, key := range sortedKeys(user.sessions) {
+		var session Session
+		if err = json.Unmarshal(user.sessions[key], &session); err != nil {
+			return
+		}
+		sessions = append(sessions, session)
+	}
+	return
+}
+
+func (s *memoryAuthStorage) GetUserKeys(username string) (keys []KeyUsage, err error) {
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+
+	user := s.st.user(username, false)
+	if user == nil {
+		return
+	}
+
+	for _, key := range sortedKeys(user.keys) {
+		var usage KeyUsage
+		if err = json.Unmarshal(user.keys[key], &usage); err != nil {
+			return
+		}
+		keys = append(keys, usage)
+	}
+	return
+}
+
+func (s *memoryAuthStorage) GetUserConnections(username string) ([]Connection, error) {
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+
+	user := s.st.user(username, false)
+	if user == nil {
+		return nil, nil
+	}
+	return user.allConnections()
+}
+
+func (s *memoryAuthStorage) AddEscalation(escalation Escalation) (saved Escalation, err error) {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+
+	username := escalation.Username
+	if username == "" {
+		username = UnknownUser
+	}
+
+	user := s.st.user(username, true)
+	user.escalationSeq++
+	escalation.ID = user.escalationSeq
+
+	if user.escalations[escalation.ID], err = json.Marshal(escalation); err != nil {
+		return
+	}
+
+	saved = escalation
+	return
+}
+
+func (s *memoryAuthStorage) GetUserEscalations(username string) (escalations []Escalation, err error) {
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+
+	user := s.st.user(username, false)
+	if user == nil {
+		return
+	}
+
+	for _, id := range sortedIDs(user.escalations) {
+		var escalation Escalation
+		if err = json.Unmarshal(user.escalations[id], &escalation); err != nil {
+			return
+		}
+		escalations = append(escalations, escalation)
+	}
+	return
+}
+
+func (s *memoryAuthStorage) Users() (users []string, err error) {
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+
+	for username := range s.st.users {
+		users = append(users, username)
+	}
+	sort.Strings(users)
+	return
+}
+
+func (s *memoryAuthStorage) ImportRecord(record Record) (ok bool, err error) {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+
+	if record.Type == RecordEvent {
+		return s.st.importEvent(*record.Event)
+	}
+
+	var records map[string][]byte
+	var ids map[uint64][]byte
+	var key string
+	var value interface{}
+	var id uint64
+	user := s.st.user(record.User, true)
+	// the sequences are kept ahead of the imported IDs
+	switch record.Type {
+	case RecordSession:
+		records, key, value = user.sessions, string(addrKey(record.Session.RemoteAddr)), record.Session
+		user.sessionSeq = maxSeq(user.sessionSeq, record.Session.ID)
+	case RecordKey:
+		records, key, value = user.keys, record.Key.ID(), record.Key
+	case RecordConnection:
+		ids, id, value = user.connections, record.Connection.ID, record.Connection
+		user.connectionSeq = maxSeq(user.connectionSeq, id)
+	case RecordEscalation:
+		ids, id, value = user.escalations, record.Escalation.ID, record.Escalation
+		user.escalationSeq = maxSeq(user.escalationSeq, id)
+	default:
+		err = fmt.Errorf("unsupported record type %q", record.Type)
+		return
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	if records != nil {
+		if _, exists := records[key]; exists {
+			return false, nil
+		}
+		records[key] = raw
+		return true, nil
+	}
+	if _, exists := ids[id]; exists {
+		return false, nil
+	}
+	ids[id] = raw
+	return true, nil
+}
+
+func (st *MemoryStorage) importEvent(event AuthEvent) (ok bool, err error) {
+	key, err := eventIDKey(event.ID)
+	if err != nil {
+		return
+	}
+	i := st.searchEvents(key)
+	if i < len(st.events) && bytes.Equal(st.events[i].key, key) {
+		return false, nil
+	}
+
+	raw, err := json.Marshal(event.AuthInfo)
+	if err != nil {
+		return
+	}
+	st.eventsSeq = maxSeq(st.eventsSeq, binary.BigEndian.Uint64(key[8:]))
+	st.events = append(st.events, memoryEvent{})
+	copy(st.events[i+1:], st.events[i:])
+	st.events[i] = memoryEvent{key: key, raw: raw}
+	return true, nil
+}
+
+func maxSeq(seq, id uint64) uint64 {
+	if id > seq {
+		return id
+	}
+	return seq
+}
+
+func (s *memoryAuthStorage) Prune(policy RetentionPolicy) (report PruneReport, err error) {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+
+	return pruneAuth(memoryPruner{s.st}, policy)
+}
+
+// memoryPruner removes the records of all users.
+type memoryPruner struct {
+	st *MemoryStorage
+}
+
+func (p memoryPruner) deleteSessions(expired func(Session) bool) (n int, err error) {
+	for _, user := range p.st.users {
+		for key, raw := range user.sessions {
+			var session Session
+			if err = json.Unmarshal(raw, &session); err != nil {
+				return
+			}
+			if expired(session) {
+				delete(user.sessions, key)
+				n++
+			}
+		}
+	}
+	return
+}
+
+func (p memoryPruner) deleteKeys(expired func(KeyUsage) bool) (n int, err error) {
+	for _, user := range p.st.users {
+		for key, raw := range user.keys {
+			var usage KeyUsage
+			if err = json.Unmarshal(raw, &usage); err != nil {
+				return
+			}
+			if expired(usage) {
+				delete(user.keys, key)
+				n++
+			}
+		}
+	}
+	return
+}
+
+func (p memoryPruner) deleteConnections(expired func(Connection) bool) (n int, err error) {
+	for _, user := range p.st.users {
+		for id, raw := range user.connections {
+			var conn Connection
+			if err = json.Unmarshal(raw, &conn); err != nil {
+				return
+			}
+			if expired(conn) {
+				delete(user.connections, id)
+				n++
+			}
+		}
+	}
+	return
+}
+
+func (p memoryPruner) deleteEscalations(expired func(Escalation) bool) (n int, err error) {
+	for _, user := range p.st.users {
+		for id, raw := range user.escalations {
+			var escalation Escalation
+			if err = json.Unmarshal(raw, &escalation); err != nil {
+				return
+			}
+			if expired(escalation) {
+				delete(user.escalations, id)
+				n++
+			}
+		}
+	}
+	return
+}
+
+func (p memoryPruner) deleteEvents(before time.Time, expired func(AuthInfo) bool) (n int, err error) {
+	last := p.st.searchEvents(timeKey(before))
+	kept := p.st.events[:0]
+	for i, event := range p.st.events {
+		if i < last {
+			var info AuthInfo
+			if err = json.Unmarshal(event.raw, &info); err != nil {
+				return
+			}
+			if expired(info) {
+				n++
+				continue
+			}
+		}
+		kept = append(kept, event)
+	}
+	p.st.events = kept
+	return
+}
+
+func (p memoryPruner) deleteEmptyUsers() (n int, err error) {
+	for username, user := range p.st.users {
+		if user.empty() {
+			delete(p.st.users, username)
+			n++
+		}
+	}
+	return
+}
+
+// sortedKeys returns the keys in the bolt order.
+func sortedKeys(records map[string][]byte) []string {
+	keys := make([]string, 0, len(records))
+	for key := range records {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedIDs(records map[uint64][]byte) []uint64 {
+	ids := make([]uint64, 0, len(records))
+	for id := range records {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+type memoryTGStorage struct {
+	st *MemoryStorage
+}
+
+func (s *memoryTGStorage) AddUser(username string, chatID int64) error {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+
+	s.st.tgUsers[username] = TGChatInfo{ChatID: chatID, Muted: false}
+	return nil
+}
+
+func (s *memoryTGStorage) Mute(username string, chatID int64) error {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+
+	s.st.tgUsers[username] = TGChatInfo{ChatID: chatID, Muted: true}
+	return nil
+}
+
+func (s *memoryTGStorage) GetUsers() (map[string]TGChatInfo, error) {
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+
+	users := make(map[string]TGChatInfo, len(s.st.tgUsers))
+	for username, info := range s.st.tgUsers {
+		users[username] = info
+	}
+	return users, nil
+}
+
+func (s *memoryTGStorage) GetUser(username string) (TGChatInfo, error) {
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+
+	return s.st.tgUsers[username], nil
+}
+
+// memorySlackStorage is empty as the SlackStorage has no methods yet.
+type memorySlackStorage struct{}
+
+type memoryPositionStorage struct {
+	st *MemoryStorage
+}
+
+func (s *memoryPositionStorage) GetPosition(path string) (*FilePosition, error) {
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+
+	pos, ok := s.st.positions[path]
+	if !ok {
+		return nil, nil
+	}
+	return &pos, nil
+}
+
+func (s *memoryPositionStorage) SavePosition(pos FilePosition) error {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+
+	s.st.positions[pos.Path] = pos
+	return nil
+}
+
+type memoryImportStorage struct {
+	st *MemoryStorage
+}
+
+func (s *memoryImportStorage) GetImport(fileID string) (*ImportState, error) {
+	s.st.mu.RLock()
+	defer s.st.mu.RUnlock()
+
+	state, ok := s.st.imports[fileID]
+	if !ok {
+		return nil, nil
+	}
+	return &state, nil
+}
+
+func (s *memoryImportStorage) SaveImport(state ImportState) error {
+	s.st.mu.Lock()
+	defer s.st.mu.Unlock()
+
+	s.st.imports[state.FileID] = state
+	return nil
+}
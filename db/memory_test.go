@@ -0,0 +1,52 @@
+package db
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryStorage_concurrent(t *testing.T) {
+	storage := NewMemoryStorage()
+	start := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				_, err := storage.Auth().UpsetAuthEvent(AuthInfo{Status: AuthAccepted, Username: "sheb",
+					AuthMethod: "publickey", RemoteAddr: net.ParseIP(fmt.Sprintf("10.0.0.%d", i)), Port: 1000 + j,
+					PID: i*100 + j + 1, Date: start.Add(time.Duration(j) * time.Second)})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if _, err = storage.Auth().GetUserSessions("sheb"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	conns, err := storage.Auth().GetUserConnections("sheb")
+	if err != nil || len(conns) != 400 {
+		t.Errorf("GetUserConnections() got %d connections, error = %v, want 400", len(conns), err)
+	}
+	page, err := storage.Auth().Events(EventFilter{Limit: MaxEventsLimit})
+	if err != nil || len(page.Events) != 400 {
+		t.Errorf("Events() got %d events, error = %v, want 400", len(page.Events), err)
+	}
+
+	// the returned records are copies
+	sessions, _ := storage.Auth().GetUserSessions("sheb")
+	sessions[0].AuthMethods["publickey"] = 0
+	if sessions, _ = storage.Auth().GetUserSessions("sheb"); sessions[0].AuthMethods["publickey"] != 50 {
+		t.Errorf("GetUserSessions() got = %v, want the stored session unchanged", sessions[0].AuthMethods)
+	}
+}
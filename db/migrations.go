@@ -0,0 +1,415 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Migration upgrades the data of the database to the Version.
+type Migration struct {
+	Version     int
+	Description string
+	// Up changes the data in the transaction and returns the number of the changed records.
+	Up func(tx *bolt.Tx) (int, error)
+}
+
+// MigrationResult is the applied, or the pending on the dry run, migration.
+type MigrationResult struct {
+	File        string `json:"file"`
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	Changed     int    `json:"changed"`
+}
+
+// ErrSchemaTooNew is returned for the database written by the newer uwatch.
+type ErrSchemaTooNew struct {
+	File      string
+	Version   int
+	Supported int
+}
+
+func (e ErrSchemaTooNew) Error() string {
+	return fmt.Sprintf("%s has schema version %d, the supported version is %d", e.File, e.Version, e.Supported)
+}
+
+const (
+	// bucketMeta is the top-level bucket with the storage metadata.
+	bucketMeta       = "__meta"
+	keySchemaVersion = "schema_version"
+)
+
+// schema is the list of the migrations of the database file, ordered by the version.
+type schema struct {
+	file       string
+	migrations []Migration
+}
+
+// schemas are ordered as the Storage databases.
+var schemas = []schema{
+	{file: "auth.db", migrations: authMigrations},
+	{file: "tg.db", migrations: tgMigrations},
+	{file: "state.db"},
+}
+
+func (s schema) latest() int {
+	if len(s.migrations) == 0 {
+		return 0
+	}
+	return s.migrations[len(s.migrations)-1].Version
+}
+
+// SchemaVersion returns the version of the database, zero for the databases before the versioning.
+func SchemaVersion(tx *bolt.Tx) (int, error) {
+	bucket := tx.Bucket([]byte(bucketMeta))
+	if bucket == nil {
+		return 0, nil
+	}
+
+	raw := bucket.Get([]byte(keySchemaVersion))
+	if raw == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(string(raw))
+}
+
+func setSchemaVersion(tx *bolt.Tx, version int) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketMeta))
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(keySchemaVersion), []byte(strconv.Itoa(version)))
+}
+
+// migrate applies the pending migrations of the schema, each one in its own transaction.
+// The dry run applies them in a single transaction and rolls it back.
+func (s schema) migrate(db *bolt.DB, dryRun bool) (results []MigrationResult, err error) {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil || dryRun {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	version, err := SchemaVersion(tx)
+	if err != nil {
+		return
+	}
+	if version > s.latest() {
+		err = ErrSchemaTooNew{File: s.file, Version: version, Supported: s.latest()}
+		return
+	}
+	if version == s.latest() && tx.Bucket([]byte(bucketMeta)) != nil {
+		return
+	}
+
+	for _, migration := range s.migrations {
+		if migration.Version <= version {
+			continue
+		}
+
+		result := MigrationResult{File: s.file, Version: migration.Version, Description: migration.Description}
+		if result.Changed, err = migration.Up(tx); err != nil {
+			err = fmt.Errorf("%s migration %d: %s", s.file, migration.Version, err)
+			return
+		}
+		if err = setSchemaVersion(tx, migration.Version); err != nil {
+			return
+		}
+		results = append(results, result)
+
+		if dryRun {
+			continue
+		}
+		if err = tx.Commit(); err != nil {
+			return
+		}
+		if tx, err = db.Begin(true); err != nil {
+			return
+		}
+	}
+
+	// the new database gets the version without the migrations
+	err = setSchemaVersion(tx, s.latest())
+	return
+}
+
+// MigrateStorage upgrades the database files of the storage directory, NewStorage does it on the start.
+// The dry run returns the pending migrations and leaves the files intact.
+// The files must not be open, e.g. by the running watcher.
+func MigrateStorage(dbPath string, dryRun bool) ([]MigrationResult, error) {
+	var results []MigrationResult
+	for _, s := range schemas {
+		path := filepath.Join(dbPath, s.file)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			continue
+		}
+
+		db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 1 * time.Second})
+		if err != nil {
+			return results, err
+		}
+
+		applied, err := s.migrate(db, dryRun)
+		results = append(results, applied...)
+		if closeErr := db.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return results, err
+		}
+	}
+
+	return results, nil
+}
+
+var authMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "key the sessions by the canonical address, merge the sessions of the same address",
+		Up:          canonicalSessionKeys,
+	},
+	{
+		Version:     2,
+		Description: "move the user buckets to the __users bucket",
+		Up:          nestUserBuckets,
+	},
+}
+
+var tgMigrations = []Migration{
+	{
+		Version:     1,
+		Description: "rename the chat info fields to chat_id and muted",
+		Up:          tagChatInfo,
+	},
+}
+
+// canonicalSessionKeys re-keys the sessions written before the net.IP addresses,
+// their keys and addresses are the raw text of the log, like "::ffff:10.0.0.1".
+// The sessions without the valid address are removed, they can not be found by the address anyway.
+func canonicalSessionKeys(tx *bolt.Tx) (changed int, err error) {
+	users, err := legacyUserNames(tx)
+	if err != nil {
+		return
+	}
+
+	for _, name := range users {
+		userBucket := tx.Bucket(name)
+
+		var keys [][]byte
+		sessions := map[string]Session{}
+		err = userBucket.ForEach(func(k, v []byte) error {
+			// nested buckets have no value
+			if v == nil {
+				return nil
+			}
+
+			session, ok, err := decodeLegacySession(k, v)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				keys = append(keys, k)
+				return nil
+			}
+			key := string(addrKey(session.RemoteAddr))
+			if key == string(k) {
+				return nil
+			}
+
+			keys = append(keys, k)
+			if prev, found := sessions[key]; found {
+				session = mergeSessions(prev, session)
+			} else if raw := userBucket.Get([]byte(key)); raw != nil {
+				var current Session
+				if err := json.Unmarshal(raw, &current); err != nil {
+					return err
+				}
+				session = mergeSessions(current, session)
+			}
+			sessions[key] = session
+			return nil
+		})
+		if err != nil {
+			return
+		}
+
+		for _, k := range keys {
+			if err = userBucket.Delete(k); err != nil {
+				return
+			}
+		}
+		for key, session := range sessions {
+			var raw []byte
+			if raw, err = json.Marshal(session); err != nil {
+				return
+			}
+			if err = userBucket.Put([]byte(key), raw); err != nil {
+				return
+			}
+		}
+		changed += len(keys)
+	}
+
+	return
+}
+
+// legacyServiceBuckets are the top-level buckets of auth.db beside the user buckets before the version 2.
+var legacyServiceBuckets = map[string]bool{bucketMeta: true, bucketEvents: true, bucketUsers: true}
+
+// legacyUserNames returns the names of the top-level user buckets before the version 2.
+func legacyUserNames(tx *bolt.Tx) (names [][]byte, err error) {
+	err = tx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+		if !legacyServiceBuckets[string(name)] {
+			names = append(names, name)
+		}
+		return nil
+	})
+	return
+}
+
+// nestUserBuckets moves the top-level user buckets to bucketUsers, the usernames like "__events"
+// shared the namespace with the service buckets.
+func nestUserBuckets(tx *bolt.Tx) (changed int, err error) {
+	names, err := legacyUserNames(tx)
+	if err != nil {
+		return
+	}
+
+	for _, name := range names {
+		var userBucket *bolt.Bucket
+		if userBucket, err = createUserBucket(tx, name); err != nil {
+			return
+		}
+		if err = copyBucket(userBucket, tx.Bucket(name)); err != nil {
+			return
+		}
+		if err = tx.DeleteBucket(name); err != nil {
+			return
+		}
+		changed++
+	}
+	return
+}
+
+// decodeLegacySession decodes the session with the text address, ok is false if the address is invalid.
+func decodeLegacySession(key, raw []byte) (session Session, ok bool, err error) {
+	fields := map[string]json.RawMessage{}
+	if err = json.Unmarshal(raw, &fields); err != nil {
+		return
+	}
+
+	var addr string
+	if rawAddr, found := fields["remote_addr"]; found {
+		if err = json.Unmarshal(rawAddr, &addr); err != nil {
+			return
+		}
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		ip = net.ParseIP(string(key))
+	}
+	if ip == nil {
+		return session, false, nil
+	}
+	delete(fields, "remote_addr")
+
+	if raw, err = json.Marshal(fields); err != nil {
+		return
+	}
+	if err = json.Unmarshal(raw, &session); err != nil {
+		return
+	}
+	session.RemoteAddr = ip
+	if session.AuthMethods == nil {
+		session.AuthMethods = map[string]int32{}
+	}
+	return session, true, nil
+}
+
+// mergeSessions sums up the counters of the sessions of the same address.
+func mergeSessions(a, b Session) Session {
+	if b.ID < a.ID {
+		a.ID = b.ID
+	}
+	if b.lastActivity().After(a.lastActivity()) {
+		a.Status = b.Status
+	}
+	if a.AuthMethods == nil {
+		a.AuthMethods = map[string]int32{}
+	}
+	for method, count := range b.AuthMethods {
+		a.AuthMethods[method] += count
+	}
+	a.ConnsCount += b.ConnsCount
+	a.FailsCount += b.FailsCount
+	a.PreauthCount += b.PreauthCount
+
+	a.FirstLogInTime = earliestTime(a.FirstLogInTime, b.FirstLogInTime)
+	a.LastLogInTime = latestTime(a.LastLogInTime, b.LastLogInTime)
+	a.LastLogOutTime = latestTime(a.LastLogOutTime, b.LastLogOutTime)
+	a.LastAttemptTime = latestTime(a.LastAttemptTime, b.LastAttemptTime)
+	return a
+}
+
+func earliestTime(a, b *time.Time) *time.Time {
+	if a == nil || b != nil && b.Before(*a) {
+		return b
+	}
+	return a
+}
+
+func latestTime(a, b *time.Time) *time.Time {
+	if a == nil || b != nil && b.After(*a) {
+		return b
+	}
+	return a
+}
+
+// tagChatInfo rewrites the chat info written with the Go field names.
+func tagChatInfo(tx *bolt.Tx) (changed int, err error) {
+	bucket := tx.Bucket([]byte(bucketTGWhitelist))
+	if bucket == nil {
+		return
+	}
+
+	values := map[string][]byte{}
+	err = bucket.ForEach(func(k, v []byte) error {
+		var legacy struct {
+			ChatID *int64 `json:"ChatID"`
+			Muted  bool   `json:"Muted"`
+		}
+		if err := json.Unmarshal(v, &legacy); err != nil {
+			return err
+		}
+		// the record is already in the new layout
+		if legacy.ChatID == nil {
+			return nil
+		}
+
+		raw, err := json.Marshal(TGChatInfo{ChatID: *legacy.ChatID, Muted: legacy.Muted})
+		values[string(k)] = raw
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	for k, raw := range values {
+		if err = bucket.Put([]byte(k), raw); err != nil {
+			return
+		}
+	}
+	return len(values), nil
+}
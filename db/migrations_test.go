@@ -0,0 +1,360 @@
+package db
+
+import (
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// copyFixture copies the fixture databases to the temp dir, the migrations must not change the testdata.
+func copyFixture(t *testing.T, fixture string) string {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join("testdata", fixture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		raw, err := ioutil.ReadFile(filepath.Join("testdata", fixture, file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(filepath.Join(dir, file.Name()), raw, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func fileVersion(t *testing.T, path string) int {
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 1 * time.Second, ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var version int
+	err = db.View(func(tx *bolt.Tx) (err error) {
+		version, err = SchemaVersion(tx)
+		return
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+func TestMigrateStorage_dryRun(t *testing.T) {
+	dir := copyFixture(t, "v0")
+	defer os.RemoveAll(dir)
+
+	before, err := ioutil.ReadFile(filepath.Join(dir, "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := MigrateStorage(dir, true)
+	if err != nil {
+		t.Fatalf("MigrateStorage() error = %v", err)
+	}
+	want := []MigrationResult{
+		{File: "auth.db", Version: 1, Description: authMigrations[0].Description, Changed: 3},
+		{File: "auth.db", Version: 2, Description: authMigrations[1].Description, Changed: 3},
+		{File: "tg.db", Version: 1, Description: tgMigrations[0].Description, Changed: 2},
+	}
+	if !reflect.DeepEqual(results, want) {
+		t.Errorf("MigrateStorage() got = %+v, want %+v", results, want)
+	}
+
+	after, err := ioutil.ReadFile(filepath.Join(dir, "auth.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Error("MigrateStorage() dry run has changed auth.db")
+	}
+	if version := fileVersion(t, filepath.Join(dir, "tg.db")); version != 0 {
+		t.Errorf("tg.db version got = %d, want 0", version)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "state.db")); !os.IsNotExist(err) {
+		t.Errorf("MigrateStorage() dry run has created state.db, error = %v", err)
+	}
+}
+
+func TestMigrateStorage(t *testing.T) {
+	dir := copyFixture(t, "v0")
+	defer os.RemoveAll(dir)
+
+	results, err := MigrateStorage(dir, false)
+	if err != nil {
+		t.Fatalf("MigrateStorage() error = %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("MigrateStorage() got = %+v, want auth.db and tg.db", results)
+	}
+	for _, s := range schemas[:2] {
+		if version := fileVersion(t, filepath.Join(dir, s.file)); version != s.latest() {
+			t.Errorf("%s version got = %d, want %d", s.file, version, s.latest())
+		}
+	}
+
+	// the migrated files have nothing to upgrade
+	if results, err = MigrateStorage(dir, false); err != nil || len(results) != 0 {
+		t.Errorf("MigrateStorage() got = %+v, error = %v, want none", results, err)
+	}
+
+	storage, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	sessions, err := storage.Auth().GetUserSessions("sheb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	if len(sessions) != 2 {
+		t.Fatalf("GetUserSessions() got = %+v, want 2 sessions", sessions)
+	}
+
+	merged := sessions[0]
+	if !merged.RemoteAddr.Equal(net.ParseIP("10.0.0.1")) || merged.ConnsCount != 1 || merged.FailsCount != 2 ||
+		merged.Status != AuthDisconnected {
+		t.Errorf("merged session got = %+v", merged)
+	}
+	if !reflect.DeepEqual(merged.AuthMethods, map[string]int32{"publickey": 0, "password": 1}) {
+		t.Errorf("merged session auth methods got = %v", merged.AuthMethods)
+	}
+	if want := time.Date(2020, 5, 1, 10, 0, 0, 0, time.UTC); !merged.LastLogOutTime.Equal(want) {
+		t.Errorf("merged session logout time got = %v, want %v", merged.LastLogOutTime, want)
+	}
+	if sessions[1].RemoteAddr.String() != "2001:db8::1" || sessions[1].FailsCount != 1 {
+		t.Errorf("session got = %+v", sessions[1])
+	}
+
+	// the session of the same address is updated, not duplicated
+	_, err = storage.Auth().UpsetAuthEvent(AuthInfo{Status: AuthFailed, Username: "sheb",
+		RemoteAddr: net.ParseIP("::ffff:10.0.0.1"), Date: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sessions, err = storage.Auth().GetUserSessions("sheb"); err != nil || len(sessions) != 2 {
+		t.Errorf("GetUserSessions() got = %+v, error = %v, want 2 sessions", sessions, err)
+	}
+
+	if sessions, err = storage.Auth().GetUserSessions("root"); err != nil || len(sessions) != 0 {
+		t.Errorf("GetUserSessions() got = %+v, error = %v, want the invalid session removed", sessions, err)
+	}
+	if sessions, err = storage.Auth().GetUserSessions("admin"); err != nil || len(sessions) != 1 {
+		t.Errorf("GetUserSessions() got = %+v, error = %v, want the session kept", sessions, err)
+	}
+	if names, err := storage.Auth().Users(); err != nil || !reflect.DeepEqual(names, []string{"admin", "root", "sheb"}) {
+		t.Errorf("Users() got = %v, error = %v, want the moved users", names, err)
+	}
+
+	users, err := storage.TG().GetUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantUsers := map[string]TGChatInfo{
+		"sheb":  {ChatID: 1001},
+		"admin": {ChatID: 1002, Muted: true},
+	}
+	if !reflect.DeepEqual(users, wantUsers) {
+		t.Errorf("GetUsers() got = %+v, want %+v", users, wantUsers)
+	}
+}
+
+func TestMigrateStorage_serviceUsername(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the user bucket of the version 0 with the prefix of the service buckets
+	authDB, err := bolt.Open(filepath.Join(dir, "auth.db"), 0644, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = authDB.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucket([]byte("__bob"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("::ffff:10.0.0.1"), []byte(`{"id":1,"status":"Failed","remote_addr":"::ffff:10.0.0.1"}`))
+	})
+	if closeErr := authDB.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = MigrateStorage(dir, false); err != nil {
+		t.Fatalf("MigrateStorage() error = %v", err)
+	}
+
+	storage, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	// the session is re-keyed by the canonical address and updated, not duplicated
+	_, err = storage.Auth().UpsetAuthEvent(AuthInfo{Status: AuthFailed, Username: "__bob",
+		RemoteAddr: net.ParseIP("10.0.0.1"), Date: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := storage.Auth().GetUserSessions("__bob")
+	if err != nil || len(sessions) != 1 || sessions[0].FailsCount != 1 {
+		t.Errorf("GetUserSessions() got = %+v, error = %v, want the migrated session", sessions, err)
+	}
+}
+
+func TestNewStorage_schemaVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, s := range schemas {
+		if version := fileVersion(t, filepath.Join(dir, s.file)); version != s.latest() {
+			t.Errorf("%s version got = %d, want %d", s.file, version, s.latest())
+		}
+	}
+
+	// the database of the newer uwatch is left intact
+	authDB, err := bolt.Open(filepath.Join(dir, "auth.db"), 0644, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = authDB.Update(func(tx *bolt.Tx) error {
+		return setSchemaVersion(tx, schemas[0].latest()+1)
+	})
+	if closeErr := authDB.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewStorage(dir)
+	if _, ok := err.(ErrSchemaTooNew); !ok {
+		t.Errorf("NewStorage() error = %v, want ErrSchemaTooNew", err)
+	}
+}
+
+func TestNewStorage_metaUsername(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage, err := NewStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = storage.Auth().UpsetAuthEvent(AuthInfo{Status: AuthInvalidUser, Username: bucketMeta,
+		RemoteAddr: net.ParseIP("10.0.0.2"), Date: time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)})
+	if closeErr := storage.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the user of the same name leaves the metadata intact
+	if version := fileVersion(t, filepath.Join(dir, "auth.db")); version != schemas[0].latest() {
+		t.Errorf("auth.db version got = %d, want %d", version, schemas[0].latest())
+	}
+	if storage, err = NewStorage(dir); err != nil {
+		t.Fatalf("NewStorage() error = %v", err)
+	}
+	defer storage.Close()
+	if sessions, err := storage.Auth().GetUserSessions(bucketMeta); err != nil || len(sessions) != 1 {
+		t.Errorf("GetUserSessions() got = %+v, error = %v, want 1 session", sessions, err)
+	}
+}
+
+func TestNewSQLiteStorage_upgrade(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	storage, err := NewSQLiteStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = storage.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the schema of the version 1 has no geo columns
+	conn, err := sql.Open("sqlite", filepath.Join(dir, SQLiteFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = conn.Exec(`ALTER TABLE sessions DROP COLUMN geo;
+ALTER TABLE events DROP COLUMN geo;
+PRAGMA user_version = 1;`)
+	if closeErr := conn.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err = NewSQLiteStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Close()
+
+	info := AuthInfo{Status: AuthAccepted, Username: "sheb", AuthMethod: "password", RemoteAddr: net.ParseIP("81.2.69.142"),
+		Port: 1000, PID: 100, Date: time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC),
+		Geo: &GeoInfo{Country: "GB", City: "London", Latitude: 51.5142, Longitude: -0.0931, AccuracyRadius: 10}}
+	if _, err = storage.Auth().UpsetAuthEvent(info); err != nil {
+		t.Fatal(err)
+	}
+	sessions, err := storage.Auth().GetUserSessions("sheb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || !reflect.DeepEqual(sessions[0].Geo, info.Geo) {
+		t.Errorf("GetUserSessions() got = %+v, want the session with geo %+v", sessions, info.Geo)
+	}
+	page, err := storage.Auth().Events(EventFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Events) != 1 || !reflect.DeepEqual(page.Events[0].Geo, info.Geo) {
+		t.Errorf("Events() got = %+v, want the event with geo %+v", page.Events, info.Geo)
+	}
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// FilePosition is the offset of the next unread line in the log file.
+type FilePosition struct {
+	Path      string    `json:"path"`
+	Inode     uint64    `json:"inode"`
+	Offset    int64     `json:"offset"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+const bucketPositions = "positions"
+
+type positionStorage struct {
+	db *bolt.DB
+}
+
+func (st *positionStorage) GetPosition(path string) (pos *FilePosition, err error) {
+	tx, err := st.db.Begin(false)
+	if err != nil {
+		return
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	bucket := tx.Bucket([]byte(bucketPositions))
+	if bucket == nil {
+		return
+	}
+
+	raw := bucket.Get([]byte(path))
+	if raw == nil {
+		return
+	}
+
+	pos = &FilePosition{}
+	err = json.Unmarshal(raw, pos)
+	return
+}
+
+func (st *positionStorage) SavePosition(pos FilePosition) (err error) {
+	tx, err := st.db.Begin(true)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketPositions))
+	if err != nil {
+		return
+	}
+
+	raw, err := json.Marshal(pos)
+	if err != nil {
+		return
+	}
+
+	err = bucket.Put([]byte(pos.Path), raw)
+	return
+}
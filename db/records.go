@@ -0,0 +1,216 @@
+package db
+
+import (
+	"net"
+	"time"
+)
+
+// authRecords are the records of the user in the write transaction of the backend,
+// upsertAuthEvent is the same for all backends on top of them.
+type authRecords interface {
+	// connectionAddr returns the address of the latest connection with the host and the PID of the event.
+	connectionAddr(info AuthInfo) (net.IP, error)
+	// lastLoginAddr returns the address of the session with the latest login.
+	lastLoginAddr() (net.IP, error)
+	appendEvent(info AuthInfo) error
+
+	nextSessionID() (uint64, error)
+	// session returns the session of the address, nil if there is none.
+	session(addr net.IP) (*Session, error)
+	putSession(addr net.IP, session Session) error
+
+	// openConnections returns the open connections in the order of the IDs.
+	openConnections() ([]Connection, error)
+	putConnection(conn *Connection) error
+
+	// keyUsage returns the usage of the key, nil if there is none.
+	keyUsage(keyID string) (*KeyUsage, error)
+	putKeyUsage(keyID string, usage KeyUsage) error
+}
+
+// upsertAuthEvent appends the event to the log and updates the session of its address,
+// the event without the address and the earlier login of the user has no session.
+func upsertAuthEvent(records authRecords, info AuthInfo) (session Session, err error) {
+	// pam session lines have no address, they belong to the connection of the same sshd process
+	// or to the latest login of the user
+	if len(info.RemoteAddr) == 0 {
+		if info.RemoteAddr, err = records.connectionAddr(info); err != nil {
+			return
+		}
+	}
+	if len(info.RemoteAddr) == 0 {
+		if info.RemoteAddr, err = records.lastLoginAddr(); err != nil {
+			return
+		}
+	}
+
+	if err = records.appendEvent(info); err != nil {
+		return
+	}
+	if len(info.RemoteAddr) == 0 {
+		err = ErrSessionNotFound
+		return
+	}
+
+	sessionID, err := records.nextSessionID()
+	if err != nil {
+		return
+	}
+
+	stored, err := records.session(info.RemoteAddr)
+	if err != nil {
+		return
+	}
+	if stored == nil {
+		session = NewSession(sessionID, info)
+	} else {
+		session = *stored
+		session.Update(info)
+	}
+
+	// the session is the aggregate of the connections from the address
+	openConns, correlated, err := updateConnections(records, info)
+	if err != nil {
+		return
+	}
+	if correlated {
+		session.ConnsCount = openConns
+	}
+
+	if err = records.putSession(info.RemoteAddr, session); err != nil {
+		return
+	}
+
+	if info.Status == AuthAccepted && info.PublicKey != nil {
+		err = updateKeyUsage(records, info)
+	}
+	return
+}
+
+// updateConnections tracks the connection of the event and returns the number of the open
+// connections from the address, ok is false if the event is not correlated with a connection.
+func updateConnections(records authRecords, info AuthInfo) (count int32, ok bool, err error) {
+	var open []Connection
+	switch info.Status {
+	case AuthAccepted:
+		if info.PID == 0 {
+			return
+		}
+		if open, err = records.openConnections(); err != nil {
+			return
+		}
+
+		// the previous connection with the same PID has ended unnoticed
+		if prev := findConnection(open, AuthInfo{Host: info.Host, PID: info.PID}); prev != nil {
+			prev.Status = ConnectionLost
+			if err = records.putConnection(prev); err != nil {
+				return
+			}
+		}
+
+		conn := NewConnection(info)
+		if err = records.putConnection(&conn); err != nil {
+			return
+		}
+
+		return openCount(append(open, conn), info.RemoteAddr), true, nil
+	case AuthDisconnected, AuthReceivedDisconnect, AuthSessionClosed:
+		if open, err = records.openConnections(); err != nil {
+			return
+		}
+
+		conn := findConnection(open, info)
+		if conn == nil {
+			return
+		}
+
+		conn.Close(info.Date)
+		if err = records.putConnection(conn); err != nil {
+			return
+		}
+
+		return openCount(open, conn.RemoteAddr), true, nil
+	}
+
+	return
+}
+
+// findConnection returns the last open connection of the event, nil if there is none.
+func findConnection(conns []Connection, info AuthInfo) *Connection {
+	var found *Connection
+	for i := range conns {
+		if conns[i].Status == ConnectionOpen && conns[i].matches(info) {
+			found = &conns[i]
+		}
+	}
+	return found
+}
+
+// openCount returns the number of the open connections from the address.
+func openCount(conns []Connection, addr net.IP) (count int32) {
+	for _, conn := range conns {
+		if conn.Status == ConnectionOpen && conn.RemoteAddr.Equal(addr) {
+			count++
+		}
+	}
+	return
+}
+
+func updateKeyUsage(records authRecords, info AuthInfo) error {
+	keyID := info.PublicKey.ID()
+	usage, err := records.keyUsage(keyID)
+	if err != nil {
+		return err
+	}
+	if usage == nil {
+		usage = &KeyUsage{
+			PublicKey:     *info.PublicKey,
+			Username:      info.Username,
+			FirstUsedTime: info.Date,
+		}
+	}
+
+	usage.UsesCount += 1
+	usage.LastUsedTime = info.Date
+	usage.LastRemoteAddr = info.RemoteAddr
+	return records.putKeyUsage(keyID, *usage)
+}
+
+// authPruner removes the expired records of all users in the write transaction of the backend.
+type authPruner interface {
+	deleteSessions(expired func(Session) bool) (int, error)
+	deleteKeys(expired func(KeyUsage) bool) (int, error)
+	deleteConnections(expired func(Connection) bool) (int, error)
+	deleteEscalations(expired func(Escalation) bool) (int, error)
+	// deleteEvents removes the expired events of the log before the cutoff.
+	deleteEvents(before time.Time, expired func(AuthInfo) bool) (int, error)
+	// deleteEmptyUsers removes the users without the records.
+	deleteEmptyUsers() (int, error)
+}
+
+// pruneAuth is Prune of all backends.
+func pruneAuth(records authPruner, policy RetentionPolicy) (report PruneReport, err error) {
+	if report.Sessions, err = records.deleteSessions(policy.sessionExpired); err != nil {
+		return
+	}
+	if report.Keys, err = records.deleteKeys(policy.keyExpired); err != nil {
+		return
+	}
+	if report.Connections, err = records.deleteConnections(policy.connectionExpired); err != nil {
+		return
+	}
+	if report.Escalations, err = records.deleteEscalations(policy.escalationExpired); err != nil {
+		return
+	}
+
+	// the log is ordered by the date, only the events before the latest cutoff may be expired
+	if cutoff := policy.lastCutoff(); !cutoff.IsZero() {
+		if report.Events, err = records.deleteEvents(cutoff, policy.eventExpired); err != nil {
+			return
+		}
+	}
+
+	// the usernames tried by scanners leave nothing behind
+	report.Users, err = records.deleteEmptyUsers()
+	return
+}
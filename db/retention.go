@@ -0,0 +1,262 @@
+package db
+
+import (
+	"bytes"
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// RetentionPolicy removes the records older than the cutoffs, the zero cutoff keeps the records.
+type RetentionPolicy struct {
+	// FailedBefore is the cutoff of the failed attempts.
+	FailedBefore time.Time
+	// AcceptedBefore is the cutoff of the logins, connections, keys and escalations.
+	AcceptedBefore time.Time
+}
+
+func expired(date, cutoff time.Time) bool {
+	return !cutoff.IsZero() && date.Before(cutoff)
+}
+
+// PruneReport counts the removed records.
+type PruneReport struct {
+	Users       int `json:"users"`
+	Sessions    int `json:"sessions"`
+	Connections int `json:"connections"`
+	Keys        int `json:"keys"`
+	Escalations int `json:"escalations"`
+	Events      int `json:"events"`
+}
+
+func (r PruneReport) Total() int {
+	return r.Sessions + r.Connections + r.Keys + r.Escalations + r.Events
+}
+
+func (st *authStorage) Prune(policy RetentionPolicy) (report PruneReport, err error) {
+	tx, err := st.db.Begin(true)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	return pruneAuth(boltPruner{tx: tx}, policy)
+}
+
+// boltPruner removes the records of the user buckets.
+type boltPruner struct {
+	tx *bolt.Tx
+}
+
+// deleteExpired removes the expired values of the bucket of every user, path is the nested bucket.
+func (p boltPruner) deleteExpired(path string, isExpired func(raw []byte) (bool, error)) (count int, err error) {
+	users, err := userNames(p.tx)
+	if err != nil {
+		return
+	}
+
+	for _, name := range users {
+		bucket := findUserBucket(p.tx, name)
+		if path != "" {
+			bucket = bucket.Bucket([]byte(path))
+		}
+		if bucket == nil {
+			continue
+		}
+
+		n, err := deleteExpired(bucket, isExpired)
+		if err != nil {
+			return 0, err
+		}
+		count += n
+	}
+	return
+}
+
+func (p boltPruner) deleteSessions(expired func(Session) bool) (int, error) {
+	return p.deleteExpired("", func(raw []byte) (bool, error) {
+		var session Session
+		err := json.Unmarshal(raw, &session)
+		return err == nil && expired(session), err
+	})
+}
+
+func (p boltPruner) deleteKeys(expired func(KeyUsage) bool) (int, error) {
+	return p.deleteExpired(bucketUserKeys, func(raw []byte) (bool, error) {
+		var usage KeyUsage
+		err := json.Unmarshal(raw, &usage)
+		return err == nil && expired(usage), err
+	})
+}
+
+func (p boltPruner) deleteConnections(expired func(Connection) bool) (int, error) {
+	return p.deleteExpired(bucketUserConnections, func(raw []byte) (bool, error) {
+		var conn Connection
+		err := json.Unmarshal(raw, &conn)
+		return err == nil && expired(conn), err
+	})
+}
+
+func (p boltPruner) deleteEscalations(expired func(Escalation) bool) (int, error) {
+	return p.deleteExpired(bucketUserEscalations, func(raw []byte) (bool, error) {
+		var escalation Escalation
+		err := json.Unmarshal(raw, &escalation)
+		return err == nil && expired(escalation), err
+	})
+}
+
+// deleteEvents visits only the keys before the cutoff, the log is ordered by the date.
+func (p boltPruner) deleteEvents(before time.Time, expired func(AuthInfo) bool) (int, error) {
+	bucket := p.tx.Bucket([]byte(bucketEvents))
+	if bucket == nil {
+		return 0, nil
+	}
+
+	lastKey := timeKey(before)
+
+	var keys [][]byte
+	cursor := bucket.Cursor()
+	for k, v := cursor.First(); k != nil; k, v = cursor.Next() {
+		if bytes.Compare(k, lastKey) >= 0 {
+			break
+		}
+
+		var event AuthInfo
+		if err := json.Unmarshal(v, &event); err != nil {
+			return 0, err
+		}
+		if expired(event) {
+			keys = append(keys, k)
+		}
+	}
+
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+func (p boltPruner) deleteEmptyUsers() (count int, err error) {
+	users, err := userNames(p.tx)
+	if err != nil {
+		return
+	}
+
+	for _, name := range users {
+		if !isEmptyBucket(findUserBucket(p.tx, name)) {
+			continue
+		}
+		if err = p.tx.Bucket([]byte(bucketUsers)).DeleteBucket(name); err != nil {
+			return
+		}
+		count++
+	}
+	return
+}
+
+// sessionExpired keeps the sessions with the open connections.
+func (policy RetentionPolicy) sessionExpired(session Session) bool {
+	if session.ConnsCount > 0 {
+		return false
+	}
+	if session.FirstLogInTime == nil {
+		return expired(session.lastActivity(), policy.FailedBefore)
+	}
+	return expired(session.lastActivity(), policy.AcceptedBefore)
+}
+
+func (policy RetentionPolicy) keyExpired(usage KeyUsage) bool {
+	return expired(usage.LastUsedTime, policy.AcceptedBefore)
+}
+
+// connectionExpired keeps the open connections.
+func (policy RetentionPolicy) connectionExpired(conn Connection) bool {
+	if conn.Status == ConnectionOpen {
+		return false
+	}
+	end := conn.StartTime
+	if conn.EndTime != nil {
+		end = *conn.EndTime
+	}
+	return expired(end, policy.AcceptedBefore)
+}
+
+func (policy RetentionPolicy) escalationExpired(escalation Escalation) bool {
+	if escalation.Status.IsFailure() {
+		return expired(escalation.Date, policy.FailedBefore)
+	}
+	return expired(escalation.Date, policy.AcceptedBefore)
+}
+
+func (policy RetentionPolicy) eventExpired(event AuthInfo) bool {
+	if event.Status.IsFailure() {
+		return expired(event.Date, policy.FailedBefore)
+	}
+	return expired(event.Date, policy.AcceptedBefore)
+}
+
+// lastCutoff is the latest of the cutoffs, the older records may be expired.
+func (policy RetentionPolicy) lastCutoff() time.Time {
+	if policy.AcceptedBefore.After(policy.FailedBefore) {
+		return policy.AcceptedBefore
+	}
+	return policy.FailedBefore
+}
+
+// lastActivity returns the latest time of the session.
+func (s Session) lastActivity() time.Time {
+	var last time.Time
+	for _, t := range []*time.Time{s.FirstLogInTime, s.LastLogInTime, s.LastLogOutTime, s.LastAttemptTime} {
+		if t != nil && t.After(last) {
+			last = *t
+		}
+	}
+	return last
+}
+
+// deleteExpired removes the values of the bucket, the nested buckets are skipped.
+func deleteExpired(bucket *bolt.Bucket, isExpired func(raw []byte) (bool, error)) (int, error) {
+	var keys [][]byte
+	err := bucket.ForEach(func(k, v []byte) error {
+		if v == nil {
+			return nil
+		}
+
+		ok, err := isExpired(v)
+		if ok {
+			keys = append(keys, k)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, k := range keys {
+		if err := bucket.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// isEmptyBucket reports whether the bucket and its nested buckets have no values.
+func isEmptyBucket(bucket *bolt.Bucket) bool {
+	empty := true
+	_ = bucket.ForEach(func(k, _ []byte) error {
+		if nested := bucket.Bucket(k); nested == nil || !isEmptyBucket(nested) {
+			empty = false
+		}
+		return nil
+	})
+	return empty
+}
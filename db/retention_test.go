@@ -0,0 +1,89 @@
+package db
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func Test_authStorage_Prune(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	old, recent := now.AddDate(0, -2, 0), now.AddDate(0, 0, -1)
+	ancient := now.AddDate(-2, 0, 0)
+	key := &PublicKey{Type: "RSA", Fingerprint: "SHA256:aaa"}
+
+	boltDB, closeDB := openTestDB(t, "auth.db")
+	defer closeDB()
+	st := &authStorage{db: boltDB}
+
+	events := []AuthInfo{
+		// the scanner leaves nothing behind
+		{Status: AuthInvalidUser, Username: "oracle", RemoteAddr: net.ParseIP("10.0.0.9"), Date: old},
+		{Status: AuthFailed, Username: "oracle", AuthMethod: "password", RemoteAddr: net.ParseIP("10.0.0.9"), Date: old},
+		// the recent attempt is kept
+		{Status: AuthFailed, Username: "root", AuthMethod: "password", RemoteAddr: net.ParseIP("10.0.0.8"), Date: recent},
+		// the old login is kept by the accepted retention
+		{Status: AuthAccepted, Username: "sheb", AuthMethod: "publickey", PublicKey: key,
+			RemoteAddr: net.ParseIP("10.0.0.1"), Port: 1000, PID: 10, Date: old},
+		{Status: AuthDisconnected, Username: "sheb", RemoteAddr: net.ParseIP("10.0.0.1"), Port: 1000, Date: old},
+		// the ancient login is removed
+		{Status: AuthAccepted, Username: "sheb", AuthMethod: "password",
+			RemoteAddr: net.ParseIP("10.0.0.2"), Port: 1001, PID: 11, Date: ancient},
+		{Status: AuthDisconnected, Username: "sheb", RemoteAddr: net.ParseIP("10.0.0.2"), Port: 1001, Date: ancient},
+		// the open connection and its session are kept
+		{Status: AuthAccepted, Username: "deploy", AuthMethod: "publickey",
+			RemoteAddr: net.ParseIP("10.0.0.3"), Port: 1002, PID: 12, Date: ancient},
+	}
+	for _, event := range events {
+		if _, err := st.UpsetAuthEvent(event); err != nil {
+			t.Fatalf("UpsetAuthEvent() error = %v", err)
+		}
+	}
+
+	escalations := []Escalation{
+		{Tool: "sudo", Status: EscalationFailed, Username: "sheb", TargetUser: "root", Date: old},
+		{Tool: "sudo", Status: EscalationGranted, Username: "sheb", TargetUser: "root", Date: old},
+	}
+	for _, escalation := range escalations {
+		if _, err := st.AddEscalation(escalation); err != nil {
+			t.Fatalf("AddEscalation() error = %v", err)
+		}
+	}
+
+	policy := RetentionPolicy{FailedBefore: now.AddDate(0, 0, -30), AcceptedBefore: now.AddDate(-1, 0, 0)}
+	report, err := st.Prune(policy)
+	if err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+
+	want := PruneReport{Users: 1, Sessions: 2, Connections: 1, Escalations: 1, Events: 5}
+	if report != want {
+		t.Errorf("Prune() got = %+v, want %+v", report, want)
+	}
+
+	sessions, err := st.GetUserSessions("oracle")
+	if err != nil || sessions != nil {
+		t.Errorf("GetUserSessions() got = %v, %v", sessions, err)
+	}
+	sessions, err = st.GetUserSessions("sheb")
+	if err != nil || len(sessions) != 1 || !sessions[0].RemoteAddr.Equal(net.ParseIP("10.0.0.1")) {
+		t.Errorf("GetUserSessions() got = %v, %v", sessions, err)
+	}
+	conns, err := st.GetUserConnections("deploy")
+	if err != nil || len(conns) != 1 {
+		t.Errorf("GetUserConnections() got = %v, %v", conns, err)
+	}
+
+	page, err := st.Events(EventFilter{})
+	if err != nil {
+		t.Fatalf("Events() error = %v", err)
+	}
+	if len(page.Events) != len(events)-report.Events {
+		t.Errorf("Events() got %d events, want %d", len(page.Events), len(events)-report.Events)
+	}
+
+	// the repeated run removes nothing
+	if report, err = st.Prune(policy); err != nil || report.Total() != 0 || report.Users != 0 {
+		t.Errorf("Prune() got = %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,438 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	// the pure-Go driver builds without cgo
+	_ "modernc.org/sqlite"
+)
+
+const (
+	BackendBolt   = "bolt"
+	BackendSQLite = "sqlite"
+)
+
+// SQLiteFile is the database file of the sqlite storage in the storage directory.
+const SQLiteFile = "uwatch.sqlite"
+
+// Open opens the storage of the backend in the storage directory, the bolt one by default.
+// The MemoryPath directory keeps the records of any backend in memory.
+func Open(backend, dbPath string) (StorageI, error) {
+	if dbPath == MemoryPath {
+		return NewMemoryStorage(), nil
+	}
+
+	switch backend {
+	case "", BackendBolt:
+		return NewStorage(dbPath)
+	case BackendSQLite:
+		return NewSQLiteStorage(dbPath)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", backend)
+}
+
+// sqliteVersion is the user_version of the current sqlite schema.
+const sqliteVersion = 2
+
+// sqliteUpgrades[v] upgrades the schema of the version v-1 to v, the new databases get sqliteSchema.
+var sqliteUpgrades = map[int]string{
+	2: `ALTER TABLE sessions ADD COLUMN geo TEXT;
+ALTER TABLE events ADD COLUMN geo TEXT;`,
+}
+
+// SQLite Storage Schema, the addresses are in the canonical text form,
+// the times are RFC3339 text, the maps and the public keys are JSON text.
+// The username of the records logged before the username is known is UnknownUser.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	username       TEXT PRIMARY KEY,
+	session_seq    INTEGER NOT NULL DEFAULT 0,
+	connection_seq INTEGER NOT NULL DEFAULT 0,
+	escalation_seq INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS sessions (
+	username          TEXT NOT NULL,
+	remote_addr       TEXT NOT NULL,
+	id                INTEGER NOT NULL,
+	status            TEXT NOT NULL,
+	auth_methods      TEXT,
+	public_key        TEXT,
+	port              INTEGER NOT NULL DEFAULT 0,
+	protocol          TEXT NOT NULL DEFAULT '',
+	tty               TEXT NOT NULL DEFAULT '',
+	host              TEXT NOT NULL DEFAULT '',
+	labels            TEXT,
+	conns_count       INTEGER NOT NULL DEFAULT 0,
+	login_time        TEXT,
+	last_login_time   TEXT,
+	logout_time       TEXT,
+	fails_count       INTEGER NOT NULL DEFAULT 0,
+	preauth_count     INTEGER NOT NULL DEFAULT 0,
+	last_attempt_time TEXT,
+	geo               TEXT,
+	PRIMARY KEY (username, remote_addr)
+);
+
+CREATE TABLE IF NOT EXISTS keys (
+	username         TEXT NOT NULL,
+	key_id           TEXT NOT NULL,
+	type             TEXT NOT NULL,
+	fingerprint      TEXT NOT NULL,
+	cert_id          TEXT NOT NULL DEFAULT '',
+	cert_serial      INTEGER NOT NULL DEFAULT 0,
+	ca_type          TEXT NOT NULL DEFAULT '',
+	ca_fingerprint   TEXT NOT NULL DEFAULT '',
+	uses_count       INTEGER NOT NULL DEFAULT 0,
+	first_used_time  TEXT NOT NULL,
+	last_used_time   TEXT NOT NULL,
+	last_remote_addr TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (username, key_id)
+);
+
+CREATE TABLE IF NOT EXISTS connections (
+	username    TEXT NOT NULL,
+	id          INTEGER NOT NULL,
+	status      TEXT NOT NULL,
+	pid         INTEGER NOT NULL DEFAULT 0,
+	auth_method TEXT NOT NULL DEFAULT '',
+	public_key  TEXT,
+	remote_addr TEXT NOT NULL DEFAULT '',
+	port        INTEGER NOT NULL DEFAULT 0,
+	protocol    TEXT NOT NULL DEFAULT '',
+	host        TEXT NOT NULL DEFAULT '',
+	labels      TEXT,
+	start_time  TEXT NOT NULL,
+	end_time    TEXT,
+	duration    INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (username, id)
+);
+CREATE INDEX IF NOT EXISTS connections_status ON connections (username, status);
+
+CREATE TABLE IF NOT EXISTS escalations (
+	username    TEXT NOT NULL,
+	id          INTEGER NOT NULL,
+	tool        TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	target_user TEXT NOT NULL DEFAULT '',
+	tty         TEXT NOT NULL DEFAULT '',
+	pwd         TEXT NOT NULL DEFAULT '',
+	command     TEXT NOT NULL DEFAULT '',
+	attempts    INTEGER NOT NULL DEFAULT 0,
+	date        TEXT NOT NULL,
+	host        TEXT NOT NULL DEFAULT '',
+	labels      TEXT,
+	PRIMARY KEY (username, id)
+);
+
+CREATE TABLE IF NOT EXISTS events (
+	seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+	date_nano   INTEGER NOT NULL,
+	date        TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	username    TEXT NOT NULL DEFAULT '',
+	auth_method TEXT NOT NULL DEFAULT '',
+	public_key  TEXT,
+	remote_addr TEXT NOT NULL DEFAULT '',
+	port        INTEGER NOT NULL DEFAULT 0,
+	protocol    TEXT NOT NULL DEFAULT '',
+	pid         INTEGER NOT NULL DEFAULT 0,
+	tty         TEXT NOT NULL DEFAULT '',
+	host        TEXT NOT NULL DEFAULT '',
+	labels      TEXT,
+	geo         TEXT
+);
+CREATE INDEX IF NOT EXISTS events_date ON events (date_nano, seq);
+
+CREATE TABLE IF NOT EXISTS tg_whitelist (
+	username TEXT PRIMARY KEY,
+	chat_id  INTEGER NOT NULL,
+	muted    BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE TABLE IF NOT EXISTS positions (
+	path       TEXT PRIMARY KEY,
+	inode      INTEGER NOT NULL,
+	offset     INTEGER NOT NULL,
+	updated_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS imports (
+	file_id    TEXT PRIMARY KEY,
+	path       TEXT NOT NULL,
+	lines      INTEGER NOT NULL,
+	done       BOOLEAN NOT NULL,
+	updated_at TEXT NOT NULL
+);
+`
+
+// SQLiteStorage keeps all records in the single sqlite file, it can be queried by other tools.
+type SQLiteStorage struct {
+	db *sql.DB
+}
+
+func NewSQLiteStorage(dbPath string) (StorageI, error) {
+	if _, err := os.Stat(dbPath); os.IsNotExist(err) {
+		err = os.Mkdir(dbPath, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := sql.Open("sqlite", filepath.Join(dbPath, SQLiteFile)+"?_pragma=busy_timeout(1000)")
+	if err != nil {
+		return nil, err
+	}
+	// the writes are serialized as in bolt
+	db.SetMaxOpenConns(1)
+
+	st := &SQLiteStorage{db: db}
+	if err = st.migrate(); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return st, nil
+}
+
+// migrate creates the schema of the new database and upgrades the old one.
+func (st *SQLiteStorage) migrate() (err error) {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	var version int
+	if err = tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return
+	}
+	if version > sqliteVersion {
+		err = ErrSchemaTooNew{File: SQLiteFile, Version: version, Supported: sqliteVersion}
+		return
+	}
+	if version == sqliteVersion {
+		return
+	}
+
+	if version == 0 {
+		_, err = tx.Exec(sqliteSchema)
+	}
+	for v := version + 1; version > 0 && v <= sqliteVersion && err == nil; v++ {
+		_, err = tx.Exec(sqliteUpgrades[v])
+	}
+	if err != nil {
+		return
+	}
+	_, err = tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", sqliteVersion))
+	return
+}
+
+func (st *SQLiteStorage) Auth() AuthStorage {
+	return &sqliteAuthStorage{db: st.db}
+}
+
+func (st *SQLiteStorage) TG() TGStorage {
+	return &sqliteTGStorage{db: st.db}
+}
+
+func (st *SQLiteStorage) Slack() SlackStorage {
+	// todo:
+	return nil
+}
+
+func (st *SQLiteStorage) Positions() PositionStorage {
+	return &sqlitePositionStorage{db: st.db}
+}
+
+func (st *SQLiteStorage) Imports() ImportStorage {
+	return &sqliteImportStorage{db: st.db}
+}
+
+func (st *SQLiteStorage) Close() error {
+	return st.db.Close()
+}
+
+// sqlTime is the text of the time column, it keeps the zone offset like the JSON of the bolt records.
+func sqlTime(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+func sqlNullTime(t *time.Time) sql.NullString {
+	if t == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: sqlTime(*t), Valid: true}
+}
+
+func parseSQLTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func parseSQLNullTime(s sql.NullString) (*time.Time, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	t, err := parseSQLTime(s.String)
+	return &t, err
+}
+
+// sqlJSON is the text of the JSON column, the empty value is NULL like the omitted JSON field.
+func sqlJSON(v interface{}, empty bool) (sql.NullString, error) {
+	if empty {
+		return sql.NullString{}, nil
+	}
+	raw, err := json.Marshal(v)
+	return sql.NullString{String: string(raw), Valid: true}, err
+}
+
+func parseSQLJSON(s sql.NullString, v interface{}) error {
+	if !s.Valid {
+		return nil
+	}
+	return json.Unmarshal([]byte(s.String), v)
+}
+
+// rowScanner is the sql.Row or the sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type sqliteTGStorage struct {
+	db *sql.DB
+}
+
+func (st *sqliteTGStorage) AddUser(username string, chatID int64) error {
+	_, err := st.db.Exec("INSERT OR REPLACE INTO tg_whitelist (username, chat_id, muted) VALUES (?, ?, FALSE)",
+		username, chatID)
+	return err
+}
+
+func (st *sqliteTGStorage) Mute(username string, chatID int64) error {
+	_, err := st.db.Exec("INSERT OR REPLACE INTO tg_whitelist (username, chat_id, muted) VALUES (?, ?, TRUE)",
+		username, chatID)
+	return err
+}
+
+func (st *sqliteTGStorage) GetUsers() (users map[string]TGChatInfo, err error) {
+	rows, err := st.db.Query("SELECT username, chat_id, muted FROM tg_whitelist")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	users = map[string]TGChatInfo{}
+	for rows.Next() {
+		var username string
+		var info TGChatInfo
+		if err = rows.Scan(&username, &info.ChatID, &info.Muted); err != nil {
+			return
+		}
+		users[username] = info
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (st *sqliteTGStorage) GetUser(username string) (info TGChatInfo, err error) {
+	err = st.db.QueryRow("SELECT chat_id, muted FROM tg_whitelist WHERE username = ?", username).
+		Scan(&info.ChatID, &info.Muted)
+	if err == sql.ErrNoRows {
+		err = nil
+	}
+	return
+}
+
+type sqlitePositionStorage struct {
+	db *sql.DB
+}
+
+func (st *sqlitePositionStorage) GetPosition(path string) (*FilePosition, error) {
+	pos := &FilePosition{}
+	var updatedAt string
+	err := st.db.QueryRow("SELECT path, inode, offset, updated_at FROM positions WHERE path = ?", path).
+		Scan(&pos.Path, &pos.Inode, &pos.Offset, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pos.UpdatedAt, err = parseSQLTime(updatedAt)
+	return pos, err
+}
+
+func (st *sqlitePositionStorage) SavePosition(pos FilePosition) error {
+	_, err := st.db.Exec("INSERT OR REPLACE INTO positions (path, inode, offset, updated_at) VALUES (?, ?, ?, ?)",
+		pos.Path, pos.Inode, pos.Offset, sqlTime(pos.UpdatedAt))
+	return err
+}
+
+type sqliteImportStorage struct {
+	db *sql.DB
+}
+
+func (st *sqliteImportStorage) GetImport(fileID string) (*ImportState, error) {
+	state := &ImportState{}
+	var updatedAt string
+	err := st.db.QueryRow("SELECT file_id, path, lines, done, updated_at FROM imports WHERE file_id = ?", fileID).
+		Scan(&state.FileID, &state.Path, &state.Lines, &state.Done, &updatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state.UpdatedAt, err = parseSQLTime(updatedAt)
+	return state, err
+}
+
+func (st *sqliteImportStorage) SaveImport(state ImportState) error {
+	_, err := st.db.Exec("INSERT OR REPLACE INTO imports (file_id, path, lines, done, updated_at) VALUES (?, ?, ?, ?, ?)",
+		state.FileID, state.Path, state.Lines, state.Done, sqlTime(state.UpdatedAt))
+	return err
+}
+
+// CompactSQLite rebuilds the sqlite file of the storage directory to reclaim the space freed by the retention.
+func CompactSQLite(dbPath string) (report CompactReport, err error) {
+	path := filepath.Join(dbPath, SQLiteFile)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return report, nil
+	}
+	if err != nil {
+		return
+	}
+	report.SizeBefore = info.Size()
+
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(1000)")
+	if err != nil {
+		return
+	}
+	_, err = db.Exec("VACUUM")
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+
+	if info, err = os.Stat(path); err != nil {
+		return
+	}
+	report.SizeAfter = info.Size()
+	return
+}
@@ -0,0 +1,830 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+type sqliteAuthStorage struct {
+	db *sql.DB
+}
+
+// sqlUsername is the username of the stored event as in the bolt user buckets.
+func sqlUsername(username string) string {
+	if username == "" {
+		return UnknownUser
+	}
+	return username
+}
+
+// recordUsername is the username of the record, it is empty in the records of UnknownUser.
+func recordUsername(username string) string {
+	if username == UnknownUser {
+		return ""
+	}
+	return username
+}
+
+func sqlAddr(ip net.IP) string {
+	return string(addrKey(ip))
+}
+
+// nextSequence increments the sequence of the user, the sequences are reset with the removed user.
+func nextSequence(tx *sql.Tx, username, column string) (seq uint64, err error) {
+	_, err = tx.Exec("INSERT OR IGNORE INTO users (username) VALUES (?)", username)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec("UPDATE users SET "+column+" = "+column+" + 1 WHERE username = ?", username)
+	if err != nil {
+		return
+	}
+
+	err = tx.QueryRow("SELECT "+column+" FROM users WHERE username = ?", username).Scan(&seq)
+	return
+}
+
+func (st *sqliteAuthStorage) UpsetAuthEvent(authInfo AuthInfo) (session Session, err error) {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		// the event without the session is stored anyway
+		if err != nil && err != ErrSessionNotFound {
+			_ = tx.Rollback()
+			return
+		}
+		if commitErr := tx.Commit(); commitErr != nil {
+			err = commitErr
+		}
+	}()
+
+	session, err = upsertAuthEvent(sqliteRecords{tx: tx, username: sqlUsername(authInfo.Username)}, authInfo)
+	return
+}
+
+// sqliteRecords are the rows of the user.
+type sqliteRecords struct {
+	tx       *sql.Tx
+	username string
+}
+
+func (r sqliteRecords) connectionAddr(info AuthInfo) (net.IP, error) {
+	if info.PID == 0 {
+		return nil, nil
+	}
+
+	var addr string
+	err := r.tx.QueryRow("SELECT remote_addr FROM connections WHERE username = ? AND host = ? AND pid = ? ORDER BY id DESC LIMIT 1",
+		r.username, info.Host, info.PID).Scan(&addr)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return net.ParseIP(addr), err
+}
+
+func (r sqliteRecords) lastLoginAddr() (net.IP, error) {
+	return sqliteLastLoginAddr(r.tx, r.username)
+}
+
+func (r sqliteRecords) appendEvent(info AuthInfo) error {
+	return appendSQLEvent(r.tx, 0, info)
+}
+
+func (r sqliteRecords) nextSessionID() (uint64, error) {
+	return nextSequence(r.tx, r.username, "session_seq")
+}
+
+func (r sqliteRecords) session(addr net.IP) (*Session, error) {
+	row := r.tx.QueryRow("SELECT "+sqlSessionColumns+" FROM sessions WHERE username = ? AND remote_addr = ?",
+		r.username, sqlAddr(addr))
+	session, err := scanSession(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &session, err
+}
+
+func (r sqliteRecords) putSession(addr net.IP, session Session) error {
+	return putSQLSession(r.tx, r.username, sqlAddr(addr), session)
+}
+
+func (r sqliteRecords) openConnections() (conns []Connection, err error) {
+	rows, err := r.tx.Query("SELECT "+sqlConnectionColumns+" FROM connections WHERE username = ? AND status = ? ORDER BY id",
+		r.username, ConnectionOpen)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var conn Connection
+		if conn, err = scanConnection(rows); err != nil {
+			return
+		}
+		conns = append(conns, conn)
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (r sqliteRecords) putConnection(conn *Connection) error {
+	return putSQLConnection(r.tx, r.username, conn)
+}
+
+func (r sqliteRecords) keyUsage(keyID string) (*KeyUsage, error) {
+	row := r.tx.QueryRow("SELECT "+sqlKeyColumns+" FROM keys WHERE username = ? AND key_id = ?", r.username, keyID)
+	usage, err := scanKeyUsage(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return &usage, err
+}
+
+func (r sqliteRecords) putKeyUsage(keyID string, usage KeyUsage) error {
+	return insertSQLKeyUsage(r.tx, r.username, keyID, usage)
+}
+
+const sqlSessionColumns = `id, status, username, auth_methods, public_key, remote_addr, port, protocol, tty, host, labels,
+	conns_count, login_time, last_login_time, logout_time, fails_count, preauth_count, last_attempt_time, geo`
+
+func scanSession(row rowScanner) (s Session, err error) {
+	var authMethods, publicKey, labels, geo sql.NullString
+	var addr string
+	var firstLogIn, lastLogIn, lastLogOut, lastAttempt sql.NullString
+	err = row.Scan(&s.ID, &s.Status, &s.Username, &authMethods, &publicKey, &addr, &s.Port, &s.Protocol, &s.TTY,
+		&s.Host, &labels, &s.ConnsCount, &firstLogIn, &lastLogIn, &lastLogOut, &s.FailsCount, &s.PreauthCount, &lastAttempt,
+		&geo)
+	if err != nil {
+		return
+	}
+
+	s.Username = recordUsername(s.Username)
+	s.RemoteAddr = net.ParseIP(addr)
+	if err = parseSQLJSON(authMethods, &s.AuthMethods); err != nil {
+		return
+	}
+	if err = parseSQLJSON(publicKey, &s.PublicKey); err != nil {
+		return
+	}
+	if err = parseSQLJSON(labels, &s.Labels); err != nil {
+		return
+	}
+	if err = parseSQLJSON(geo, &s.Geo); err != nil {
+		return
+	}
+
+	if s.FirstLogInTime, err = parseSQLNullTime(firstLogIn); err != nil {
+		return
+	}
+	if s.LastLogInTime, err = parseSQLNullTime(lastLogIn); err != nil {
+		return
+	}
+	if s.LastLogOutTime, err = parseSQLNullTime(lastLogOut); err != nil {
+		return
+	}
+	s.LastAttemptTime, err = parseSQLNullTime(lastAttempt)
+	return
+}
+
+func putSQLSession(tx *sql.Tx, username, addr string, s Session) error {
+	authMethods, err := sqlJSON(s.AuthMethods, len(s.AuthMethods) == 0)
+	if err != nil {
+		return err
+	}
+	publicKey, err := sqlJSON(s.PublicKey, s.PublicKey == nil)
+	if err != nil {
+		return err
+	}
+	labels, err := sqlJSON(s.Labels, len(s.Labels) == 0)
+	if err != nil {
+		return err
+	}
+	geo, err := sqlJSON(s.Geo, s.Geo == nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT OR REPLACE INTO sessions ("+sqlSessionColumns+
+		") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		s.ID, s.Status, username, authMethods, publicKey, addr, s.Port, s.Protocol, s.TTY, s.Host, labels,
+		s.ConnsCount, sqlNullTime(s.FirstLogInTime), sqlNullTime(s.LastLogInTime), sqlNullTime(s.LastLogOutTime),
+		s.FailsCount, s.PreauthCount, sqlNullTime(s.LastAttemptTime), geo)
+	return err
+}
+
+func sqliteLastLoginAddr(tx *sql.Tx, username string) (net.IP, error) {
+	rows, err := tx.Query("SELECT remote_addr, last_login_time FROM sessions WHERE username = ? AND last_login_time IS NOT NULL ORDER BY remote_addr",
+		username)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = rows.Close() }()
+
+	var addr net.IP
+	var lastLogin time.Time
+	for rows.Next() {
+		var sessionAddr, rawTime string
+		if err := rows.Scan(&sessionAddr, &rawTime); err != nil {
+			return nil, err
+		}
+		loginTime, err := parseSQLTime(rawTime)
+		if err != nil {
+			continue
+		}
+		if loginTime.After(lastLogin) {
+			lastLogin = loginTime
+			addr = net.ParseIP(sessionAddr)
+		}
+	}
+
+	return addr, rows.Err()
+}
+
+const sqlConnectionColumns = `id, status, pid, username, auth_method, public_key, remote_addr, port, protocol, host, labels,
+	start_time, end_time, duration`
+
+func scanConnection(row rowScanner) (c Connection, err error) {
+	var publicKey, labels, endTime sql.NullString
+	var addr, startTime string
+	err = row.Scan(&c.ID, &c.Status, &c.PID, &c.Username, &c.AuthMethod, &publicKey, &addr, &c.Port, &c.Protocol,
+		&c.Host, &labels, &startTime, &endTime, &c.Duration)
+	if err != nil {
+		return
+	}
+
+	c.Username = recordUsername(c.Username)
+	c.RemoteAddr = net.ParseIP(addr)
+	if err = parseSQLJSON(publicKey, &c.PublicKey); err != nil {
+		return
+	}
+	if err = parseSQLJSON(labels, &c.Labels); err != nil {
+		return
+	}
+	if c.StartTime, err = parseSQLTime(startTime); err != nil {
+		return
+	}
+	c.EndTime, err = parseSQLNullTime(endTime)
+	return
+}
+
+func putSQLConnection(tx *sql.Tx, username string, c *Connection) (err error) {
+	if c.ID == 0 {
+		if c.ID, err = nextSequence(tx, username, "connection_seq"); err != nil {
+			return
+		}
+	}
+
+	publicKey, err := sqlJSON(c.PublicKey, c.PublicKey == nil)
+	if err != nil {
+		return
+	}
+	labels, err := sqlJSON(c.Labels, len(c.Labels) == 0)
+	if err != nil {
+		return
+	}
+
+	_, err = tx.Exec("INSERT OR REPLACE INTO connections ("+sqlConnectionColumns+
+		") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		c.ID, c.Status, c.PID, username, c.AuthMethod, publicKey, sqlAddr(c.RemoteAddr), c.Port, c.Protocol,
+		c.Host, labels, sqlTime(c.StartTime), sqlNullTime(c.EndTime), c.Duration)
+	return
+}
+
+const sqlEventColumns = `seq, date_nano, date, status, username, auth_method, public_key, remote_addr, port, protocol,
+	pid, tty, host, labels, geo`
+
+// appendSQLEvent inserts the event, zero seq is assigned by the events sequence.
+func appendSQLEvent(tx *sql.Tx, seq uint64, info AuthInfo) error {
+	publicKey, err := sqlJSON(info.PublicKey, info.PublicKey == nil)
+	if err != nil {
+		return err
+	}
+	labels, err := sqlJSON(info.Labels, len(info.Labels) == 0)
+	if err != nil {
+		return err
+	}
+	geo, err := sqlJSON(info.Geo, info.Geo == nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO events ("+sqlEventColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		sql.NullInt64{Int64: int64(seq), Valid: seq != 0}, info.Date.UnixNano(), sqlTime(info.Date), info.Status, info.Username, info.AuthMethod, publicKey,
+		sqlAddr(info.RemoteAddr), info.Port, info.Protocol, info.PID, info.TTY, info.Host, labels, geo)
+	return err
+}
+
+func scanEvent(row rowScanner) (event AuthEvent, err error) {
+	var seq uint64
+	var dateNano int64
+	var date, addr string
+	var publicKey, labels, geo sql.NullString
+	err = row.Scan(&seq, &dateNano, &date, &event.Status, &event.Username, &event.AuthMethod, &publicKey, &addr,
+		&event.Port, &event.Protocol, &event.PID, &event.TTY, &event.Host, &labels, &geo)
+	if err != nil {
+		return
+	}
+
+	event.ID = hex.EncodeToString(eventKey(time.Unix(0, dateNano), seq))
+	event.RemoteAddr = net.ParseIP(addr)
+	if err = parseSQLJSON(publicKey, &event.PublicKey); err != nil {
+		return
+	}
+	if err = parseSQLJSON(labels, &event.Labels); err != nil {
+		return
+	}
+	if err = parseSQLJSON(geo, &event.Geo); err != nil {
+		return
+	}
+	event.Date, err = parseSQLTime(date)
+	return
+}
+
+func (st *sqliteAuthStorage) Events(filter EventFilter) (page EventsPage, err error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultEventsLimit
+	}
+	if limit > MaxEventsLimit {
+		limit = MaxEventsLimit
+	}
+
+	var where []string
+	var args []interface{}
+	if filter.Cursor != "" {
+		cursorKey, err := hex.DecodeString(filter.Cursor)
+		if err != nil || len(cursorKey) != 16 {
+			return page, ErrInvalidCursor
+		}
+		cursorNano, cursorSeq := int64(binary.BigEndian.Uint64(cursorKey)), binary.BigEndian.Uint64(cursorKey[8:])
+
+		op := ">"
+		if filter.Descending {
+			op = "<"
+		}
+		where = append(where, "(date_nano "+op+" ? OR date_nano = ? AND seq "+op+" ?)")
+		args = append(args, cursorNano, cursorNano, cursorSeq)
+	}
+
+	// the cursor replaces the start of the time range as in the bolt storage
+	if !filter.From.IsZero() && (filter.Cursor == "" || filter.Descending) {
+		where = append(where, "date_nano >= ?")
+		args = append(args, filter.From.UnixNano())
+	}
+	if !filter.To.IsZero() && (filter.Cursor == "" || !filter.Descending) {
+		where = append(where, "date_nano < ?")
+		args = append(args, filter.To.UnixNano())
+	}
+	if filter.Username != "" {
+		where = append(where, "username = ?")
+		args = append(args, filter.Username)
+	}
+	if len(filter.RemoteAddr) != 0 {
+		where = append(where, "remote_addr = ?")
+		args = append(args, sqlAddr(filter.RemoteAddr))
+	}
+	if len(filter.Statuses) != 0 {
+		where = append(where, "status IN (?"+strings.Repeat(", ?", len(filter.Statuses)-1)+")")
+		for _, status := range filter.Statuses {
+			args = append(args, status)
+		}
+	}
+
+	query := "SELECT " + sqlEventColumns + " FROM events"
+	if len(where) != 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	if filter.Descending {
+		query += " ORDER BY date_nano DESC, seq DESC LIMIT ?"
+	} else {
+		query += " ORDER BY date_nano, seq LIMIT ?"
+	}
+	args = append(args, limit+1)
+
+	rows, err := st.db.Query(query, args...)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		if len(page.Events) == limit {
+			page.Next = page.Events[limit-1].ID
+			break
+		}
+
+		var event AuthEvent
+		if event, err = scanEvent(rows); err != nil {
+			return
+		}
+		page.Events = append(page.Events, event)
+	}
+
+	err = rows.Err()
+	return
+}
+
+const sqlKeyColumns = `type, fingerprint, cert_id, cert_serial, ca_type, ca_fingerprint, username, uses_count,
+	first_used_time, last_used_time, last_remote_addr`
+
+func scanKeyUsage(row rowScanner) (usage KeyUsage, err error) {
+	var firstUsed, lastUsed, addr string
+	err = row.Scan(&usage.Type, &usage.Fingerprint, &usage.CertID, &usage.CertSerial, &usage.CAType,
+		&usage.CAFingerprint, &usage.Username, &usage.UsesCount, &firstUsed, &lastUsed, &addr)
+	if err != nil {
+		return
+	}
+
+	usage.Username = recordUsername(usage.Username)
+	usage.LastRemoteAddr = net.ParseIP(addr)
+	if usage.FirstUsedTime, err = parseSQLTime(firstUsed); err != nil {
+		return
+	}
+	usage.LastUsedTime, err = parseSQLTime(lastUsed)
+	return
+}
+
+func insertSQLKeyUsage(tx *sql.Tx, username, keyID string, usage KeyUsage) error {
+	_, err := tx.Exec("INSERT OR REPLACE INTO keys (key_id, "+sqlKeyColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		keyID, usage.Type, usage.Fingerprint, usage.CertID, usage.CertSerial, usage.CAType, usage.CAFingerprint,
+		username, usage.UsesCount, sqlTime(usage.FirstUsedTime), sqlTime(usage.LastUsedTime), sqlAddr(usage.LastRemoteAddr))
+	return err
+}
+
+func (st *sqliteAuthStorage) GetUserSessions(username string) (sessions []Session, err error) {
+	rows, err := st.db.Query("SELECT "+sqlSessionColumns+" FROM sessions WHERE username = ? ORDER BY remote_addr", username)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var session Session
+		if session, err = scanSession(rows); err != nil {
+			return
+		}
+		sessions = append(sessions, session)
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (st *sqliteAuthStorage) GetUserKeys(username string) (keys []KeyUsage, err error) {
+	rows, err := st.db.Query("SELECT "+sqlKeyColumns+" FROM keys WHERE username = ? ORDER BY key_id", username)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var usage KeyUsage
+		if usage, err = scanKeyUsage(rows); err != nil {
+			return
+		}
+		keys = append(keys, usage)
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (st *sqliteAuthStorage) GetUserConnections(username string) (conns []Connection, err error) {
+	rows, err := st.db.Query("SELECT "+sqlConnectionColumns+" FROM connections WHERE username = ? ORDER BY id", username)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var conn Connection
+		if conn, err = scanConnection(rows); err != nil {
+			return
+		}
+		conns = append(conns, conn)
+	}
+
+	err = rows.Err()
+	return
+}
+
+const sqlEscalationColumns = `id, tool, status, username, target_user, tty, pwd, command, attempts, date, host, labels`
+
+func scanEscalation(row rowScanner) (e Escalation, err error) {
+	var date string
+	var labels sql.NullString
+	err = row.Scan(&e.ID, &e.Tool, &e.Status, &e.Username, &e.TargetUser, &e.TTY, &e.PWD, &e.Command, &e.Attempts,
+		&date, &e.Host, &labels)
+	if err != nil {
+		return
+	}
+
+	e.Username = recordUsername(e.Username)
+	if err = parseSQLJSON(labels, &e.Labels); err != nil {
+		return
+	}
+	e.Date, err = parseSQLTime(date)
+	return
+}
+
+func (st *sqliteAuthStorage) AddEscalation(escalation Escalation) (saved Escalation, err error) {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	username := sqlUsername(escalation.Username)
+	if escalation.ID, err = nextSequence(tx, username, "escalation_seq"); err != nil {
+		return
+	}
+
+	if err = insertSQLEscalation(tx, username, escalation); err != nil {
+		return
+	}
+
+	saved = escalation
+	return
+}
+
+func insertSQLEscalation(tx *sql.Tx, username string, e Escalation) error {
+	labels, err := sqlJSON(e.Labels, len(e.Labels) == 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("INSERT INTO escalations ("+sqlEscalationColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		e.ID, e.Tool, e.Status, username, e.TargetUser, e.TTY, e.PWD, e.Command, e.Attempts, sqlTime(e.Date), e.Host, labels)
+	return err
+}
+
+func (st *sqliteAuthStorage) GetUserEscalations(username string) (escalations []Escalation, err error) {
+	rows, err := st.db.Query("SELECT "+sqlEscalationColumns+" FROM escalations WHERE username = ? ORDER BY id", username)
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var escalation Escalation
+		if escalation, err = scanEscalation(rows); err != nil {
+			return
+		}
+		escalations = append(escalations, escalation)
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (st *sqliteAuthStorage) Prune(policy RetentionPolicy) (report PruneReport, err error) {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	return pruneAuth(sqlitePruner{tx: tx}, policy)
+}
+
+// sqlitePruner removes the rows of all users.
+type sqlitePruner struct {
+	tx *sql.Tx
+}
+
+func (p sqlitePruner) deleteSessions(expired func(Session) bool) (int, error) {
+	return deleteExpiredRows(p.tx, "sessions", "username, remote_addr", sqlSessionColumns,
+		func(row rowScanner) (bool, error) {
+			session, err := scanSession(row)
+			return err == nil && expired(session), err
+		})
+}
+
+func (p sqlitePruner) deleteKeys(expired func(KeyUsage) bool) (int, error) {
+	return deleteExpiredRows(p.tx, "keys", "username, key_id", sqlKeyColumns,
+		func(row rowScanner) (bool, error) {
+			usage, err := scanKeyUsage(row)
+			return err == nil && expired(usage), err
+		})
+}
+
+func (p sqlitePruner) deleteConnections(expired func(Connection) bool) (int, error) {
+	return deleteExpiredRows(p.tx, "connections", "username, id", sqlConnectionColumns,
+		func(row rowScanner) (bool, error) {
+			conn, err := scanConnection(row)
+			return err == nil && expired(conn), err
+		})
+}
+
+func (p sqlitePruner) deleteEscalations(expired func(Escalation) bool) (int, error) {
+	return deleteExpiredRows(p.tx, "escalations", "username, id", sqlEscalationColumns,
+		func(row rowScanner) (bool, error) {
+			escalation, err := scanEscalation(row)
+			return err == nil && expired(escalation), err
+		})
+}
+
+func (p sqlitePruner) deleteEvents(before time.Time, expired func(AuthInfo) bool) (int, error) {
+	return deleteExpiredRows(p.tx, "events WHERE date_nano < ?", "seq", sqlEventColumns,
+		func(row rowScanner) (bool, error) {
+			event, err := scanEvent(row)
+			return err == nil && expired(event.AuthInfo), err
+		}, before.UnixNano())
+}
+
+func (p sqlitePruner) deleteEmptyUsers() (int, error) {
+	result, err := p.tx.Exec(`DELETE FROM users WHERE
+		NOT EXISTS (SELECT 1 FROM sessions WHERE sessions.username = users.username) AND
+		NOT EXISTS (SELECT 1 FROM keys WHERE keys.username = users.username) AND
+		NOT EXISTS (SELECT 1 FROM connections WHERE connections.username = users.username) AND
+		NOT EXISTS (SELECT 1 FROM escalations WHERE escalations.username = users.username)`)
+	if err != nil {
+		return 0, err
+	}
+	users, err := result.RowsAffected()
+	return int(users), err
+}
+
+// deleteExpiredRows removes the expired rows of the table, the rows are identified by the key columns
+// selected before the record columns. The from is the table with the optional condition on the args.
+func deleteExpiredRows(tx *sql.Tx, from, keyColumns, columns string, isExpired func(row rowScanner) (bool, error),
+	args ...interface{}) (int, error) {
+	rows, err := tx.Query("SELECT "+keyColumns+", "+columns+" FROM "+from, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	keyCount := len(strings.Split(keyColumns, ","))
+	var keys [][]interface{}
+	for rows.Next() {
+		key := make([]interface{}, keyCount)
+		ok, err := isExpired(keyedRow{rows: rows, key: key})
+		if err != nil {
+			_ = rows.Close()
+			return 0, err
+		}
+		if ok {
+			keys = append(keys, key)
+		}
+	}
+	if err = rows.Close(); err != nil {
+		return 0, err
+	}
+
+	table := strings.Fields(from)[0]
+	condition := strings.Join(strings.Split(keyColumns, ", "), " = ? AND ") + " = ?"
+	for _, key := range keys {
+		if _, err = tx.Exec("DELETE FROM "+table+" WHERE "+condition, key...); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+// keyedRow scans the key columns into the key and the rest into the record.
+type keyedRow struct {
+	rows *sql.Rows
+	key  []interface{}
+}
+
+func (r keyedRow) Scan(dest ...interface{}) error {
+	all := make([]interface{}, 0, len(r.key)+len(dest))
+	for i := range r.key {
+		all = append(all, &r.key[i])
+	}
+	return r.rows.Scan(append(all, dest...)...)
+}
+
+func (st *sqliteAuthStorage) Users() (users []string, err error) {
+	rows, err := st.db.Query("SELECT username FROM users ORDER BY username")
+	if err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+
+	for rows.Next() {
+		var username string
+		if err = rows.Scan(&username); err != nil {
+			return
+		}
+		users = append(users, username)
+	}
+
+	err = rows.Err()
+	return
+}
+
+func (st *sqliteAuthStorage) ImportRecord(record Record) (ok bool, err error) {
+	tx, err := st.db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	if record.Type == RecordEvent {
+		return importSQLEvent(tx, *record.Event)
+	}
+
+	username := record.User
+	if _, err = tx.Exec("INSERT OR IGNORE INTO users (username) VALUES (?)", username); err != nil {
+		return
+	}
+
+	var exists *sql.Row
+	switch record.Type {
+	case RecordSession:
+		exists = tx.QueryRow("SELECT 1 FROM sessions WHERE username = ? AND remote_addr = ?",
+			username, sqlAddr(record.Session.RemoteAddr))
+	case RecordKey:
+		exists = tx.QueryRow("SELECT 1 FROM keys WHERE username = ? AND key_id = ?", username, record.Key.ID())
+	case RecordConnection:
+		exists = tx.QueryRow("SELECT 1 FROM connections WHERE username = ? AND id = ?", username, record.Connection.ID)
+	case RecordEscalation:
+		exists = tx.QueryRow("SELECT 1 FROM escalations WHERE username = ? AND id = ?", username, record.Escalation.ID)
+	default:
+		err = fmt.Errorf("unsupported record type %q", record.Type)
+		return
+	}
+	if ok, err = sqlNotExists(exists); !ok || err != nil {
+		return
+	}
+
+	// the sequences are kept ahead of the imported IDs
+	switch record.Type {
+	case RecordSession:
+		if err = putSQLSession(tx, username, sqlAddr(record.Session.RemoteAddr), *record.Session); err != nil {
+			return
+		}
+		err = bumpSQLSequence(tx, username, "session_seq", record.Session.ID)
+	case RecordKey:
+		err = insertSQLKeyUsage(tx, username, record.Key.ID(), *record.Key)
+	case RecordConnection:
+		if err = putSQLConnection(tx, username, record.Connection); err != nil {
+			return
+		}
+		err = bumpSQLSequence(tx, username, "connection_seq", record.Connection.ID)
+	case RecordEscalation:
+		if err = insertSQLEscalation(tx, username, *record.Escalation); err != nil {
+			return
+		}
+		err = bumpSQLSequence(tx, username, "escalation_seq", record.Escalation.ID)
+	}
+	return
+}
+
+func importSQLEvent(tx *sql.Tx, event AuthEvent) (ok bool, err error) {
+	key, err := eventIDKey(event.ID)
+	if err != nil {
+		return
+	}
+
+	seq := binary.BigEndian.Uint64(key[8:])
+	ok, err = sqlNotExists(tx.QueryRow("SELECT 1 FROM events WHERE seq = ?", int64(seq)))
+	if !ok || err != nil {
+		return
+	}
+	err = appendSQLEvent(tx, seq, event.AuthInfo)
+	return
+}
+
+// sqlNotExists scans the row of the existence query.
+func sqlNotExists(row *sql.Row) (bool, error) {
+	var one int
+	err := row.Scan(&one)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	return false, err
+}
+
+func bumpSQLSequence(tx *sql.Tx, username, column string, seq uint64) error {
+	_, err := tx.Exec("UPDATE users SET "+column+" = MAX("+column+", ?) WHERE username = ?", seq, username)
+	return err
+}
@@ -0,0 +1,456 @@
+package db
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+// backends are the StorageI implementations, every one must pass the conformance suite.
+var backends = map[string]func(dbPath string) (StorageI, error){
+	BackendBolt:   NewStorage,
+	BackendSQLite: NewSQLiteStorage,
+	MemoryPath: func(string) (StorageI, error) {
+		return NewMemoryStorage(), nil
+	},
+}
+
+func openBackend(t *testing.T, backend string) (StorageI, func()) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	storage, err := backends[backend](dir)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return storage, func() {
+		_ = storage.Close()
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// conformanceStart is the start of the conformance scenario.
+var conformanceStart = time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+
+func conformanceEvents() []AuthInfo {
+	start := conformanceStart
+	nat, other := net.ParseIP("10.0.0.1"), net.ParseIP("2001:db8::1")
+	key := &PublicKey{Type: "ED25519", Fingerprint: "SHA256:abc"}
+	cert := &PublicKey{Type: "ED25519-CERT", Fingerprint: "SHA256:abc", CertID: "sheb@ca", CertSerial: 7,
+		CAType: "ED25519", CAFingerprint: "SHA256:ca"}
+	labels := map[string]string{"host": "web"}
+	geo := &GeoInfo{Country: "JP", Latitude: 35.69, Longitude: 139.69, AccuracyRadius: 100, ASN: 64500, ASOrg: "Example"}
+
+	return []AuthInfo{
+		{Status: AuthInvalidUser, Username: "admin", RemoteAddr: other, Port: 4000, Date: start.Add(-48 * time.Hour)},
+		{Status: AuthPreauthClosed, RemoteAddr: other, Port: 4001, Date: start.Add(-47 * time.Hour)},
+		{Status: AuthFailed, Username: "sheb", AuthMethod: "password", RemoteAddr: nat, Port: 1000, PID: 90,
+			Host: "web", Date: start.Add(-time.Minute)},
+		{Status: AuthAccepted, Username: "sheb", AuthMethod: "publickey", PublicKey: key, RemoteAddr: nat,
+			Port: 1001, Protocol: "ssh2", PID: 100, Host: "web", Labels: labels, Date: start},
+		{Status: AuthSessionOpened, Username: "sheb", PID: 100, Host: "web", Date: start},
+		{Status: AuthAccepted, Username: "sheb", AuthMethod: "publickey", PublicKey: cert,
+			RemoteAddr: net.ParseIP("::ffff:10.0.0.1"), Port: 1002, PID: 200, Host: "web", Date: start.Add(time.Minute)},
+		{Status: AuthAccepted, Username: "sheb", AuthMethod: "publickey", PublicKey: key, RemoteAddr: other,
+			Port: 1003, PID: 300, Host: "web", Date: start.Add(2 * time.Minute), Geo: geo},
+		{Status: AuthSessionClosed, Username: "sheb", PID: 100, Host: "web", Date: start.Add(10 * time.Minute)},
+		{Status: AuthDisconnected, Username: "sheb", AuthMethod: "publickey", RemoteAddr: nat, Port: 1002, PID: 201,
+			Host: "web", Date: start.Add(11 * time.Minute)},
+		{Status: AuthAccepted, Username: "sheb", AuthMethod: "publickey", PublicKey: key, RemoteAddr: other,
+			Port: 1004, PID: 300, Host: "web", Date: start.Add(time.Hour)},
+	}
+}
+
+// storageDump is the content of the storage after the conformance scenario.
+type storageDump struct {
+	Sessions    map[string][]Session
+	Keys        map[string][]KeyUsage
+	Connections map[string][]Connection
+	Escalations map[string][]Escalation
+	Events      []AuthEvent
+}
+
+func dumpStorage(t *testing.T, storage StorageI) storageDump {
+	dump := storageDump{
+		Sessions:    map[string][]Session{},
+		Keys:        map[string][]KeyUsage{},
+		Connections: map[string][]Connection{},
+		Escalations: map[string][]Escalation{},
+	}
+
+	auth := storage.Auth()
+	for _, username := range []string{"sheb", "admin", UnknownUser} {
+		var err error
+		if dump.Sessions[username], err = auth.GetUserSessions(username); err != nil {
+			t.Fatalf("GetUserSessions() error = %v", err)
+		}
+		if dump.Keys[username], err = auth.GetUserKeys(username); err != nil {
+			t.Fatalf("GetUserKeys() error = %v", err)
+		}
+		if dump.Connections[username], err = auth.GetUserConnections(username); err != nil {
+			t.Fatalf("GetUserConnections() error = %v", err)
+		}
+		if dump.Escalations[username], err = auth.GetUserEscalations(username); err != nil {
+			t.Fatalf("GetUserEscalations() error = %v", err)
+		}
+	}
+
+	page, err := auth.Events(EventFilter{Limit: MaxEventsLimit})
+	if err != nil {
+		t.Fatalf("Events() error = %v", err)
+	}
+	dump.Events = page.Events
+	return dump
+}
+
+// compareDumps checks that the backends have the same content as the bolt one.
+func compareDumps(t *testing.T, dumps map[string]storageDump) {
+	want, ok := dumps[BackendBolt]
+	if !ok {
+		return
+	}
+	for backend, dump := range dumps {
+		if !reflect.DeepEqual(dump, want) {
+			t.Errorf("%s differs from bolt:\ngot  = %+v\nwant = %+v", backend, dump, want)
+		}
+	}
+}
+
+func runScenario(t *testing.T, storage StorageI) {
+	for i, event := range conformanceEvents() {
+		if _, err := storage.Auth().UpsetAuthEvent(event); err != nil {
+			t.Fatalf("UpsetAuthEvent() #%d error = %v", i+1, err)
+		}
+	}
+
+	escalations := []Escalation{
+		{Tool: "sudo", Status: EscalationGranted, Username: "sheb", TargetUser: "root", TTY: "pts/0",
+			PWD: "/home/sheb", Command: "/bin/ls", Date: conformanceStart.Add(3 * time.Minute)},
+		{Tool: "sudo", Status: EscalationFailed, Username: "sheb", TargetUser: "root", Attempts: 3,
+			Command: "/bin/sh", Date: conformanceStart.Add(4 * time.Minute), Labels: map[string]string{"host": "web"}},
+		{Tool: "su", Status: EscalationGranted, TargetUser: "root", Date: conformanceStart.Add(5 * time.Minute)},
+	}
+	for i, escalation := range escalations {
+		if _, err := storage.Auth().AddEscalation(escalation); err != nil {
+			t.Fatalf("AddEscalation() #%d error = %v", i+1, err)
+		}
+	}
+}
+
+func TestStorage_conformance(t *testing.T) {
+	dumps := map[string]storageDump{}
+	for backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			storage, closeStorage := openBackend(t, backend)
+			defer closeStorage()
+
+			runScenario(t, storage)
+			dump := dumpStorage(t, storage)
+			dumps[backend] = dump
+
+			sessions := dump.Sessions["sheb"]
+			if len(sessions) != 2 {
+				t.Fatalf("GetUserSessions() got = %+v, want 2 sessions", sessions)
+			}
+			if s := sessions[0]; s.RemoteAddr.String() != "10.0.0.1" || s.ConnsCount != 0 || s.FailsCount != 1 ||
+				s.AuthMethods["publickey"] != 1 || s.Labels["host"] != "web" || s.Protocol != "ssh2" {
+				t.Errorf("session got = %+v", s)
+			}
+			if s := sessions[1]; s.RemoteAddr.String() != "2001:db8::1" || s.ConnsCount != 1 || s.Status != AuthAccepted {
+				t.Errorf("session got = %+v", s)
+			}
+			if len(dump.Sessions[UnknownUser]) != 1 || dump.Sessions[UnknownUser][0].Username != "" {
+				t.Errorf("unknown user sessions got = %+v", dump.Sessions[UnknownUser])
+			}
+
+			keys := dump.Keys["sheb"]
+			if len(keys) != 2 || keys[0].UsesCount != 3 || keys[1].CertSerial != 7 || keys[1].UsesCount != 1 {
+				t.Errorf("GetUserKeys() got = %+v", keys)
+			}
+
+			var statuses []ConnectionStatus
+			for _, conn := range dump.Connections["sheb"] {
+				statuses = append(statuses, conn.Status)
+			}
+			wantStatuses := []ConnectionStatus{ConnectionClosed, ConnectionClosed, ConnectionLost, ConnectionOpen}
+			if !reflect.DeepEqual(statuses, wantStatuses) {
+				t.Errorf("GetUserConnections() got = %v, want %v", statuses, wantStatuses)
+			}
+			if conn := dump.Connections["sheb"][0]; conn.Duration != 10*time.Minute || conn.PublicKey == nil {
+				t.Errorf("connection got = %+v", conn)
+			}
+
+			if escalations := dump.Escalations["sheb"]; len(escalations) != 2 || escalations[1].ID != 2 ||
+				escalations[1].Attempts != 3 {
+				t.Errorf("GetUserEscalations() got = %+v", escalations)
+			}
+			if escalations := dump.Escalations[UnknownUser]; len(escalations) != 1 || escalations[0].ID != 1 {
+				t.Errorf("GetUserEscalations() got = %+v", escalations)
+			}
+
+			if len(dump.Events) != len(conformanceEvents()) {
+				t.Errorf("Events() got %d events, want %d", len(dump.Events), len(conformanceEvents()))
+			}
+		})
+	}
+
+	compareDumps(t, dumps)
+}
+
+func TestStorage_conformanceEvents(t *testing.T) {
+	start := conformanceStart
+	nat := net.ParseIP("10.0.0.1")
+
+	tests := []struct {
+		filter EventFilter
+		// pages are the statuses of the pages
+		pages [][]AuthStatus
+	}{
+		{
+			filter: EventFilter{Username: "sheb", Limit: 3},
+			pages: [][]AuthStatus{
+				{AuthFailed, AuthAccepted, AuthSessionOpened},
+				{AuthAccepted, AuthAccepted, AuthSessionClosed},
+				{AuthDisconnected, AuthAccepted},
+			},
+		},
+		{
+			filter: EventFilter{Statuses: []AuthStatus{AuthAccepted}, Limit: 2, Descending: true},
+			pages: [][]AuthStatus{
+				{AuthAccepted, AuthAccepted},
+				{AuthAccepted, AuthAccepted},
+			},
+		},
+		{
+			filter: EventFilter{RemoteAddr: nat, From: start, To: start.Add(time.Hour), Limit: 2, Descending: true},
+			// the pam lines are stored with the address of the connection
+			pages: [][]AuthStatus{
+				{AuthDisconnected, AuthSessionClosed},
+				{AuthAccepted, AuthSessionOpened},
+				{AuthAccepted},
+			},
+		},
+		{
+			filter: EventFilter{From: start.Add(-time.Hour), To: start.Add(time.Minute)},
+			pages: [][]AuthStatus{
+				{AuthFailed, AuthAccepted, AuthSessionOpened},
+			},
+		},
+		{
+			filter: EventFilter{Username: "root"},
+			pages:  [][]AuthStatus{nil},
+		},
+	}
+
+	for backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			storage, closeStorage := openBackend(t, backend)
+			defer closeStorage()
+			runScenario(t, storage)
+
+			for i, tt := range tests {
+				filter := tt.filter
+				for j, want := range tt.pages {
+					page, err := storage.Auth().Events(filter)
+					if err != nil {
+						t.Fatalf("Events() #%d error = %v", i+1, err)
+					}
+
+					var got []AuthStatus
+					for _, event := range page.Events {
+						got = append(got, event.Status)
+					}
+					if !reflect.DeepEqual(got, want) {
+						t.Errorf("Events() #%d page %d got = %v, want %v", i+1, j+1, got, want)
+					}
+					if last := j == len(tt.pages)-1; last != (page.Next == "") {
+						t.Errorf("Events() #%d page %d next = %q", i+1, j+1, page.Next)
+					}
+					filter.Cursor = page.Next
+				}
+			}
+
+			if _, err := storage.Auth().Events(EventFilter{Cursor: "zz"}); err != ErrInvalidCursor {
+				t.Errorf("Events() error = %v, want %v", err, ErrInvalidCursor)
+			}
+		})
+	}
+}
+
+func TestStorage_conformanceNoSession(t *testing.T) {
+	for backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			storage, closeStorage := openBackend(t, backend)
+			defer closeStorage()
+
+			// the pam line with no address and no earlier login of the user
+			_, err := storage.Auth().UpsetAuthEvent(AuthInfo{Status: AuthSessionOpened, Username: "admin",
+				PID: 42, Host: "web", Date: conformanceStart})
+			if err != ErrSessionNotFound {
+				t.Fatalf("UpsetAuthEvent() error = %v, want %v", err, ErrSessionNotFound)
+			}
+
+			page, err := storage.Auth().Events(EventFilter{Username: "admin"})
+			if err != nil || len(page.Events) != 1 || page.Events[0].Status != AuthSessionOpened {
+				t.Errorf("Events() got = %+v, error = %v", page, err)
+			}
+			if sessions, err := storage.Auth().GetUserSessions("admin"); err != nil || len(sessions) != 0 {
+				t.Errorf("GetUserSessions() got = %+v, error = %v", sessions, err)
+			}
+		})
+	}
+}
+
+func TestStorage_conformanceServiceUsernames(t *testing.T) {
+	// the usernames are taken from the log as they are, scanners may try any of them
+	usernames := []string{"__events", "__meta", "__bob"}
+
+	for backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			storage, closeStorage := openBackend(t, backend)
+			defer closeStorage()
+
+			events := []AuthInfo{{Status: AuthAccepted, Username: "sheb", AuthMethod: "password",
+				RemoteAddr: net.ParseIP("10.0.0.1"), Port: 1000, Date: conformanceStart}}
+			for i, username := range usernames {
+				events = append(events, AuthInfo{Status: AuthInvalidUser, Username: username,
+					RemoteAddr: net.ParseIP("10.0.0.2"), Port: 2000 + i, Date: conformanceStart.Add(time.Minute)})
+			}
+			for i, event := range events {
+				if _, err := storage.Auth().UpsetAuthEvent(event); err != nil {
+					t.Fatalf("UpsetAuthEvent() #%d error = %v", i+1, err)
+				}
+			}
+
+			page, err := storage.Auth().Events(EventFilter{Limit: MaxEventsLimit})
+			if err != nil || len(page.Events) != len(events) {
+				t.Errorf("Events() got = %+v, error = %v, want %d events", page, err, len(events))
+			}
+			for _, username := range usernames {
+				if sessions, err := storage.Auth().GetUserSessions(username); err != nil || len(sessions) != 1 {
+					t.Errorf("GetUserSessions(%q) got = %+v, error = %v, want 1 session", username, sessions, err)
+				}
+			}
+
+			users, err := storage.Auth().Users()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(users)
+			want := append([]string{"sheb"}, usernames...)
+			sort.Strings(want)
+			if !reflect.DeepEqual(users, want) {
+				t.Errorf("Users() got = %v, want %v", users, want)
+			}
+
+			report, err := storage.Auth().Prune(RetentionPolicy{FailedBefore: conformanceStart.Add(time.Hour)})
+			if err != nil || report.Users != len(usernames) {
+				t.Errorf("Prune() got = %+v, error = %v, want %d users removed", report, err, len(usernames))
+			}
+			if users, err = storage.Auth().Users(); err != nil || !reflect.DeepEqual(users, []string{"sheb"}) {
+				t.Errorf("Users() got = %v, error = %v, want the users removed", users, err)
+			}
+		})
+	}
+}
+
+func TestStorage_conformancePrune(t *testing.T) {
+	policy := RetentionPolicy{
+		FailedBefore:   conformanceStart.Add(-24 * time.Hour),
+		AcceptedBefore: conformanceStart.Add(30 * time.Minute),
+	}
+	want := PruneReport{Users: 2, Sessions: 3, Connections: 3, Keys: 1, Escalations: 2, Events: 8}
+
+	dumps := map[string]storageDump{}
+	for backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			storage, closeStorage := openBackend(t, backend)
+			defer closeStorage()
+			runScenario(t, storage)
+
+			report, err := storage.Auth().Prune(policy)
+			if err != nil {
+				t.Fatalf("Prune() error = %v", err)
+			}
+			if report != want {
+				t.Errorf("Prune() got = %+v, want %+v", report, want)
+			}
+			dumps[backend] = dumpStorage(t, storage)
+
+			// the removed user starts the sequences again
+			escalation, err := storage.Auth().AddEscalation(Escalation{Tool: "su", Status: EscalationFailed,
+				Username: "admin", TargetUser: "root", Date: conformanceStart})
+			if err != nil || escalation.ID != 1 {
+				t.Errorf("AddEscalation() got = %+v, error = %v", escalation, err)
+			}
+		})
+	}
+
+	compareDumps(t, dumps)
+}
+
+func TestStorage_conformanceState(t *testing.T) {
+	updated := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+
+	for backend := range backends {
+		t.Run(backend, func(t *testing.T) {
+			storage, closeStorage := openBackend(t, backend)
+			defer closeStorage()
+
+			if info, err := storage.TG().GetUser("sheb"); err != nil || info != (TGChatInfo{}) {
+				t.Errorf("GetUser() got = %+v, error = %v, want none", info, err)
+			}
+			if err := storage.TG().AddUser("sheb", 1001); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.TG().AddUser("admin", 1002); err != nil {
+				t.Fatal(err)
+			}
+			if err := storage.TG().Mute("admin", 1002); err != nil {
+				t.Fatal(err)
+			}
+			users, err := storage.TG().GetUsers()
+			wantUsers := map[string]TGChatInfo{"sheb": {ChatID: 1001}, "admin": {ChatID: 1002, Muted: true}}
+			if err != nil || !reflect.DeepEqual(users, wantUsers) {
+				t.Errorf("GetUsers() got = %+v, error = %v, want %+v", users, err, wantUsers)
+			}
+			if info, err := storage.TG().GetUser("admin"); err != nil || info != wantUsers["admin"] {
+				t.Errorf("GetUser() got = %+v, error = %v", info, err)
+			}
+
+			if pos, err := storage.Positions().GetPosition("/var/log/auth.log"); err != nil || pos != nil {
+				t.Errorf("GetPosition() got = %+v, error = %v, want none", pos, err)
+			}
+			wantPos := FilePosition{Path: "/var/log/auth.log", Inode: 42, Offset: 1024, UpdatedAt: updated}
+			for _, offset := range []int64{512, wantPos.Offset} {
+				pos := wantPos
+				pos.Offset = offset
+				if err := storage.Positions().SavePosition(pos); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if pos, err := storage.Positions().GetPosition(wantPos.Path); err != nil || !reflect.DeepEqual(*pos, wantPos) {
+				t.Errorf("GetPosition() got = %+v, error = %v, want %+v", pos, err, wantPos)
+			}
+
+			if state, err := storage.Imports().GetImport("abc"); err != nil || state != nil {
+				t.Errorf("GetImport() got = %+v, error = %v, want none", state, err)
+			}
+			wantState := ImportState{FileID: "abc", Path: "/var/log/auth.log.1", Lines: 10, Done: true, UpdatedAt: updated}
+			if err := storage.Imports().SaveImport(wantState); err != nil {
+				t.Fatal(err)
+			}
+			if state, err := storage.Imports().GetImport("abc"); err != nil || !reflect.DeepEqual(*state, wantState) {
+				t.Errorf("GetImport() got = %+v, error = %v, want %+v", state, err, wantState)
+			}
+		})
+	}
+}
@@ -7,8 +7,8 @@ import (
 )
 
 type TGChatInfo struct {
-	ChatID int64
-	Muted  bool
+	ChatID int64 `json:"chat_id"`
+	Muted  bool  `json:"muted"`
 }
 
 const bucketTGWhitelist = "tg_whitelist"
@@ -22,13 +22,12 @@ func (st *tgStorage) AddUser(username string, chatID int64) (err error) {
 	if err != nil {
 		return
 	}
-
 	defer func() {
 		if err != nil {
-			err = tx.Rollback()
-		} else {
-			err = tx.Commit()
+			_ = tx.Rollback()
+			return
 		}
+		err = tx.Commit()
 	}()
 
 	userBucket, err := tx.CreateBucketIfNotExists([]byte(bucketTGWhitelist))
@@ -57,10 +56,10 @@ func (st *tgStorage) Mute(username string, chatID int64) (err error) {
 	}
 	defer func() {
 		if err != nil {
-			err = tx.Rollback()
-		} else {
-			err = tx.Commit()
+			_ = tx.Rollback()
+			return
 		}
+		err = tx.Commit()
 	}()
 
 	userBucket, err := tx.CreateBucketIfNotExists([]byte(bucketTGWhitelist))
@@ -84,60 +83,46 @@ func (st *tgStorage) Mute(username string, chatID int64) (err error) {
 
 func (st *tgStorage) GetUsers() (users map[string]TGChatInfo, err error) {
 	users = map[string]TGChatInfo{}
-	tx, err := st.db.Begin(true)
+	tx, err := st.db.Begin(false)
 	if err != nil {
 		return nil, err
 	}
-	defer func() {
-		if err != nil {
-			err = tx.Rollback()
-		} else {
-			err = tx.Commit()
-		}
-	}()
+	defer func() { _ = tx.Rollback() }()
 
-	bucket, err := tx.CreateBucketIfNotExists([]byte(bucketTGWhitelist))
-	if err != nil {
-		return
-	}
-	if bucket != nil {
+	bucket := tx.Bucket([]byte(bucketTGWhitelist))
+	if bucket == nil {
 		return
 	}
 
-	cursor := bucket.Cursor()
-	for {
-		user, value := cursor.Next()
-		if value == nil {
-			break
-		}
+	err = bucket.ForEach(func(user, value []byte) error {
 		info := TGChatInfo{}
-		err = json.Unmarshal(value, &info)
+		if err := json.Unmarshal(value, &info); err != nil {
+			return err
+		}
 
 		users[string(user)] = info
-	}
+		return nil
+	})
 
 	return
 }
 
 func (st *tgStorage) GetUser(username string) (info TGChatInfo, err error) {
-	tx, err := st.db.Begin(true)
+	tx, err := st.db.Begin(false)
 	if err != nil {
 		return
 	}
-	defer func() {
-		if err != nil {
-			err = tx.Rollback()
-		} else {
-			err = tx.Commit()
-		}
-	}()
+	defer func() { _ = tx.Rollback() }()
 
 	bucket := tx.Bucket([]byte(bucketTGWhitelist))
-	if bucket != nil {
+	if bucket == nil {
 		return
 	}
 
 	val := bucket.Get([]byte(username))
+	if val == nil {
+		return
+	}
 	err = json.Unmarshal(val, &info)
 
 	return
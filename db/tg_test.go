@@ -1,62 +1,100 @@
 package db
 
 import (
+	"encoding/json"
 	"reflect"
 	"testing"
 
 	bolt "go.etcd.io/bbolt"
 )
 
-func Test_tgStorage_AddUser(t *testing.T) {
-	type fields struct {
-		db *bolt.DB
+// seedTG writes the users into the whitelist bucket, nil users leave the database without the bucket.
+func seedTG(t *testing.T, users map[string]TGChatInfo) (*bolt.DB, func()) {
+	db, closeDB := openTestDB(t, "tg.db")
+	if users == nil {
+		return db, closeDB
+	}
+
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(bucketTGWhitelist))
+		if err != nil {
+			return err
+		}
+		for username, info := range users {
+			value, err := json.Marshal(info)
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put([]byte(username), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		closeDB()
+		t.Fatal(err)
 	}
+	return db, closeDB
+}
+
+func Test_tgStorage_AddUser(t *testing.T) {
 	type args struct {
 		username string
 		chatID   int64
 	}
 	tests := []struct {
 		name    string
-		fields  fields
+		users   map[string]TGChatInfo
 		args    args
+		want    TGChatInfo
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{name: "new bucket", args: args{username: "sheb", chatID: 42}, want: TGChatInfo{ChatID: 42}},
+		{name: "unmuted", users: map[string]TGChatInfo{"sheb": {ChatID: 1, Muted: true}},
+			args: args{username: "sheb", chatID: 42}, want: TGChatInfo{ChatID: 42}},
+		// the failed write is not hidden by the rollback
+		{name: "empty username", args: args{username: "", chatID: 42}, wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			st := &tgStorage{
-				db: tt.fields.db,
-			}
+			db, closeDB := seedTG(t, tt.users)
+			defer closeDB()
+
+			st := &tgStorage{db: db}
 			if err := st.AddUser(tt.args.username, tt.args.chatID); (err != nil) != tt.wantErr {
 				t.Errorf("AddUser() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tt.wantErr {
+				return
+			}
+			if got, err := st.GetUser(tt.args.username); err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetUser() got = %v, %v, want %v", got, err, tt.want)
+			}
 		})
 	}
 }
 
 func Test_tgStorage_GetUser(t *testing.T) {
-	type fields struct {
-		db *bolt.DB
-	}
-	type args struct {
-		username string
-	}
 	tests := []struct {
-		name    string
-		fields  fields
-		args    args
-		want    TGChatInfo
-		wantErr bool
+		name     string
+		users    map[string]TGChatInfo
+		username string
+		want     TGChatInfo
+		wantErr  bool
 	}{
-		// TODO: Add test cases.
+		{name: "no bucket", username: "sheb"},
+		{name: "unknown user", users: map[string]TGChatInfo{"admin": {ChatID: 7}}, username: "sheb"},
+		{name: "user", users: map[string]TGChatInfo{"admin": {ChatID: 7}, "sheb": {ChatID: 42, Muted: true}},
+			username: "sheb", want: TGChatInfo{ChatID: 42, Muted: true}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			st := &tgStorage{
-				db: tt.fields.db,
-			}
-			got, err := st.GetUser(tt.args.username)
+			db, closeDB := seedTG(t, tt.users)
+			defer closeDB()
+
+			st := &tgStorage{db: db}
+			got, err := st.GetUser(tt.username)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetUser() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -69,22 +107,23 @@ func Test_tgStorage_GetUser(t *testing.T) {
 }
 
 func Test_tgStorage_GetUsers(t *testing.T) {
-	type fields struct {
-		db *bolt.DB
-	}
 	tests := []struct {
 		name    string
-		fields  fields
+		users   map[string]TGChatInfo
 		want    map[string]TGChatInfo
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{name: "no bucket", want: map[string]TGChatInfo{}},
+		{name: "empty bucket", users: map[string]TGChatInfo{}, want: map[string]TGChatInfo{}},
+		{name: "users", users: map[string]TGChatInfo{"admin": {ChatID: 7}, "sheb": {ChatID: 42, Muted: true}},
+			want: map[string]TGChatInfo{"admin": {ChatID: 7}, "sheb": {ChatID: 42, Muted: true}}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			st := &tgStorage{
-				db: tt.fields.db,
-			}
+			db, closeDB := seedTG(t, tt.users)
+			defer closeDB()
+
+			st := &tgStorage{db: db}
 			got, err := st.GetUsers()
 			if (err != nil) != tt.wantErr {
 				t.Errorf("GetUsers() error = %v, wantErr %v", err, tt.wantErr)
@@ -98,29 +137,36 @@ func Test_tgStorage_GetUsers(t *testing.T) {
 }
 
 func Test_tgStorage_Mute(t *testing.T) {
-	type fields struct {
-		db *bolt.DB
-	}
 	type args struct {
 		username string
 		chatID   int64
 	}
 	tests := []struct {
 		name    string
-		fields  fields
+		users   map[string]TGChatInfo
 		args    args
+		want    TGChatInfo
 		wantErr bool
 	}{
-		// TODO: Add test cases.
+		{name: "user", users: map[string]TGChatInfo{"sheb": {ChatID: 42}},
+			args: args{username: "sheb", chatID: 42}, want: TGChatInfo{ChatID: 42, Muted: true}},
+		{name: "empty username", args: args{username: "", chatID: 42}, wantErr: true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			st := &tgStorage{
-				db: tt.fields.db,
-			}
+			db, closeDB := seedTG(t, tt.users)
+			defer closeDB()
+
+			st := &tgStorage{db: db}
 			if err := st.Mute(tt.args.username, tt.args.chatID); (err != nil) != tt.wantErr {
 				t.Errorf("Mute() error = %v, wantErr %v", err, tt.wantErr)
 			}
+			if tt.wantErr {
+				return
+			}
+			if got, err := st.GetUser(tt.args.username); err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetUser() got = %v, %v, want %v", got, err, tt.want)
+			}
 		})
 	}
 }
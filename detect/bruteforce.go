@@ -0,0 +1,164 @@
+package detect
+
+import (
+	"net"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+// BruteForce counts the failed attempts in the sliding window per address, per username
+// and per network, the counter crossed the threshold raises the alert. The counter alerts
+// again only after it stays under the threshold for the cooldown, so the attack makes one alert.
+// The window slides by the event dates, the replayed logs give the same alerts.
+type BruteForce struct {
+	config    config.BruteForceConfig
+	counters  map[counterKey]*counter
+	lastSweep time.Time
+}
+
+type counterKey struct {
+	scope Scope
+	key   string
+}
+
+type attempt struct {
+	date     time.Time
+	username string
+	addr     string
+}
+
+type counter struct {
+	// attempts are the latest attempts in the date order, only the threshold of them matters.
+	attempts []attempt
+	// attackDate is the date of the latest attempt over the threshold.
+	attackDate time.Time
+}
+
+func NewBruteForce(config config.BruteForceConfig) *BruteForce {
+	return &BruteForce{
+		config:   config,
+		counters: map[counterKey]*counter{},
+	}
+}
+
+func (d *BruteForce) Observe(info db.AuthInfo) (alerts []Alert) {
+	if !info.Status.IsFailure() {
+		return nil
+	}
+	d.sweep(info.Date)
+
+	a := attempt{date: info.Date, username: info.Username}
+	if len(info.RemoteAddr) != 0 {
+		a.addr = info.RemoteAddr.String()
+	}
+
+	counts := []struct {
+		scope     Scope
+		key       string
+		threshold int
+	}{
+		{scope: ScopeIP, key: a.addr, threshold: d.config.PerIP},
+		{scope: ScopeUser, key: info.Username, threshold: d.config.PerUser},
+		{scope: ScopeSubnet, key: Subnet(info.RemoteAddr), threshold: d.config.PerSubnet},
+	}
+	for _, c := range counts {
+		if c.key == "" || c.threshold <= 0 {
+			continue
+		}
+		if alert := d.count(counterKey{scope: c.scope, key: c.key}, c.threshold, a); alert != nil {
+			alerts = append(alerts, *alert)
+		}
+	}
+	return alerts
+}
+
+func (d *BruteForce) count(key counterKey, threshold int, a attempt) *Alert {
+	c, ok := d.counters[key]
+	if !ok {
+		c = &counter{}
+		d.counters[key] = c
+	}
+
+	// the events of several sources are almost ordered
+	i := len(c.attempts)
+	for i > 0 && c.attempts[i-1].date.After(a.date) {
+		i--
+	}
+	c.attempts = append(c.attempts, attempt{})
+	copy(c.attempts[i+1:], c.attempts[i:])
+	c.attempts[i] = a
+
+	latest := c.attempts[len(c.attempts)-1].date
+	cutoff := latest.Add(-d.config.Window.Duration)
+	first := len(c.attempts) - threshold
+	if first < 0 {
+		first = 0
+	}
+	for first < len(c.attempts) && c.attempts[first].date.Before(cutoff) {
+		first++
+	}
+	c.attempts = append(c.attempts[:0], c.attempts[first:]...)
+	if len(c.attempts) < threshold {
+		return nil
+	}
+
+	alerted := !c.attackDate.IsZero() && latest.Sub(c.attackDate) < d.config.Cooldown.Duration
+	c.attackDate = latest
+	if alerted {
+		return nil
+	}
+
+	alert := &Alert{
+		Kind:      AlertBruteForce,
+		Scope:     key.scope,
+		Key:       key.key,
+		Count:     len(c.attempts),
+		Threshold: threshold,
+		Window:    d.config.Window.Duration,
+		Since:     c.attempts[0].date,
+		Date:      latest,
+	}
+	usernames, addrs := map[string]bool{}, map[string]bool{}
+	for _, a := range c.attempts {
+		if a.username != "" && !usernames[a.username] {
+			usernames[a.username] = true
+			alert.Usernames = append(alert.Usernames, a.username)
+		}
+		if a.addr != "" && !addrs[a.addr] {
+			addrs[a.addr] = true
+			alert.Addrs = append(alert.Addrs, a.addr)
+		}
+	}
+	return alert
+}
+
+// sweep drops the counters without the attempts in the window and out of the cooldown,
+// the scanners try thousands of addresses and usernames.
+func (d *BruteForce) sweep(date time.Time) {
+	if date.Sub(d.lastSweep) < d.config.Window.Duration {
+		return
+	}
+	d.lastSweep = date
+
+	cutoff := date.Add(-d.config.Window.Duration)
+	for key, c := range d.counters {
+		if c.attempts[len(c.attempts)-1].date.Before(cutoff) &&
+			date.Sub(c.attackDate) >= d.config.Cooldown.Duration {
+			delete(d.counters, key)
+		}
+	}
+}
+
+// Subnet returns the /24 network of the IPv4 address and the /64 one of the IPv6 address.
+func Subnet(ip net.IP) string {
+	if len(ip) == 0 {
+		return ""
+	}
+	network := net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}
+	if ip4 := ip.To4(); ip4 != nil {
+		network = net.IPNet{IP: ip4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}
+	}
+	return network.String()
+}
@@ -0,0 +1,170 @@
+package detect
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+func TestBruteForce_Observe(t *testing.T) {
+	start := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	cfg := config.BruteForceConfig{
+		Window:    config.Duration{Duration: 10 * time.Minute},
+		PerIP:     3,
+		PerUser:   4,
+		PerSubnet: 5,
+		Cooldown:  config.Duration{Duration: time.Hour},
+	}
+	failed := func(username, addr string, at time.Duration) db.AuthInfo {
+		return db.AuthInfo{Status: db.AuthFailed, Username: username, RemoteAddr: net.ParseIP(addr), Date: start.Add(at)}
+	}
+
+	tests := []struct {
+		events []db.AuthInfo
+		want   []Alert
+	}{
+		{
+			// under the thresholds
+			events: []db.AuthInfo{
+				failed("root", "10.0.0.1", 0),
+				failed("admin", "10.0.0.1", time.Minute),
+				{Status: db.AuthAccepted, Username: "sheb", RemoteAddr: net.ParseIP("10.0.0.1"), Date: start.Add(2 * time.Minute)},
+			},
+		},
+		{
+			// the attempts out of the window
+			events: []db.AuthInfo{
+				failed("root", "10.0.0.1", 0),
+				failed("root", "10.0.0.1", 6*time.Minute),
+				failed("root", "10.0.0.1", 12*time.Minute),
+			},
+		},
+		{
+			events: []db.AuthInfo{
+				failed("root", "10.0.0.1", 0),
+				failed("admin", "10.0.0.1", time.Minute),
+				failed("root", "10.0.0.1", 2*time.Minute),
+			},
+			want: []Alert{{Kind: AlertBruteForce, Scope: ScopeIP, Key: "10.0.0.1", Count: 3, Threshold: 3,
+				Window: 10 * time.Minute, Since: start, Date: start.Add(2 * time.Minute),
+				Usernames: []string{"root", "admin"}, Addrs: []string{"10.0.0.1"}}},
+		},
+		{
+			// the attack goes on within the cooldown, one alert
+			events: []db.AuthInfo{
+				failed("", "10.0.0.1", 0),
+				failed("", "10.0.0.1", 30*time.Second),
+				failed("", "10.0.0.1", time.Minute),
+				failed("", "10.0.0.1", 9*time.Minute),
+				failed("", "10.0.0.1", 50*time.Minute),
+				failed("", "10.0.0.1", 55*time.Minute),
+				failed("", "10.0.0.1", 59*time.Minute),
+				failed("", "10.0.0.1", 100*time.Minute),
+			},
+			want: []Alert{{Kind: AlertBruteForce, Scope: ScopeIP, Key: "10.0.0.1", Count: 3, Threshold: 3,
+				Window: 10 * time.Minute, Since: start, Date: start.Add(time.Minute),
+				Addrs: []string{"10.0.0.1"}}},
+		},
+		{
+			// the quiet cooldown passed, the new attack alerts again
+			events: []db.AuthInfo{
+				failed("", "10.0.0.1", 0),
+				failed("", "10.0.0.1", time.Second),
+				failed("", "10.0.0.1", 2*time.Second),
+				failed("", "10.0.0.1", 2*time.Hour),
+				failed("", "10.0.0.1", 2*time.Hour+time.Second),
+				failed("", "10.0.0.1", 2*time.Hour+2*time.Second),
+			},
+			want: []Alert{
+				{Kind: AlertBruteForce, Scope: ScopeIP, Key: "10.0.0.1", Count: 3, Threshold: 3,
+					Window: 10 * time.Minute, Since: start, Date: start.Add(2 * time.Second), Addrs: []string{"10.0.0.1"}},
+				{Kind: AlertBruteForce, Scope: ScopeIP, Key: "10.0.0.1", Count: 3, Threshold: 3,
+					Window: 10 * time.Minute, Since: start.Add(2 * time.Hour), Date: start.Add(2*time.Hour + 2*time.Second),
+					Addrs: []string{"10.0.0.1"}},
+			},
+		},
+		{
+			// the distributed attack on the username from the network
+			events: []db.AuthInfo{
+				failed("root", "10.0.0.1", 0),
+				failed("root", "10.0.0.2", time.Minute),
+				failed("root", "10.0.0.3", 2*time.Minute),
+				failed("root", "10.0.0.4", 3*time.Minute),
+				failed("admin", "10.0.0.5", 4*time.Minute),
+			},
+			want: []Alert{
+				{Kind: AlertBruteForce, Scope: ScopeUser, Key: "root", Count: 4, Threshold: 4,
+					Window: 10 * time.Minute, Since: start, Date: start.Add(3 * time.Minute), Usernames: []string{"root"},
+					Addrs: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4"}},
+				{Kind: AlertBruteForce, Scope: ScopeSubnet, Key: "10.0.0.0/24", Count: 5, Threshold: 5,
+					Window: 10 * time.Minute, Since: start, Date: start.Add(4 * time.Minute), Usernames: []string{"root", "admin"},
+					Addrs: []string{"10.0.0.1", "10.0.0.2", "10.0.0.3", "10.0.0.4", "10.0.0.5"}},
+			},
+		},
+		{
+			// the late event of the other source is counted in order
+			events: []db.AuthInfo{
+				failed("root", "2001:db8::1", 0),
+				failed("root", "2001:db8::1", 20*time.Minute),
+				failed("root", "2001:db8::1", 15*time.Minute),
+				failed("root", "2001:db8::1", 25*time.Minute),
+			},
+			want: []Alert{{Kind: AlertBruteForce, Scope: ScopeIP, Key: "2001:db8::1", Count: 3, Threshold: 3,
+				Window: 10 * time.Minute, Since: start.Add(15 * time.Minute), Date: start.Add(25 * time.Minute),
+				Usernames: []string{"root"}, Addrs: []string{"2001:db8::1"}}},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			d := NewBruteForce(cfg)
+			var got []Alert
+			for _, event := range tt.events {
+				got = append(got, d.Observe(event)...)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Observe() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBruteForce_sweep(t *testing.T) {
+	start := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	d := NewBruteForce(config.BruteForceConfig{
+		Window:   config.Duration{Duration: 10 * time.Minute},
+		PerIP:    100,
+		Cooldown: config.Duration{Duration: time.Hour},
+	})
+	for i := 0; i < 2000; i++ {
+		d.Observe(db.AuthInfo{Status: db.AuthInvalidUser, RemoteAddr: net.IPv4(10, 0, byte(i/256), byte(i)),
+			Date: start.Add(time.Duration(i) * time.Second)})
+	}
+	if len(d.counters) > 1000 {
+		t.Errorf("counters got %d, want the old ones dropped", len(d.counters))
+	}
+}
+
+func TestSubnet(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want string
+	}{
+		{ip: "10.0.0.1", want: "10.0.0.0/24"},
+		{ip: "::ffff:10.0.1.200", want: "10.0.1.0/24"},
+		{ip: "2001:db8:1:2:3::1", want: "2001:db8:1:2::/64"},
+		{ip: "", want: ""},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			if got := Subnet(net.ParseIP(tt.ip)); got != tt.want {
+				t.Errorf("Subnet() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+// Package detect finds the attacks in the stream of the parsed auth events.
+package detect
+
+import (
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+type AlertKind string
+
+const (
+	AlertBruteForce       AlertKind = "brute_force"
+	AlertImpossibleTravel AlertKind = "impossible_travel"
+	AlertRule             AlertKind = "rule"
+)
+
+// Scope is the attribute of the events counted together.
+type Scope string
+
+const (
+	ScopeIP     Scope = "ip"
+	ScopeUser   Scope = "user"
+	ScopeSubnet Scope = "subnet"
+)
+
+// Alert is the detected attack, it is sent to the notifiers.
+type Alert struct {
+	Kind  AlertKind `json:"kind"`
+	Scope Scope     `json:"scope,omitempty"`
+	// Key is the value of the scope, like the address or the username.
+	Key   string `json:"key,omitempty"`
+	Count int    `json:"count,omitempty"`
+	// Threshold is the crossed limit, the attempts or the speed.
+	Threshold int           `json:"threshold,omitempty"`
+	Window    time.Duration `json:"window,omitempty"`
+	// Since is the date of the first counted event, Date is the date of the last one.
+	Since time.Time `json:"since"`
+	Date  time.Time `json:"date"`
+	// Usernames and Addrs are the distinct values of the counted events.
+	Usernames []string `json:"usernames,omitempty"`
+	Addrs     []string `json:"addrs,omitempty"`
+
+	// Distance in km and Speed in km/h between the Sessions of the travel.
+	Distance float64      `json:"distance,omitempty"`
+	Speed    float64      `json:"speed,omitempty"`
+	Sessions []db.Session `json:"sessions,omitempty"`
+
+	// Rule is the name of the matched rule, the alerts of the other kinds go to the default channels.
+	Rule     string           `json:"rule,omitempty"`
+	Severity config.Severity  `json:"severity,omitempty"`
+	Channels []config.Channel `json:"channels,omitempty"`
+	Event    *db.AuthInfo     `json:"event,omitempty"`
+}
+
+// Detector looks at the events one by one in the date order.
+type Detector interface {
+	// Observe returns the alerts raised by the event.
+	Observe(info db.AuthInfo) []Alert
+}
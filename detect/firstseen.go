@@ -0,0 +1,29 @@
+package detect
+
+import (
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+// FirstSeen evaluates the accepted login against the sessions of the user stored before it.
+// The address, the /24 or /64 network and the auth method are new if no stored session
+// has the login with them; the failed attempts from the address do not count.
+func FirstSeen(sessions []db.Session, info db.AuthInfo) db.FirstSeen {
+	mark := db.FirstSeen{IP: true, Subnet: true, AuthMethod: true}
+	subnet := Subnet(info.RemoteAddr)
+	for _, s := range sessions {
+		if s.FirstLogInTime == nil {
+			continue
+		}
+		if s.RemoteAddr.Equal(info.RemoteAddr) {
+			mark.IP = false
+		}
+		if Subnet(s.RemoteAddr) == subnet {
+			mark.Subnet = false
+		}
+		// the methods of the closed connections are kept with the zero count
+		if _, ok := s.AuthMethods[info.AuthMethod]; ok {
+			mark.AuthMethod = false
+		}
+	}
+	return mark
+}
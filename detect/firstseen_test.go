@@ -0,0 +1,72 @@
+package detect
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+func TestFirstSeen(t *testing.T) {
+	date := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	login := func(addr string, methods ...string) db.Session {
+		s := db.Session{RemoteAddr: net.ParseIP(addr), FirstLogInTime: &date, AuthMethods: map[string]int32{}}
+		for _, method := range methods {
+			s.AuthMethods[method] = 0
+		}
+		return s
+	}
+	accepted := func(addr, method string) db.AuthInfo {
+		return db.AuthInfo{Status: db.AuthAccepted, Username: "sheb", AuthMethod: method, RemoteAddr: net.ParseIP(addr), Date: date}
+	}
+
+	tests := []struct {
+		sessions []db.Session
+		info     db.AuthInfo
+		want     db.FirstSeen
+	}{
+		{
+			info: accepted("10.0.0.1", "publickey"),
+			want: db.FirstSeen{IP: true, Subnet: true, AuthMethod: true},
+		},
+		{
+			sessions: []db.Session{login("10.0.0.1", "publickey")},
+			info:     accepted("10.0.0.1", "publickey"),
+			want:     db.FirstSeen{},
+		},
+		{
+			sessions: []db.Session{login("10.0.0.1", "publickey")},
+			info:     accepted("::ffff:10.0.0.1", "password"),
+			want:     db.FirstSeen{AuthMethod: true},
+		},
+		{
+			sessions: []db.Session{login("10.0.0.1", "publickey"), login("192.0.2.1", "password")},
+			info:     accepted("10.0.0.2", "password"),
+			want:     db.FirstSeen{IP: true},
+		},
+		{
+			// the failed attempts are not the logins
+			sessions: []db.Session{{RemoteAddr: net.ParseIP("2001:db8::1"), FailsCount: 3}},
+			info:     accepted("2001:db8::2", "password"),
+			want:     db.FirstSeen{IP: true, Subnet: true, AuthMethod: true},
+		},
+		{
+			sessions: []db.Session{login("2001:db8::1", "password")},
+			info:     accepted("2001:db8::2", "password"),
+			want:     db.FirstSeen{IP: true},
+		},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			got := FirstSeen(tt.sessions, tt.info)
+			if got != tt.want {
+				t.Errorf("FirstSeen() got = %+v, want %+v", got, tt.want)
+			}
+			if got.IsNew() != (tt.want != db.FirstSeen{}) {
+				t.Errorf("IsNew() got = %v", got.IsNew())
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package detect
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/rules"
+)
+
+// Rules matches the events with the expressions of the config rules, every matched rule
+// raises the alert with its severity and channels.
+type Rules struct {
+	rules   []rule
+	fails   *failWindow
+	history rules.History
+}
+
+type rule struct {
+	config config.RuleConfig
+	expr   *rules.Expr
+}
+
+// NewRules compiles the expressions, the error names the rule and the position in its expression.
+func NewRules(configs []config.RuleConfig, auth db.AuthStorage) (*Rules, error) {
+	d := &Rules{fails: &failWindow{attempts: map[string][]time.Time{}}}
+	for _, cfg := range configs {
+		expr, err := rules.Compile(cfg.When)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %s", cfg.Name, err)
+		}
+		d.rules = append(d.rules, rule{config: cfg, expr: expr})
+
+		if window := expr.Window("fails_in"); window > d.fails.window {
+			d.fails.window = window
+		}
+	}
+	d.history = ruleHistory{storageHistory: storageHistory{auth: auth}, failWindow: d.fails}
+	return d, nil
+}
+
+func (d *Rules) Observe(info db.AuthInfo) (alerts []Alert) {
+	d.fails.observe(info)
+
+	for _, r := range d.rules {
+		// the rule failed on the storage does not match
+		if ok, err := r.expr.Match(info, d.history); err != nil || !ok {
+			continue
+		}
+
+		event := info
+		alert := Alert{
+			Kind:     AlertRule,
+			Since:    info.Date,
+			Date:     info.Date,
+			Rule:     r.config.Name,
+			Severity: r.config.Severity,
+			Channels: r.config.Channels,
+			Event:    &event,
+		}
+		if info.Username != "" {
+			alert.Usernames = []string{info.Username}
+		}
+		if len(info.RemoteAddr) != 0 {
+			alert.Addrs = []string{info.RemoteAddr.String()}
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts
+}
+
+// ruleHistory counts the failures in the observed events, they are not stored with ignore_fails,
+// the rest of the history is stored.
+type ruleHistory struct {
+	storageHistory
+	*failWindow
+}
+
+// failWindow keeps the dates of the failed attempts per address for the longest fails_in() window,
+// the window slides by the event dates as in BruteForce.
+type failWindow struct {
+	window    time.Duration
+	attempts  map[string][]time.Time
+	lastSweep time.Time
+}
+
+func (w *failWindow) observe(info db.AuthInfo) {
+	if w.window <= 0 || !info.Status.IsFailure() || len(info.RemoteAddr) == 0 {
+		return
+	}
+	w.sweep(info.Date)
+
+	// the events of several sources are almost ordered
+	addr := info.RemoteAddr.String()
+	dates := w.attempts[addr]
+	i := len(dates)
+	for i > 0 && dates[i-1].After(info.Date) {
+		i--
+	}
+	dates = append(dates, time.Time{})
+	copy(dates[i+1:], dates[i:])
+	dates[i] = info.Date
+
+	cutoff := dates[len(dates)-1].Add(-w.window)
+	first := 0
+	for first < len(dates) && dates[first].Before(cutoff) {
+		first++
+	}
+	w.attempts[addr] = append(dates[:0], dates[first:]...)
+}
+
+// sweep drops the addresses without the attempts in the window.
+func (w *failWindow) sweep(date time.Time) {
+	if date.Sub(w.lastSweep) < w.window {
+		return
+	}
+	w.lastSweep = date
+
+	cutoff := date.Add(-w.window)
+	for addr, dates := range w.attempts {
+		if dates[len(dates)-1].Before(cutoff) {
+			delete(w.attempts, addr)
+		}
+	}
+}
+
+// FailsIn counts the observed failures from the address, the event and the ones at its date included.
+func (w *failWindow) FailsIn(info db.AuthInfo, window time.Duration) (int, error) {
+	if len(info.RemoteAddr) == 0 {
+		return 0, nil
+	}
+
+	n := 0
+	from := info.Date.Add(-window)
+	for _, date := range w.attempts[info.RemoteAddr.String()] {
+		if !date.Before(from) && !date.After(info.Date) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// storageHistory is the history of the rules in the stored events and sessions,
+// the events stored at the date of the evaluated one are not counted.
+type storageHistory struct {
+	auth db.AuthStorage
+}
+
+func (h storageHistory) LoginsIn(info db.AuthInfo, window time.Duration) (int, error) {
+	n := 0
+	if info.Status == db.AuthAccepted {
+		n++
+	}
+	if info.Username == "" {
+		return n, nil
+	}
+
+	count, err := h.count(db.EventFilter{Username: info.Username, Statuses: []db.AuthStatus{db.AuthAccepted},
+		From: info.Date.Add(-window), To: info.Date}, nil)
+	return n + count, err
+}
+
+func (h storageHistory) FirstSeen(info db.AuthInfo) (bool, error) {
+	sessions, err := h.auth.GetUserSessions(info.Username)
+	if err != nil {
+		return false, err
+	}
+
+	// the login may be stored already
+	var before []db.Session
+	for _, session := range sessions {
+		if session.FirstLogInTime != nil && session.FirstLogInTime.Before(info.Date) {
+			before = append(before, session)
+		}
+	}
+	return FirstSeen(before, info).IsNew(), nil
+}
+
+// count pages through the events of the filter and counts the matched ones, nil matches all.
+func (h storageHistory) count(filter db.EventFilter, match func(db.AuthEvent) bool) (int, error) {
+	n := 0
+	filter.Limit = db.MaxEventsLimit
+	for {
+		page, err := h.auth.Events(filter)
+		if err != nil {
+			return n, err
+		}
+		for _, event := range page.Events {
+			if match == nil || match(event) {
+				n++
+			}
+		}
+		if page.Next == "" {
+			return n, nil
+		}
+		filter.Cursor = page.Next
+	}
+}
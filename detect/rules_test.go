@@ -0,0 +1,135 @@
+package detect
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+func TestNewRules(t *testing.T) {
+	_, err := NewRules([]config.RuleConfig{
+		{Name: "root", When: `user == "root"`},
+		{Name: "spray", When: `fails_in(10m) > 20 && !cidr("10.0.0.0/8"`},
+	}, db.NewMemoryStorage().Auth())
+	want := `rule "spray": at 41: unexpected end of expression`
+	if err == nil || err.Error() != want {
+		t.Errorf("NewRules() error = %v, want %s", err, want)
+	}
+}
+
+func TestRules_Observe(t *testing.T) {
+	start := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	event := func(status db.AuthStatus, username, addr string, at time.Duration) db.AuthInfo {
+		return db.AuthInfo{Status: status, Username: username, AuthMethod: "password",
+			RemoteAddr: net.ParseIP(addr), Date: start.Add(at)}
+	}
+	configs := []config.RuleConfig{
+		{Name: "root", When: `status == "Accepted" && user == "root"`, Severity: config.SeverityCritical,
+			Channels: []config.Channel{config.ChannelTG, config.ChannelLog}},
+		{Name: "spray", When: `fails_in(10m) >= 3 && !cidr("10.0.0.0/8")`, Severity: config.SeverityWarning,
+			Channels: []config.Channel{config.ChannelLog}},
+		{Name: "new", When: `first_seen() && logins_in(1h) > 1`, Severity: config.SeverityInfo,
+			Channels: []config.Channel{config.ChannelTG}},
+	}
+
+	tests := []struct {
+		// observed are the events before the evaluated one, the failures are not stored with ignore_fails
+		observed []db.AuthInfo
+		// stored are the events before the evaluated one
+		stored []db.AuthInfo
+		info   db.AuthInfo
+		want   []string
+	}{
+		{
+			info: event(db.AuthAccepted, "root", "10.0.0.1", 0),
+			want: []string{"root"},
+		},
+		{
+			observed: []db.AuthInfo{
+				event(db.AuthFailed, "admin", "81.2.69.142", -20*time.Minute),
+				event(db.AuthInvalidUser, "test", "81.2.69.142", -5*time.Minute),
+				event(db.AuthAccepted, "sheb", "81.2.69.142", -4*time.Minute),
+				event(db.AuthFailed, "admin", "81.2.69.142", -time.Minute),
+			},
+			info: event(db.AuthFailed, "admin", "81.2.69.142", 0),
+			want: []string{"spray"},
+		},
+		{
+			// the failures logged in the same second are counted
+			observed: []db.AuthInfo{
+				event(db.AuthFailed, "admin", "81.2.69.142", 0),
+				event(db.AuthFailed, "root", "81.2.69.142", 0),
+				event(db.AuthFailed, "test", "81.2.69.142", 0),
+			},
+			info: event(db.AuthAccepted, "sheb", "81.2.69.142", 0),
+			want: []string{"spray"},
+		},
+		{
+			// the failures of the other address are not counted
+			observed: []db.AuthInfo{
+				event(db.AuthFailed, "admin", "81.2.69.143", -2*time.Minute),
+				event(db.AuthFailed, "admin", "81.2.69.143", -time.Minute),
+			},
+			info: event(db.AuthFailed, "admin", "81.2.69.142", 0),
+		},
+		{
+			// the private network is excluded
+			observed: []db.AuthInfo{
+				event(db.AuthFailed, "admin", "10.0.0.1", -2*time.Minute),
+				event(db.AuthFailed, "admin", "10.0.0.1", -time.Minute),
+			},
+			info: event(db.AuthFailed, "admin", "10.0.0.1", 0),
+		},
+		{
+			// the observed login is stored already, it is counted once
+			stored: []db.AuthInfo{
+				event(db.AuthAccepted, "sheb", "10.0.0.1", -30*time.Minute),
+				event(db.AuthAccepted, "sheb", "81.2.69.142", 0),
+			},
+			info: event(db.AuthAccepted, "sheb", "81.2.69.142", 0),
+			want: []string{"new"},
+		},
+		{
+			stored: []db.AuthInfo{
+				event(db.AuthAccepted, "sheb", "81.2.69.142", -2*time.Hour),
+				event(db.AuthAccepted, "sheb", "10.0.0.1", -30*time.Minute),
+			},
+			info: event(db.AuthAccepted, "sheb", "81.2.69.142", 0),
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			storage := db.NewMemoryStorage()
+			for _, info := range tt.stored {
+				if _, err := storage.Auth().UpsetAuthEvent(info); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d, err := NewRules(configs, storage.Auth())
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, info := range tt.observed {
+				d.Observe(info)
+			}
+			var got []string
+			for _, alert := range d.Observe(tt.info) {
+				if alert.Kind != AlertRule || alert.Event == nil || !reflect.DeepEqual(*alert.Event, tt.info) ||
+					alert.Date != tt.info.Date {
+					t.Errorf("Observe() got = %+v", alert)
+				}
+				got = append(got, alert.Rule)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Observe() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package detect
+
+import (
+	"math"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+// earthRadius is the mean radius of the Earth in km.
+const earthRadius = 6371.0
+
+// ImpossibleTravel compares the locations of the consecutive accepted logins of the user,
+// the travel faster than the max speed between them raises the alert. The accuracy radiuses
+// of the locations are taken off the distance, the nearby cities of the same network do not alert.
+// The last login of the user is kept in memory, it is loaded from the stored sessions on the first login.
+type ImpossibleTravel struct {
+	config config.ImpossibleTravelConfig
+	auth   db.AuthStorage
+	last   map[string]db.Session
+}
+
+func NewImpossibleTravel(config config.ImpossibleTravelConfig, auth db.AuthStorage) *ImpossibleTravel {
+	return &ImpossibleTravel{
+		config: config,
+		auth:   auth,
+		last:   map[string]db.Session{},
+	}
+}
+
+func (d *ImpossibleTravel) Observe(info db.AuthInfo) []Alert {
+	if info.Status != db.AuthAccepted || info.Username == "" || info.Geo == nil || !info.Geo.HasLocation() {
+		return nil
+	}
+
+	// the storage fails rarely, the login is compared with the kept one then
+	sessions, _ := d.auth.GetUserSessions(info.Username)
+	current := loginSession(sessions, info)
+
+	prev, ok := d.last[info.Username]
+	if !ok {
+		prev, ok = previousLogin(sessions, info.Date)
+	}
+	if !ok || prev.LastLogInTime.Before(info.Date) {
+		d.last[info.Username] = current
+	}
+	if !ok {
+		return nil
+	}
+
+	distance := Distance(*prev.Geo, *info.Geo) - float64(prev.Geo.AccuracyRadius) - float64(info.Geo.AccuracyRadius)
+	if distance <= 0 {
+		return nil
+	}
+	// the late login of the other source is the first one
+	first, second := prev, current
+	since, date := *prev.LastLogInTime, info.Date
+	elapsed := date.Sub(since)
+	if elapsed < 0 {
+		first, second = current, prev
+		since, date, elapsed = date, since, -elapsed
+	}
+	// the log dates have the second precision
+	if elapsed < time.Second {
+		elapsed = time.Second
+	}
+	speed := distance / elapsed.Hours()
+	if speed <= float64(d.config.MaxSpeed) {
+		return nil
+	}
+
+	return []Alert{{
+		Kind:      AlertImpossibleTravel,
+		Scope:     ScopeUser,
+		Key:       info.Username,
+		Threshold: d.config.MaxSpeed,
+		Since:     since,
+		Date:      date,
+		Usernames: []string{info.Username},
+		Addrs:     []string{first.RemoteAddr.String(), second.RemoteAddr.String()},
+		Distance:  math.Round(distance),
+		Speed:     math.Round(speed),
+		Sessions:  []db.Session{first, second},
+	}}
+}
+
+// loginSession returns the session of the login address updated by the login,
+// the watcher may store the login before or after the detection.
+func loginSession(sessions []db.Session, info db.AuthInfo) db.Session {
+	for _, session := range sessions {
+		if !session.RemoteAddr.Equal(info.RemoteAddr) {
+			continue
+		}
+		if session.LastLogInTime == nil || session.LastLogInTime.Before(info.Date) {
+			session.Update(info)
+		}
+		return session
+	}
+	return db.NewSession(0, info)
+}
+
+// previousLogin returns the latest stored login with the location before the date.
+func previousLogin(sessions []db.Session, date time.Time) (prev db.Session, ok bool) {
+	for _, session := range sessions {
+		if session.Geo == nil || !session.Geo.HasLocation() ||
+			session.LastLogInTime == nil || !session.LastLogInTime.Before(date) {
+			continue
+		}
+		if !ok || session.LastLogInTime.After(*prev.LastLogInTime) {
+			prev, ok = session, true
+		}
+	}
+	return
+}
+
+// Distance returns the great-circle distance between the locations in km.
+func Distance(a, b db.GeoInfo) float64 {
+	lat1, lat2 := a.Latitude*math.Pi/180, b.Latitude*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Longitude - a.Longitude) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
@@ -0,0 +1,142 @@
+package detect
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+var (
+	london = &db.GeoInfo{Country: "GB", City: "London", Latitude: 51.5074, Longitude: -0.1278, AccuracyRadius: 10}
+	paris  = &db.GeoInfo{Country: "FR", City: "Paris", Latitude: 48.8566, Longitude: 2.3522, AccuracyRadius: 10}
+	tokyo  = &db.GeoInfo{Country: "JP", City: "Tokyo", Latitude: 35.6762, Longitude: 139.6503, AccuracyRadius: 20}
+	// the country location of the nearby address
+	britain = &db.GeoInfo{Country: "GB", Latitude: 51.4964, Longitude: -0.1224, AccuracyRadius: 200}
+)
+
+func TestImpossibleTravel_Observe(t *testing.T) {
+	start := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	login := func(addr string, geo *db.GeoInfo, at time.Duration) db.AuthInfo {
+		return db.AuthInfo{Status: db.AuthAccepted, Username: "sheb", AuthMethod: "publickey",
+			RemoteAddr: net.ParseIP(addr), Date: start.Add(at), Geo: geo}
+	}
+
+	tests := []struct {
+		// stored are the logins before the start of the detector
+		stored []db.AuthInfo
+		events []db.AuthInfo
+		want   []string
+	}{
+		{
+			// the flight
+			events: []db.AuthInfo{
+				login("81.2.69.1", london, 0),
+				login("89.160.20.1", paris, 2*time.Hour),
+				login("81.2.69.1", london, 4*time.Hour),
+			},
+		},
+		{
+			events: []db.AuthInfo{
+				login("81.2.69.1", london, 0),
+				login("2001:db8::1", tokyo, 2*time.Hour),
+			},
+			want: []string{"81.2.69.1 London -> 2001:db8::1 Tokyo 4764 km/h"},
+		},
+		{
+			// the last login is loaded from the storage
+			stored: []db.AuthInfo{
+				login("2001:db8::1", tokyo, -time.Hour),
+				login("81.2.69.1", london, -10*time.Hour),
+			},
+			events: []db.AuthInfo{
+				login("81.2.69.1", london, 0),
+			},
+			want: []string{"2001:db8::1 Tokyo -> 81.2.69.1 London 9529 km/h"},
+		},
+		{
+			// the locations are within the accuracy radius
+			events: []db.AuthInfo{
+				login("81.2.69.1", london, 0),
+				login("81.2.69.200", britain, time.Minute),
+			},
+		},
+		{
+			// the failures and the logins without the location are skipped
+			events: []db.AuthInfo{
+				login("81.2.69.1", london, 0),
+				{Status: db.AuthFailed, Username: "sheb", RemoteAddr: net.ParseIP("2001:db8::1"),
+					Date: start.Add(time.Minute), Geo: tokyo},
+				login("10.0.0.1", nil, 2*time.Minute),
+				login("89.160.20.1", paris, 3*time.Hour),
+			},
+		},
+		{
+			// the late login of the other source
+			events: []db.AuthInfo{
+				login("89.160.20.1", paris, time.Hour),
+				login("81.2.69.1", london, 0),
+				login("2001:db8::1", tokyo, 2*time.Hour),
+			},
+			want: []string{
+				"81.2.69.1 London -> 89.160.20.1 Paris 324 km/h",
+				"89.160.20.1 Paris -> 2001:db8::1 Tokyo 9682 km/h",
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			storage := db.NewMemoryStorage()
+			for _, info := range tt.stored {
+				if _, err := storage.Auth().UpsetAuthEvent(info); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := NewImpossibleTravel(config.ImpossibleTravelConfig{MaxSpeed: 300}, storage.Auth())
+			var got []string
+			for _, info := range tt.events {
+				for _, alert := range d.Observe(info) {
+					if alert.Kind != AlertImpossibleTravel || alert.Key != "sheb" || len(alert.Sessions) != 2 ||
+						alert.Since != *alert.Sessions[0].LastLogInTime {
+						t.Errorf("Observe() got = %+v", alert)
+					}
+					got = append(got, fmt.Sprintf("%s %s -> %s %s %.0f km/h",
+						alert.Addrs[0], alert.Sessions[0].Geo.City, alert.Addrs[1], alert.Sessions[1].Geo.City, alert.Speed))
+				}
+				// the watcher stores the login after sending it to the detection
+				if _, err := storage.Auth().UpsetAuthEvent(info); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Observe() got = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		a, b *db.GeoInfo
+		want float64
+	}{
+		{a: london, b: london, want: 0},
+		{a: london, b: paris, want: 344},
+		{a: paris, b: london, want: 344},
+		{a: london, b: tokyo, want: 9559},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			if got := Distance(*tt.a, *tt.b); math.Round(got) != tt.want {
+				t.Errorf("Distance() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+// Package geoip looks up the location and the network owner of the addresses
+// in the local MaxMind format databases.
+package geoip
+
+import (
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+// cityRecord is the subset of the GeoLite2-City and GeoLite2-Country records.
+type cityRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Location struct {
+		Latitude       float64 `maxminddb:"latitude"`
+		Longitude      float64 `maxminddb:"longitude"`
+		AccuracyRadius uint16  `maxminddb:"accuracy_radius"`
+	} `maxminddb:"location"`
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+// Resolver looks up the addresses, the nil Resolver finds nothing.
+type Resolver struct {
+	city *maxminddb.Reader
+	asn  *maxminddb.Reader
+}
+
+// Open opens the databases of the config, the empty paths are skipped.
+func Open(cfg config.GeoIPConfig) (*Resolver, error) {
+	r := &Resolver{}
+	var err error
+	if cfg.City != "" {
+		if r.city, err = maxminddb.Open(cfg.City); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.ASN != "" {
+		if r.asn, err = maxminddb.Open(cfg.ASN); err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Lookup returns the location of the address, nil if the databases have nothing about it,
+// like about the private networks.
+func (r *Resolver) Lookup(ip net.IP) (*db.GeoInfo, error) {
+	if r == nil || len(ip) == 0 {
+		return nil, nil
+	}
+
+	geo := db.GeoInfo{}
+	if r.city != nil {
+		var record cityRecord
+		if err := r.city.Lookup(ip, &record); err != nil {
+			return nil, err
+		}
+		geo.Country = record.Country.ISOCode
+		geo.City = record.City.Names["en"]
+		geo.Latitude = record.Location.Latitude
+		geo.Longitude = record.Location.Longitude
+		geo.AccuracyRadius = record.Location.AccuracyRadius
+	}
+	if r.asn != nil {
+		var record asnRecord
+		if err := r.asn.Lookup(ip, &record); err != nil {
+			return nil, err
+		}
+		geo.ASN = record.Number
+		geo.ASOrg = record.Organization
+	}
+
+	if geo == (db.GeoInfo{}) {
+		return nil, nil
+	}
+	return &geo, nil
+}
+
+// Enrich sets the location of the event address.
+func (r *Resolver) Enrich(info *db.AuthInfo) (err error) {
+	info.Geo, err = r.Lookup(info.RemoteAddr)
+	return
+}
+
+func (r *Resolver) Close() error {
+	if r == nil {
+		return nil
+	}
+	for _, reader := range []*maxminddb.Reader{r.city, r.asn} {
+		if reader == nil {
+			continue
+		}
+		if err := reader.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package geoip
+
+import (
+	"fmt"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+//go:generate sh -c "cd testdata/mmdbgen && go run . .."
+
+// The testdata databases are written by testdata/mmdbgen with the few networks of the MaxMind test data.
+func TestResolver_Lookup(t *testing.T) {
+	r, err := Open(config.GeoIPConfig{
+		City: filepath.Join("testdata", "city.mmdb"),
+		ASN:  filepath.Join("testdata", "asn.mmdb"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	tests := []struct {
+		ip   string
+		want *db.GeoInfo
+	}{
+		{ip: "81.2.69.142", want: &db.GeoInfo{Country: "GB", City: "London", Latitude: 51.5142, Longitude: -0.0931,
+			AccuracyRadius: 10, ASN: 20712, ASOrg: "Andrews & Arnold Ltd"}},
+		{ip: "::ffff:89.160.20.112", want: &db.GeoInfo{Country: "SE", City: "Linköping", Latitude: 58.4167,
+			Longitude: 15.6167, AccuracyRadius: 76, ASN: 29518, ASOrg: "Bredband2 AB"}},
+		// the country only
+		{ip: "2001:db8::1", want: &db.GeoInfo{Country: "JP", Latitude: 35.69, Longitude: 139.69, AccuracyRadius: 100}},
+		{ip: "10.0.0.1"},
+		{ip: "8.8.8.8"},
+		{ip: ""},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			got, err := r.Lookup(net.ParseIP(tt.ip))
+			if err != nil {
+				t.Fatalf("Lookup() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lookup() got = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolver_Enrich(t *testing.T) {
+	r, err := Open(config.GeoIPConfig{ASN: filepath.Join("testdata", "asn.mmdb")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	info := db.AuthInfo{RemoteAddr: net.ParseIP("81.2.69.142")}
+	if err = r.Enrich(&info); err != nil {
+		t.Fatal(err)
+	}
+	want := &db.GeoInfo{ASN: 20712, ASOrg: "Andrews & Arnold Ltd"}
+	if !reflect.DeepEqual(info.Geo, want) {
+		t.Errorf("Enrich() got = %+v, want %+v", info.Geo, want)
+	}
+
+	// the nil resolver is the disabled GeoIP
+	var disabled *Resolver
+	if err = disabled.Enrich(&info); err != nil || info.Geo != nil {
+		t.Errorf("Enrich() of nil got = %+v, %v, want nil", info.Geo, err)
+	}
+
+	if _, err = Open(config.GeoIPConfig{City: filepath.Join("testdata", "missing.mmdb")}); err == nil {
+		t.Error("Open() of the missing file got nil error")
+	}
+}
@@ -0,0 +1,62 @@
+// Command mmdbgen writes the tiny GeoIP fixtures of the geoip tests, run by go generate in geoip.
+// It is the separate module, mmdbwriter needs the newer Go than uwatch.
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+
+	"github.com/maxmind/mmdbwriter"
+	"github.com/maxmind/mmdbwriter/mmdbtype"
+)
+
+func write(path, dbType string, records map[string]mmdbtype.Map) {
+	w, err := mmdbwriter.New(mmdbwriter.Options{DatabaseType: dbType, RecordSize: 24, IncludeReservedNetworks: true})
+	if err != nil {
+		log.Fatal(err)
+	}
+	for cidr, rec := range records {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := w.Insert(network, rec); err != nil {
+			log.Fatal(err)
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err := w.WriteTo(f); err != nil {
+		log.Fatal(err)
+	}
+	f.Close()
+}
+
+func city(iso, name string, lat, lon float64, radius uint16) mmdbtype.Map {
+	rec := mmdbtype.Map{
+		"country": mmdbtype.Map{"iso_code": mmdbtype.String(iso), "names": mmdbtype.Map{"en": mmdbtype.String(iso)}},
+		"location": mmdbtype.Map{"latitude": mmdbtype.Float64(lat), "longitude": mmdbtype.Float64(lon),
+			"accuracy_radius": mmdbtype.Uint16(radius)},
+	}
+	if name != "" {
+		rec["city"] = mmdbtype.Map{"names": mmdbtype.Map{"en": mmdbtype.String(name)}}
+	}
+	return rec
+}
+
+func main() {
+	write(os.Args[1]+"/city.mmdb", "GeoLite2-City", map[string]mmdbtype.Map{
+		"81.2.69.0/24":   city("GB", "London", 51.5142, -0.0931, 10),
+		"89.160.20.0/24": city("SE", "Linköping", 58.4167, 15.6167, 76),
+		"2001:db8::/32":  city("JP", "", 35.69, 139.69, 100),
+	})
+	write(os.Args[1]+"/asn.mmdb", "GeoLite2-ASN", map[string]mmdbtype.Map{
+		"81.2.69.0/24": {"autonomous_system_number": mmdbtype.Uint32(20712),
+			"autonomous_system_organization": mmdbtype.String("Andrews & Arnold Ltd")},
+		"89.160.20.0/24": {"autonomous_system_number": mmdbtype.Uint32(29518),
+			"autonomous_system_organization": mmdbtype.String("Bredband2 AB")},
+	})
+}
@@ -4,13 +4,11 @@ go 1.13
 
 require (
 	github.com/go-telegram-bot-api/telegram-bot-api v4.6.4+incompatible
-	github.com/hpcloud/tail v1.0.0
 	github.com/lancer-kit/noble v1.0.8
 	github.com/lancer-kit/uwe/v2 v2.0.6
+	github.com/oschwald/maxminddb-golang v1.3.1
 	github.com/sirupsen/logrus v1.4.2
 	github.com/technoweenie/multipartstreamer v1.0.1 // indirect
 	go.etcd.io/bbolt v1.3.3
-	golang.org/x/sys v0.0.0-20200106162015-b016eb3dc98e // indirect
-	gopkg.in/fsnotify.v1 v1.4.7 // indirect
-	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
+	modernc.org/sqlite v1.20.0
 )
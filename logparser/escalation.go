@@ -0,0 +1,159 @@
+package logparser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+const (
+	ParserSudo = "sudo"
+	ParserSu   = "su"
+)
+
+func init() {
+	Register(NewSudoParser())
+	Register(NewSuParser())
+}
+
+type sudoParser struct {
+	lineReg     *regexp.Regexp
+	attemptsReg *regexp.Regexp
+}
+
+// NewSudoParser parses the sudo command lines, like
+// "sheb : 3 incorrect password attempts ; TTY=pts/0 ; PWD=/home/sheb ; USER=root ; COMMAND=/bin/ls".
+// The pam_unix lines of sudo repeat them and are not supported.
+func NewSudoParser() Parser {
+	return &sudoParser{
+		lineReg:     regexp.MustCompile(`^\s*(?P<user>[^\s:]+) : (?P<fields>.*)$`),
+		attemptsReg: regexp.MustCompile(`^(\d+) incorrect password attempts?$`),
+	}
+}
+
+func (p *sudoParser) Name() string {
+	return ParserSudo
+}
+
+func (p *sudoParser) Match(program string) bool {
+	return program == "sudo"
+}
+
+func (p *sudoParser) Parse(rec Record) (*Event, error) {
+	matches := p.lineReg.FindStringSubmatch(rec.Message)
+	if matches == nil {
+		return nil, ErrUnSupportedStatus
+	}
+
+	escalation := &db.Escalation{
+		Tool:     ParserSudo,
+		Status:   db.EscalationGranted,
+		Username: matches[1],
+		Date:     rec.Time,
+	}
+
+	fields := strings.Split(matches[2], " ; ")
+	for i, field := range fields {
+		eq := strings.IndexByte(field, '=')
+		if i == 0 && eq < 0 {
+			escalation.Status = db.EscalationDenied
+			if attempts := p.attemptsReg.FindStringSubmatch(field); attempts != nil {
+				escalation.Status = db.EscalationFailed
+				escalation.Attempts, _ = strconv.Atoi(attempts[1])
+			}
+			continue
+		}
+		if eq < 0 {
+			continue
+		}
+
+		switch field[:eq] {
+		case "TTY":
+			escalation.TTY = field[eq+1:]
+		case "PWD":
+			escalation.PWD = field[eq+1:]
+		case "USER":
+			escalation.TargetUser = field[eq+1:]
+		case "COMMAND":
+			// the command is the last field and may contain the separator
+			escalation.Command = strings.Join(append([]string{field[eq+1:]}, fields[i+1:]...), " ; ")
+		}
+		if escalation.Command != "" {
+			break
+		}
+	}
+
+	if escalation.Command == "" {
+		return nil, ErrUnSupportedStatus
+	}
+	if escalation.TargetUser == "" {
+		escalation.TargetUser = "root"
+	}
+
+	return &Event{Type: EventEscalation, Host: rec.Host, Escalation: escalation}, nil
+}
+
+// suRule maps a message pattern to the escalation status.
+// Pattern fields are taken from the named groups: `user`, `target` and `tty`.
+type suRule struct {
+	status db.EscalationStatus
+	reg    *regexp.Regexp
+}
+
+type suParser struct {
+	rules []suRule
+}
+
+// NewSuParser parses the pam_unix session lines of su and the failed su lines.
+// The "(to root) sheb on pts/0" lines repeat the pam_unix ones and are not supported.
+func NewSuParser() Parser {
+	rule := func(status db.EscalationStatus, pattern string) suRule {
+		return suRule{status: status, reg: regexp.MustCompile(pattern)}
+	}
+
+	return &suParser{rules: []suRule{
+		rule(db.EscalationGranted, `^pam_unix\(su(?:-l)?:session\): session opened for user (?P<target>[^\s(]+)(?:\(uid=\d+\))? by (?P<user>[^\s(]*)\(uid=\d+\)`),
+		rule(db.EscalationClosed, `^pam_unix\(su(?:-l)?:session\): session closed for user (?P<target>\S+)`),
+		rule(db.EscalationFailed, `^FAILED SU \(to (?P<target>\S+)\) (?P<user>\S+) on (?P<tty>\S+)`),
+		// shadow-utils: "- pts/0 sheb:root"
+		rule(db.EscalationFailed, `^- (?P<tty>\S+) (?P<user>[^\s:]+):(?P<target>\S+)$`),
+	}}
+}
+
+func (p *suParser) Name() string {
+	return ParserSu
+}
+
+func (p *suParser) Match(program string) bool {
+	return program == "su"
+}
+
+func (p *suParser) Parse(rec Record) (*Event, error) {
+	for _, rule := range p.rules {
+		matches := rule.reg.FindStringSubmatch(rec.Message)
+		if matches == nil {
+			continue
+		}
+
+		escalation := &db.Escalation{Tool: ParserSu, Status: rule.status, Date: rec.Time}
+		for i, name := range rule.reg.SubexpNames() {
+			switch name {
+			case "user":
+				escalation.Username = matches[i]
+			case "target":
+				escalation.TargetUser = matches[i]
+			case "tty":
+				escalation.TTY = matches[i]
+			}
+		}
+		if rule.status == db.EscalationFailed {
+			escalation.Attempts = 1
+		}
+
+		return &Event{Type: EventEscalation, Host: rec.Host, Escalation: escalation}, nil
+	}
+
+	return nil, ErrUnSupportedStatus
+}
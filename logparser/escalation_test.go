@@ -0,0 +1,90 @@
+package logparser
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+func TestEscalationParsers(t *testing.T) {
+	tests := []struct {
+		logLine string
+		want    *db.Escalation
+		wantErr error
+	}{
+		{
+			logLine: "Jan  6 14:08:21 teamo sudo:     sheb : TTY=pts/0 ; PWD=/home/sheb ; USER=root ; COMMAND=/usr/bin/apt update",
+			want: &db.Escalation{Tool: ParserSudo, Status: db.EscalationGranted, Username: "sheb", TargetUser: "root",
+				TTY: "pts/0", PWD: "/home/sheb", Command: "/usr/bin/apt update"},
+		},
+		{
+			logLine: "Jan  6 14:08:21 teamo sudo:     sheb : TTY=pts/0 ; PWD=/home/sheb ; USER=postgres ; ENV=A=1 ; COMMAND=/bin/sh -c echo 1 ; echo 2",
+			want: &db.Escalation{Tool: ParserSudo, Status: db.EscalationGranted, Username: "sheb", TargetUser: "postgres",
+				TTY: "pts/0", PWD: "/home/sheb", Command: "/bin/sh -c echo 1 ; echo 2"},
+		},
+		{
+			logLine: "Jan  6 14:08:21 teamo sudo:     sheb : 3 incorrect password attempts ; TTY=pts/0 ; PWD=/home/sheb ; USER=root ; COMMAND=/bin/ls",
+			want: &db.Escalation{Tool: ParserSudo, Status: db.EscalationFailed, Username: "sheb", TargetUser: "root",
+				TTY: "pts/0", PWD: "/home/sheb", Command: "/bin/ls", Attempts: 3},
+		},
+		{
+			logLine: "Jan  6 14:08:21 teamo sudo:     sheb : 1 incorrect password attempt ; TTY=pts/0 ; PWD=/home/sheb ; USER=root ; COMMAND=/bin/ls",
+			want: &db.Escalation{Tool: ParserSudo, Status: db.EscalationFailed, Username: "sheb", TargetUser: "root",
+				TTY: "pts/0", PWD: "/home/sheb", Command: "/bin/ls", Attempts: 1},
+		},
+		{
+			logLine: "Jan  6 14:08:21 teamo sudo:    guest : user NOT in sudoers ; TTY=pts/1 ; PWD=/tmp ; USER=root ; COMMAND=/bin/bash",
+			want: &db.Escalation{Tool: ParserSudo, Status: db.EscalationDenied, Username: "guest", TargetUser: "root",
+				TTY: "pts/1", PWD: "/tmp", Command: "/bin/bash"},
+		},
+		{
+			logLine: "Jan  6 14:08:21 teamo sudo: pam_unix(sudo:auth): authentication failure; logname=sheb uid=1000 euid=0 tty=/dev/pts/0 ruser=sheb rhost=  user=sheb",
+			wantErr: ErrUnSupportedStatus,
+		},
+		{
+			logLine: "Jan  6 14:08:21 teamo su[4120]: pam_unix(su:session): session opened for user root(uid=0) by sheb(uid=1000)",
+			want:    &db.Escalation{Tool: ParserSu, Status: db.EscalationGranted, Username: "sheb", TargetUser: "root"},
+		},
+		{
+			logLine: "Jan  6 14:08:21 teamo su[4120]: pam_unix(su-l:session): session opened for user postgres by sheb(uid=1000)",
+			want:    &db.Escalation{Tool: ParserSu, Status: db.EscalationGranted, Username: "sheb", TargetUser: "postgres"},
+		},
+		{
+			logLine: "Jan  6 14:08:21 teamo su[4120]: pam_unix(su:session): session closed for user root",
+			want:    &db.Escalation{Tool: ParserSu, Status: db.EscalationClosed, TargetUser: "root"},
+		},
+		{
+			logLine: "Jan  6 14:08:21 teamo su[4121]: FAILED SU (to root) sheb on pts/0",
+			want: &db.Escalation{Tool: ParserSu, Status: db.EscalationFailed, Username: "sheb", TargetUser: "root",
+				TTY: "pts/0", Attempts: 1},
+		},
+		{
+			logLine: "Jan  6 14:08:21 teamo su[4121]: - pts/0 sheb:root",
+			want: &db.Escalation{Tool: ParserSu, Status: db.EscalationFailed, Username: "sheb", TargetUser: "root",
+				TTY: "pts/0", Attempts: 1},
+		},
+		{
+			logLine: "Jan  6 14:08:21 teamo su[4120]: (to root) sheb on pts/0",
+			wantErr: ErrUnSupportedStatus,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			got, err := Default().Parse(tt.logLine)
+			if err != tt.wantErr {
+				t.Fatalf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.want == nil {
+				return
+			}
+
+			assertField(t, got.Type, EventEscalation)
+			assertField(t, got.Parser, tt.want.Tool)
+			assertField(t, got.Host, "teamo")
+			tt.want.Date = got.Escalation.Date
+			assertField(t, *got.Escalation, *tt.want)
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package logparser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+type JournalFormat string
+
+const (
+	// JournalExport is the `journalctl -o export` stream.
+	JournalExport JournalFormat = "export"
+	// JournalJSON is the `journalctl -o json` stream.
+	JournalJSON JournalFormat = "json"
+)
+
+var ErrInvalidJournal = errors.New("invalid journal entry")
+
+// maxJournalFieldSize is the field size limit of journald, the larger size is the broken stream.
+const maxJournalFieldSize = 768 << 20
+
+// JournalEntry is the set of journal fields, binary fields are kept as is.
+type JournalEntry map[string]string
+
+func (e JournalEntry) Unit() string {
+	return e["_SYSTEMD_UNIT"]
+}
+
+// Record converts structured journal fields into the record without parsing a syslog header.
+func (e JournalEntry) Record() (Record, error) {
+	usec, err := strconv.ParseInt(e["__REALTIME_TIMESTAMP"], 10, 64)
+	if err != nil {
+		return Record{}, ErrInvalidJournal
+	}
+
+	rec := Record{
+		Time:    time.Unix(0, usec*int64(time.Microsecond)),
+		Host:    e["_HOSTNAME"],
+		Program: e["SYSLOG_IDENTIFIER"],
+		Message: e["MESSAGE"],
+	}
+	if rec.Program == "" {
+		rec.Program = e["_COMM"]
+	}
+
+	pid := e["_PID"]
+	if pid == "" {
+		pid = e["SYSLOG_PID"]
+	}
+	rec.PID, _ = strconv.Atoi(pid)
+
+	return rec, nil
+}
+
+// JournalReader decodes the journal entries from the journalctl output.
+type JournalReader struct {
+	format JournalFormat
+	r      *bufio.Reader
+}
+
+func NewJournalReader(r io.Reader, format JournalFormat) (*JournalReader, error) {
+	switch format {
+	case JournalExport, JournalJSON:
+	default:
+		return nil, fmt.Errorf("unsupported journal format %q", format)
+	}
+
+	return &JournalReader{format: format, r: bufio.NewReader(r)}, nil
+}
+
+// Next returns the next entry or io.EOF at the end of the stream.
+func (jr *JournalReader) Next() (JournalEntry, error) {
+	if jr.format == JournalJSON {
+		return jr.nextJSON()
+	}
+	return jr.nextExport()
+}
+
+func (jr *JournalReader) nextExport() (JournalEntry, error) {
+	entry := JournalEntry{}
+	for {
+		line, err := jr.r.ReadBytes('\n')
+		if err == io.EOF && len(line) == 0 {
+			if len(entry) > 0 {
+				return entry, nil
+			}
+			return nil, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		if len(line) == 0 {
+			if len(entry) == 0 {
+				continue
+			}
+			return entry, nil
+		}
+
+		if i := bytes.IndexByte(line, '='); i >= 0 {
+			entry[string(line[:i])] = string(line[i+1:])
+			continue
+		}
+
+		// binary field: name, little-endian uint64 size, data and the newline
+		var size uint64
+		if err := binary.Read(jr.r, binary.LittleEndian, &size); err != nil {
+			return nil, ErrInvalidJournal
+		}
+		if size > maxJournalFieldSize {
+			return nil, ErrInvalidJournal
+		}
+		data := make([]byte, size+1)
+		if _, err := io.ReadFull(jr.r, data); err != nil || data[size] != '\n' {
+			return nil, ErrInvalidJournal
+		}
+		entry[string(line)] = string(data[:size])
+	}
+}
+
+func (jr *JournalReader) nextJSON() (JournalEntry, error) {
+	for {
+		line, err := jr.r.ReadBytes('\n')
+		if err == io.EOF && len(bytes.TrimSpace(line)) == 0 {
+			return nil, io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return nil, ErrInvalidJournal
+		}
+
+		entry := JournalEntry{}
+		for name, raw := range fields {
+			if value, ok := jsonFieldValue(raw); ok {
+				entry[name] = value
+			}
+		}
+		return entry, nil
+	}
+}
+
+// jsonFieldValue decodes the string field, binary field as a byte array,
+// or the first value of the field logged several times.
+func jsonFieldValue(raw json.RawMessage) (string, bool) {
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		return str, true
+	}
+
+	var numbers []int
+	if err := json.Unmarshal(raw, &numbers); err == nil {
+		data := make([]byte, len(numbers))
+		for i, n := range numbers {
+			data[i] = byte(n)
+		}
+		return string(data), true
+	}
+
+	var values []json.RawMessage
+	if err := json.Unmarshal(raw, &values); err == nil && len(values) > 0 {
+		return jsonFieldValue(values[0])
+	}
+
+	return "", false
+}
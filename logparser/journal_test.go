@@ -0,0 +1,108 @@
+package logparser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJournalReader_Next(t *testing.T) {
+	want := []Record{
+		{Time: time.Unix(1578319645, 123456000), Host: "teamo", Program: "sshd", PID: 31215,
+			Message: "Accepted publickey for sheb from 188.163.50.118 port 11087 ssh2: RSA SHA256:dKBV5Ama80sfH1e3G03VQ92kfUtQvn67zh4ebLm7smw"},
+		{Time: time.Unix(1578319646, 0), Host: "teamo", Program: "systemd", PID: 1,
+			Message: "Started Session 42 of user sheb."},
+		{Time: time.Unix(1578319647, 0), Host: "teamo", Program: "sshd", PID: 31300,
+			Message: "Invalid user \x1b[31madmin from 45.95.168.10 port 41356"},
+		{Time: time.Unix(1578319648, 0), Host: "teamo", Program: "sshd", PID: 31301,
+			Message: "Failed password for root from 2001:db8::7 port 26493 ssh2"},
+	}
+	wantUnits := []string{"ssh.service", "init.scope", "ssh.service", "sshd.service"}
+
+	tests := []struct {
+		file   string
+		format JournalFormat
+	}{
+		{file: "testdata/sshd.export", format: JournalExport},
+		{file: "testdata/sshd.json", format: JournalJSON},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			file, err := os.Open(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+
+			reader, err := NewJournalReader(file, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for j := range want {
+				entry, err := reader.Next()
+				if err != nil {
+					t.Fatalf("Next() #%d error = %v", j, err)
+				}
+				got, err := entry.Record()
+				if err != nil {
+					t.Fatalf("Record() #%d error = %v", j, err)
+				}
+
+				assertField(t, got.Time.Equal(want[j].Time), true)
+				assertField(t, got.Host, want[j].Host)
+				assertField(t, got.Program, want[j].Program)
+				assertField(t, got.PID, want[j].PID)
+				assertField(t, got.Message, want[j].Message)
+				assertField(t, entry.Unit(), wantUnits[j])
+			}
+
+			if _, err := reader.Next(); err != io.EOF {
+				t.Errorf("Next() error = %v, want io.EOF", err)
+			}
+		})
+	}
+}
+
+func TestJournalReader_Next_binaryField(t *testing.T) {
+	field := func(size uint64, data string) string {
+		raw := make([]byte, 8)
+		binary.LittleEndian.PutUint64(raw, size)
+		return "__CURSOR=s=1\nMESSAGE\n" + string(raw) + data
+	}
+
+	tests := []struct {
+		stream  string
+		want    string
+		wantErr error
+	}{
+		{stream: field(5, "hello\n\n"), want: "hello"},
+		{stream: field(10, "hello\n"), wantErr: ErrInvalidJournal},
+		{stream: field(3, "hello\n"), wantErr: ErrInvalidJournal},
+		{stream: field(1<<40, "hello\n"), wantErr: ErrInvalidJournal},
+		{stream: field(1<<64-1, "hello\n"), wantErr: ErrInvalidJournal},
+		{stream: "__CURSOR=s=1\nMESSAGE\n\x05\x00", wantErr: ErrInvalidJournal},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			reader, err := NewJournalReader(strings.NewReader(tt.stream), JournalExport)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			entry, err := reader.Next()
+			if err != tt.wantErr {
+				t.Fatalf("Next() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && entry["MESSAGE"] != tt.want {
+				t.Errorf("Next() MESSAGE got = %q, want %q", entry["MESSAGE"], tt.want)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package logparser
 
 import (
 	"fmt"
+	"net"
 	"reflect"
 	"testing"
 
@@ -16,24 +17,121 @@ func TestParseLine(t *testing.T) {
 	}{
 		{
 			wantErr: false,
-			want:    &db.AuthInfo{Status: db.AuthAccepted, Username: "sheb", AuthMethod: "publickey", RemoteAddr: "188.163.50.118"},
+			want: &db.AuthInfo{Status: db.AuthAccepted, Username: "sheb", AuthMethod: "publickey", RemoteAddr: net.ParseIP("188.163.50.118"), Port: 11087, Protocol: "ssh2",
+				PublicKey: &db.PublicKey{Type: "RSA", Fingerprint: "SHA256:dKBV5Ama80sfH1e3G03VQ92kfUtQvn67zh4ebLm7smw"}},
 			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Accepted publickey for sheb from 188.163.50.118 port 11087 ssh2: RSA SHA256:dKBV5Ama80sfH1e3G03VQ92kfUtQvn67zh4ebLm7smw",
 		},
 		{
 			wantErr: false,
-			want:    &db.AuthInfo{Status: db.AuthDisconnected, Username: "sheb", AuthMethod: "", RemoteAddr: "188.163.50.118"},
+			want:    &db.AuthInfo{Status: db.AuthDisconnected, Username: "sheb", AuthMethod: "", RemoteAddr: net.ParseIP("188.163.50.118"), Port: 11323},
 			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Disconnected from user sheb 188.163.50.118 port 11323",
 		},
 		{
 			wantErr: false,
-			want:    &db.AuthInfo{Status: db.AuthFailed, Username: "yro", AuthMethod: "password", RemoteAddr: "213.91.179.246"},
+			want:    &db.AuthInfo{Status: db.AuthFailed, Username: "yro", AuthMethod: "password", RemoteAddr: net.ParseIP("213.91.179.246"), Port: 37353, Protocol: "ssh2"},
 			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Failed password for invalid user yro from 213.91.179.246 port 37353 ssh2",
 		},
 		{
 			wantErr: false,
-			want:    &db.AuthInfo{Status: db.AuthFailed, Username: "root", AuthMethod: "password", RemoteAddr: "218.92.0.164"},
+			want:    &db.AuthInfo{Status: db.AuthFailed, Username: "root", AuthMethod: "password", RemoteAddr: net.ParseIP("218.92.0.164"), Port: 26493, Protocol: "ssh2"},
 			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Failed password for root from 218.92.0.164 port 26493 ssh2",
 		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthInvalidUser, Username: "admin", RemoteAddr: net.ParseIP("45.95.168.10"), Port: 41356},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Invalid user admin from 45.95.168.10 port 41356",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthInvalidUser, Username: "", RemoteAddr: net.ParseIP("45.95.168.10"), Port: 41356},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Invalid user  from 45.95.168.10 port 41356",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthPreauthClosed, Username: "root", RemoteAddr: net.ParseIP("218.92.0.164"), Port: 26493},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Connection closed by authenticating user root 218.92.0.164 port 26493 [preauth]",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthPreauthClosed, Username: "", RemoteAddr: net.ParseIP("218.92.0.164"), Port: 26493},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Connection closed by 218.92.0.164 port 26493 [preauth]",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthPreauthClosed, Username: "admin", RemoteAddr: net.ParseIP("45.95.168.10"), Port: 41356},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Disconnected from invalid user admin 45.95.168.10 port 41356 [preauth]",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthPreauthClosed, Username: "", RemoteAddr: net.ParseIP("218.92.0.164"), Port: 26493},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Received disconnect from 218.92.0.164 port 26493:11:  [preauth]",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthReceivedDisconnect, Username: "", RemoteAddr: net.ParseIP("188.163.50.118"), Port: 11323},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Received disconnect from 188.163.50.118 port 11323:11: disconnected by user",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthMaxAttempts, Username: "root", RemoteAddr: net.ParseIP("218.92.0.164"), Port: 26493, Protocol: "ssh2"},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: error: maximum authentication attempts exceeded for root from 218.92.0.164 port 26493 ssh2 [preauth]",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthProtocolError, Username: "", RemoteAddr: net.ParseIP("45.95.168.10"), Port: 41356},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Did not receive identification string from 45.95.168.10 port 41356",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthProtocolError, Username: "", RemoteAddr: net.ParseIP("45.95.168.10"), Port: 41356},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: banner exchange: Connection from 45.95.168.10 port 41356: invalid format",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthSessionOpened, Username: "sheb", RemoteAddr: nil},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: pam_unix(sshd:session): session opened for user sheb by (uid=0)",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthSessionOpened, Username: "sheb", RemoteAddr: nil},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: pam_unix(sshd:session): session opened for user sheb(uid=1000) by (uid=0)",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthSessionClosed, Username: "sheb", RemoteAddr: nil},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: pam_unix(sshd:session): session closed for user sheb",
+		},
+		{
+			wantErr: false,
+			want: &db.AuthInfo{Status: db.AuthAccepted, Username: "sheb", AuthMethod: "publickey", RemoteAddr: net.ParseIP("2001:db8::1"), Port: 50022, Protocol: "ssh2",
+				PublicKey: &db.PublicKey{Type: "ED25519", Fingerprint: "SHA256:lJQ/VRJLu7Qmnu1ZYRZBSNaJEp/KJKgfVTxsILEA2EA"}},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Accepted publickey for sheb from 2001:db8::1 port 50022 ssh2: ED25519 SHA256:lJQ/VRJLu7Qmnu1ZYRZBSNaJEp/KJKgfVTxsILEA2EA",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthFailed, Username: "root", AuthMethod: "password", RemoteAddr: net.ParseIP("1.2.3.4"), Port: 3322, Protocol: "ssh2"},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Failed password for root from ::ffff:1.2.3.4 port 3322 ssh2",
+		},
+		{
+			wantErr: false,
+			want:    &db.AuthInfo{Status: db.AuthDisconnected, Username: "sheb", RemoteAddr: net.ParseIP("fe80::a00:27ff:fe4e:66a1"), Port: 50022},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Disconnected from user sheb fe80::a00:27ff:fe4e:66a1 port 50022",
+		},
+		{
+			wantErr: false,
+			want: &db.AuthInfo{Status: db.AuthAccepted, Username: "deploy", AuthMethod: "publickey", RemoteAddr: net.ParseIP("10.1.0.7"), Port: 40110, Protocol: "ssh2",
+				PublicKey: &db.PublicKey{Type: "ED25519-CERT", Fingerprint: "SHA256:Xk4bA0QfbX6m5qyyYfTwS3nM2Y7vB1pQx0s9sJbqf3U",
+					CertID: "alice@corp vault", CertSerial: 42, CAType: "ED25519", CAFingerprint: "SHA256:q0zK1P0sN7Z3fGz4dM8cWq2u9RzQfA1bYxT5vL6kE0o"}},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Accepted publickey for deploy from 10.1.0.7 port 40110 ssh2: ED25519-CERT SHA256:Xk4bA0QfbX6m5qyyYfTwS3nM2Y7vB1pQx0s9sJbqf3U ID alice@corp vault (serial 42) CA ED25519 SHA256:q0zK1P0sN7Z3fGz4dM8cWq2u9RzQfA1bYxT5vL6kE0o",
+		},
+		{
+			wantErr: true,
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Accepted publickey for sheb from 1.2.3.4.5 port 50022 ssh2",
+		},
+		{
+			wantErr: true,
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Server listening on 0.0.0.0 port 22.",
+		},
 		{
 			wantErr: true,
 			logLine: "Jan  6 14:08:21 teamo sudo: pam_unix(sudo:session): session closed for user root",
@@ -54,6 +152,9 @@ func TestParseLine(t *testing.T) {
 			assertField(t, got.RemoteAddr, tt.want.RemoteAddr)
 			assertField(t, got.Username, tt.want.Username)
 			assertField(t, got.Status, tt.want.Status)
+			assertField(t, got.Port, tt.want.Port)
+			assertField(t, got.Protocol, tt.want.Protocol)
+			assertField(t, got.PublicKey, tt.want.PublicKey)
 		})
 	}
 }
@@ -0,0 +1,159 @@
+package logparser
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+type EventType string
+
+const (
+	EventAuth EventType = "auth"
+	// EventEscalation is the sudo or su attempt.
+	EventEscalation EventType = "escalation"
+)
+
+// Event is a typed result of parsing a log record.
+type Event struct {
+	Type   EventType
+	Parser string
+	Host   string
+	Auth   *db.AuthInfo
+	// Escalation is set for the EventEscalation.
+	Escalation *db.Escalation
+
+	// Source is the name of the source the event is read from.
+	Source string
+	Labels map[string]string
+	// Position is set by the file sources to the position after the parsed line.
+	Position *db.FilePosition
+}
+
+// Parser converts the records of one log source into events.
+// Implementations must compile their expressions once, in the constructor.
+type Parser interface {
+	// Name returns a unique name used for registration and configuration.
+	Name() string
+	// Match reports whether the parser handles messages of the program.
+	Match(program string) bool
+	// Parse converts the record into an event.
+	Parse(rec Record) (*Event, error)
+}
+
+// Registry holds an ordered set of parsers and dispatches records to them.
+type Registry struct {
+	mu      sync.RWMutex
+	parsers []Parser
+	byName  map[string]Parser
+}
+
+func NewRegistry(parsers ...Parser) (*Registry, error) {
+	r := &Registry{byName: map[string]Parser{}}
+	for _, p := range parsers {
+		if err := r.Register(p); err != nil {
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Registry) Register(p Parser) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.byName[p.Name()]; ok {
+		return fmt.Errorf("parser %q already registered", p.Name())
+	}
+
+	r.byName[p.Name()] = p
+	r.parsers = append(r.parsers, p)
+	return nil
+}
+
+func (r *Registry) Get(name string) (Parser, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.byName[name]
+	return p, ok
+}
+
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	names := make([]string, 0, len(r.parsers))
+	for _, p := range r.parsers {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// Subset returns a new registry with only the named parsers.
+func (r *Registry) Subset(names ...string) (*Registry, error) {
+	parsers := make([]Parser, 0, len(names))
+	for _, name := range names {
+		p, ok := r.Get(name)
+		if !ok {
+			return nil, fmt.Errorf("parser %q is not registered", name)
+		}
+		parsers = append(parsers, p)
+	}
+
+	return NewRegistry(parsers...)
+}
+
+// Parse splits the syslog header of the logLine and passes the record to the parsers.
+func (r *Registry) Parse(logLine string) (*Event, error) {
+	rec, err := SplitLine(logLine)
+	if err != nil {
+		return nil, err
+	}
+
+	return r.ParseRecord(rec)
+}
+
+// ParseRecord returns the event of the first parser that accepts the record.
+func (r *Registry) ParseRecord(rec Record) (*Event, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	err := ErrUnknownSource
+	for _, p := range r.parsers {
+		if !p.Match(rec.Program) {
+			continue
+		}
+
+		var event *Event
+		event, err = p.Parse(rec)
+		if err != nil {
+			continue
+		}
+
+		event.Parser = p.Name()
+		if event.Host == "" {
+			event.Host = rec.Host
+		}
+		return event, nil
+	}
+
+	return nil, err
+}
+
+var defaultRegistry, _ = NewRegistry()
+
+// Register adds the parser to the default registry.
+// It panics if a parser with the same name is already registered.
+func Register(p Parser) {
+	if err := defaultRegistry.Register(p); err != nil {
+		panic(err)
+	}
+}
+
+// Default returns the registry with all built-in parsers.
+func Default() *Registry {
+	return defaultRegistry
+}
@@ -0,0 +1,81 @@
+package logparser
+
+import (
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+func TestRegistry_Parse(t *testing.T) {
+	tests := []struct {
+		logLine string
+		want    *Event
+		wantErr error
+	}{
+		{
+			want: &Event{Type: EventAuth, Parser: ParserSSHd, Host: "teamo",
+				Auth: &db.AuthInfo{Status: db.AuthAccepted, Username: "sheb", AuthMethod: "publickey", RemoteAddr: net.ParseIP("188.163.50.118"), PID: 31215}},
+			logLine: "Jan  6 14:07:25 teamo sshd[31215]: Accepted publickey for sheb from 188.163.50.118 port 11087 ssh2",
+		},
+		{
+			wantErr: ErrUnknownSource,
+			logLine: "Jan  6 14:08:21 teamo CRON[2]: pam_unix(cron:session): session closed for user root",
+		},
+		{
+			wantErr: ErrUnSupportedStatus,
+			logLine: "Jan  6 14:08:21 teamo sudo: pam_unix(sudo:session): session closed for user root",
+		},
+		{
+			wantErr: ErrUnSupportedStatus,
+			logLine: "Jan  6 14:08:21 teamo sshd[31215]: Server listening on 0.0.0.0 port 22.",
+		},
+		{
+			wantErr: ErrInvalidLine,
+			logLine: "random garbage",
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			got, err := Default().Parse(tt.logLine)
+			if err != tt.wantErr {
+				t.Errorf("Parse() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			assertField(t, got.Type, tt.want.Type)
+			assertField(t, got.Parser, tt.want.Parser)
+			assertField(t, got.Host, tt.want.Host)
+			assertField(t, got.Auth.Status, tt.want.Auth.Status)
+			assertField(t, got.Auth.Username, tt.want.Auth.Username)
+			assertField(t, got.Auth.AuthMethod, tt.want.Auth.AuthMethod)
+			assertField(t, got.Auth.RemoteAddr, tt.want.Auth.RemoteAddr)
+			assertField(t, got.Auth.PID, tt.want.Auth.PID)
+		})
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	r, err := NewRegistry(NewSSHdParser())
+	if err != nil {
+		t.Fatalf("NewRegistry() error = %v", err)
+	}
+
+	if err := r.Register(NewSSHdParser()); err == nil {
+		t.Errorf("Register() duplicate parser must fail")
+	}
+
+	if _, err := r.Subset("unknown"); err == nil {
+		t.Errorf("Subset() with unknown parser must fail")
+	}
+
+	sub, err := r.Subset(ParserSSHd)
+	if err != nil {
+		t.Fatalf("Subset() error = %v", err)
+	}
+	assertField(t, sub.Names(), []string{ParserSSHd})
+}
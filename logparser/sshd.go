@@ -0,0 +1,121 @@
+package logparser
+
+import (
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+const ParserSSHd = "sshd"
+
+func init() {
+	Register(NewSSHdParser())
+}
+
+const (
+	addrPattern = `[\da-fA-F\.:]+`
+	portPattern = ` port (?P<port>\d+)`
+	keyPattern  = `(?:: (?P<keytype>[\w-]+) (?P<fp>\S+)` +
+		`(?: ID (?P<certid>.*?) \(serial (?P<serial>\d+)\) CA (?P<catype>[\w-]+) (?P<cafp>\S+))?)?`
+)
+
+// sshdRule maps a message pattern to the auth status.
+// Pattern fields are taken from the named groups: `method`, `user`, `addr`, `port`, `proto`
+// and the public key groups: `keytype`, `fp`, `certid`, `serial`, `catype`, `cafp`.
+type sshdRule struct {
+	status db.AuthStatus
+	reg    *regexp.Regexp
+}
+
+type sshdParser struct {
+	rules []sshdRule
+}
+
+func NewSSHdParser() Parser {
+	rule := func(status db.AuthStatus, pattern string) sshdRule {
+		pattern = strings.Replace(pattern, "ADDR", addrPattern, -1)
+		pattern = strings.Replace(pattern, " PORT", portPattern, -1)
+		pattern = strings.Replace(pattern, "KEY", keyPattern, -1)
+		return sshdRule{status: status, reg: regexp.MustCompile(pattern)}
+	}
+
+	return &sshdParser{rules: []sshdRule{
+		rule(db.AuthAccepted, `^Accepted (?P<method>[\w-]+) for (?P<user>\S+) from (?P<addr>ADDR) PORT (?P<proto>ssh\d)KEY`),
+		rule(db.AuthFailed, `^Failed (?P<method>[\w-]+) for (?:invalid user )?(?P<user>\S+) from (?P<addr>ADDR) PORT (?P<proto>ssh\d)KEY`),
+		rule(db.AuthDisconnected, `^Disconnected from user (?P<user>\S+) (?P<addr>ADDR) PORT`),
+
+		rule(db.AuthInvalidUser, `^Invalid user (?P<user>\S*) ?from (?P<addr>ADDR)(?: PORT)?`),
+		rule(db.AuthMaxAttempts, `^error: maximum authentication attempts exceeded for (?:invalid user )?(?P<user>\S+) from (?P<addr>ADDR) PORT (?P<proto>ssh\d)`),
+		rule(db.AuthPreauthClosed, `^(?:Connection closed by|Disconnected from) (?:(?:authenticating|invalid) user (?P<user>\S*) )?(?P<addr>ADDR) PORT.*\[preauth\]$`),
+		rule(db.AuthPreauthClosed, `^Received disconnect from (?P<addr>ADDR) PORT:.*\[preauth\]$`),
+		rule(db.AuthReceivedDisconnect, `^Received disconnect from (?P<addr>ADDR) PORT:`),
+
+		rule(db.AuthProtocolError, `^Did not receive identification string from (?P<addr>ADDR)(?: PORT)?`),
+		rule(db.AuthProtocolError, `^banner exchange: Connection from (?P<addr>ADDR) PORT:`),
+		rule(db.AuthProtocolError, `^Bad protocol version identification .* from (?P<addr>ADDR)(?: PORT)?`),
+		rule(db.AuthProtocolError, `^Unable to negotiate with (?P<addr>ADDR) PORT:`),
+
+		rule(db.AuthSessionOpened, `^pam_unix\(sshd:session\): session opened for user (?P<user>[^\s(]+)`),
+		rule(db.AuthSessionClosed, `^pam_unix\(sshd:session\): session closed for user (?P<user>\S+)`),
+	}}
+}
+
+func (p *sshdParser) Name() string {
+	return ParserSSHd
+}
+
+func (p *sshdParser) Match(program string) bool {
+	return program == "sshd"
+}
+
+func (p *sshdParser) Parse(rec Record) (*Event, error) {
+	for _, rule := range p.rules {
+		matches := rule.reg.FindStringSubmatch(rec.Message)
+		if matches == nil {
+			continue
+		}
+
+		authInfo := &db.AuthInfo{Status: rule.status, Date: rec.Time, PID: rec.PID}
+		key := db.PublicKey{}
+		for i, name := range rule.reg.SubexpNames() {
+			switch name {
+			case "method":
+				authInfo.AuthMethod = matches[i]
+			case "user":
+				authInfo.Username = matches[i]
+			case "addr":
+				authInfo.RemoteAddr = net.ParseIP(matches[i])
+				if authInfo.RemoteAddr == nil {
+					return nil, ErrInvalidLine
+				}
+			case "port":
+				authInfo.Port, _ = strconv.Atoi(matches[i])
+			case "proto":
+				authInfo.Protocol = matches[i]
+			case "keytype":
+				key.Type = matches[i]
+			case "fp":
+				key.Fingerprint = matches[i]
+			case "certid":
+				key.CertID = matches[i]
+			case "serial":
+				key.CertSerial, _ = strconv.ParseUint(matches[i], 10, 64)
+			case "catype":
+				key.CAType = matches[i]
+			case "cafp":
+				key.CAFingerprint = matches[i]
+			}
+		}
+
+		if key.Fingerprint != "" {
+			authInfo.PublicKey = &key
+		}
+
+		return &Event{Type: EventAuth, Host: rec.Host, Auth: authInfo}, nil
+	}
+
+	return nil, ErrUnSupportedStatus
+}
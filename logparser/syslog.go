@@ -0,0 +1,137 @@
+package logparser
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Record is a single log message split into the syslog header and the message body.
+type Record struct {
+	Time    time.Time
+	Host    string
+	Program string
+	PID     int
+	Message string
+}
+
+var (
+	// [<38>]Jan  6 14:07:25 host sshd[31215]: message
+	stampHeaderReg = regexp.MustCompile(
+		`^(?:<\d{1,3}>)?(\w{3}\s+\d{1,2}\s\d{2}:\d{2}:\d{2})\s(\S+)\s([^\s\[:]+)(?:\[(\d+)\])?:\s?(.*)$`)
+	// [<38>]2020-01-06T14:07:25.123456+02:00 host sshd[31215]: message
+	rfc3339HeaderReg = regexp.MustCompile(
+		`^(?:<\d{1,3}>)?(\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}(?:\.\d+)?(?:Z|[+-]\d{2}:\d{2}))\s(\S+)\s([^\s\[:]+)(?:\[(\d+)\])?:\s?(.*)$`)
+	// <38>1 2020-01-06T14:07:25.003Z host sshd 31215 - - message
+	rfc5424HeaderReg = regexp.MustCompile(
+		`^<\d{1,3}>1 (\S+) (\S+) (\S+) (\S+) \S+ (?:-|(?:\[(?:[^\]\\]|\\.)*\])+) ?(.*)$`)
+)
+
+// maxFutureSkew is how far ahead of the reference time a timestamp without a year may be.
+const maxFutureSkew = 24 * time.Hour
+
+// HeaderParser splits syslog lines into records.
+type HeaderParser struct {
+	location  *time.Location
+	reference func() time.Time
+}
+
+// NewHeaderParser returns the parser which reads timestamps without zone in the location
+// and infers the missing year so that the timestamp is not after the reference time.
+// The reference is time.Now for the tailed logs, or the modification time for the rotated files.
+func NewHeaderParser(location *time.Location, reference func() time.Time) *HeaderParser {
+	if location == nil {
+		location = time.Local
+	}
+	if reference == nil {
+		reference = time.Now
+	}
+
+	return &HeaderParser{location: location, reference: reference}
+}
+
+var defaultHeaderParser = NewHeaderParser(nil, nil)
+
+// SplitLine parses the syslog header of the logLine in the local time zone.
+func SplitLine(logLine string) (Record, error) {
+	return defaultHeaderParser.Split(logLine)
+}
+
+// Split parses the traditional, RFC3339 or RFC5424 syslog header of the logLine.
+func (p *HeaderParser) Split(logLine string) (Record, error) {
+	if matches := stampHeaderReg.FindStringSubmatch(logLine); matches != nil {
+		timeStamp, err := time.ParseInLocation(time.Stamp, matches[1], p.location)
+		if err != nil {
+			return Record{}, err
+		}
+
+		return newRecord(p.withYear(timeStamp), matches[2], matches[3], matches[4], matches[5]), nil
+	}
+
+	if matches := rfc3339HeaderReg.FindStringSubmatch(logLine); matches != nil {
+		timeStamp, err := time.Parse(time.RFC3339Nano, matches[1])
+		if err != nil {
+			return Record{}, err
+		}
+
+		return newRecord(timeStamp, matches[2], matches[3], matches[4], matches[5]), nil
+	}
+
+	if matches := rfc5424HeaderReg.FindStringSubmatch(logLine); matches != nil {
+		timeStamp := p.reference()
+		if matches[1] != "-" {
+			var err error
+			timeStamp, err = time.Parse(time.RFC3339Nano, matches[1])
+			if err != nil {
+				return Record{}, err
+			}
+		}
+
+		msg := strings.TrimPrefix(matches[5], "\xEF\xBB\xBF")
+		return newRecord(timeStamp, nilValue(matches[2]), nilValue(matches[3]), nilValue(matches[4]), msg), nil
+	}
+
+	return Record{}, ErrInvalidLine
+}
+
+// withYear sets the latest year for which the timestamp is not in the future.
+func (p *HeaderParser) withYear(stamp time.Time) time.Time {
+	ref := p.reference().In(p.location)
+	limit := ref.Add(maxFutureSkew)
+
+	// Feb 29 exists only in leap years, so a few years may be skipped
+	for year := ref.Year() + 1; year > ref.Year()-8; year-- {
+		t := time.Date(year, stamp.Month(), stamp.Day(),
+			stamp.Hour(), stamp.Minute(), stamp.Second(), 0, p.location)
+		if t.Day() != stamp.Day() || t.After(limit) {
+			continue
+		}
+		return t
+	}
+
+	return stamp.AddDate(ref.Year(), 0, 0)
+}
+
+func newRecord(timeStamp time.Time, host, program, pid, msg string) Record {
+	rec := Record{
+		Time:    timeStamp,
+		Host:    host,
+		Program: program,
+		Message: msg,
+	}
+
+	if pid != "" {
+		rec.PID, _ = strconv.Atoi(pid)
+	}
+
+	return rec
+}
+
+// nilValue replaces the RFC5424 NILVALUE with the empty string.
+func nilValue(field string) string {
+	if field == "-" {
+		return ""
+	}
+	return field
+}
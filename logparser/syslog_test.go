@@ -0,0 +1,115 @@
+package logparser
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestHeaderParser_Split(t *testing.T) {
+	kiev, err := time.LoadLocation("Europe/Kiev")
+	if err != nil {
+		t.Skip("no tzdata:", err)
+	}
+	at := func(ref time.Time) func() time.Time {
+		return func() time.Time { return ref }
+	}
+
+	tests := []struct {
+		location  *time.Location
+		reference time.Time
+		logLine   string
+		want      Record
+		wantErr   bool
+	}{
+		{
+			location:  time.UTC,
+			reference: time.Date(2020, 1, 6, 15, 0, 0, 0, time.UTC),
+			logLine:   "Jan  6 14:07:25 teamo sshd[31215]: Accepted publickey for sheb",
+			want: Record{Time: time.Date(2020, 1, 6, 14, 7, 25, 0, time.UTC),
+				Host: "teamo", Program: "sshd", PID: 31215, Message: "Accepted publickey for sheb"},
+		},
+		{
+			location:  time.UTC,
+			reference: time.Date(2020, 1, 1, 0, 10, 0, 0, time.UTC),
+			logLine:   "Dec 31 23:59:59 teamo sshd[31215]: Accepted publickey for sheb",
+			want: Record{Time: time.Date(2019, 12, 31, 23, 59, 59, 0, time.UTC),
+				Host: "teamo", Program: "sshd", PID: 31215, Message: "Accepted publickey for sheb"},
+		},
+		{
+			location:  time.UTC,
+			reference: time.Date(2020, 12, 31, 23, 0, 0, 0, time.UTC),
+			logLine:   "Jan  1 00:05:00 teamo sshd[31215]: Accepted publickey for sheb",
+			want: Record{Time: time.Date(2021, 1, 1, 0, 5, 0, 0, time.UTC),
+				Host: "teamo", Program: "sshd", PID: 31215, Message: "Accepted publickey for sheb"},
+		},
+		{
+			location:  time.UTC,
+			reference: time.Date(2021, 3, 1, 0, 0, 0, 0, time.UTC),
+			logLine:   "Feb 29 10:00:00 teamo sshd[31215]: Accepted publickey for sheb",
+			want: Record{Time: time.Date(2020, 2, 29, 10, 0, 0, 0, time.UTC),
+				Host: "teamo", Program: "sshd", PID: 31215, Message: "Accepted publickey for sheb"},
+		},
+		{
+			location:  kiev,
+			reference: time.Date(2020, 6, 6, 15, 0, 0, 0, time.UTC),
+			logLine:   "Jun  6 14:07:25 teamo sudo: pam_unix(sudo:session): session closed for user root",
+			want: Record{Time: time.Date(2020, 6, 6, 11, 7, 25, 0, time.UTC),
+				Host: "teamo", Program: "sudo", Message: "pam_unix(sudo:session): session closed for user root"},
+		},
+		{
+			location:  time.UTC,
+			reference: time.Date(2020, 6, 6, 15, 0, 0, 0, time.UTC),
+			logLine:   "2020-01-06T14:07:25.123456+02:00 teamo sshd[31215]: Accepted publickey for sheb",
+			want: Record{Time: time.Date(2020, 1, 6, 12, 7, 25, 123456000, time.UTC),
+				Host: "teamo", Program: "sshd", PID: 31215, Message: "Accepted publickey for sheb"},
+		},
+		{
+			location:  time.UTC,
+			reference: time.Date(2020, 6, 6, 15, 0, 0, 0, time.UTC),
+			logLine:   `<38>1 2020-01-06T14:07:25.003Z teamo sshd 31215 - [meta sequenceId="1" note="a\]b"] Accepted publickey for sheb`,
+			want: Record{Time: time.Date(2020, 1, 6, 14, 7, 25, 3000000, time.UTC),
+				Host: "teamo", Program: "sshd", PID: 31215, Message: "Accepted publickey for sheb"},
+		},
+		{
+			location:  time.UTC,
+			reference: time.Date(2020, 6, 6, 15, 0, 0, 0, time.UTC),
+			logLine:   "<38>1 - - sshd - - - \xEF\xBB\xBFAccepted publickey for sheb",
+			want: Record{Time: time.Date(2020, 6, 6, 15, 0, 0, 0, time.UTC),
+				Program: "sshd", Message: "Accepted publickey for sheb"},
+		},
+		{
+			location:  time.UTC,
+			reference: time.Date(2020, 6, 6, 15, 0, 0, 0, time.UTC),
+			logLine:   "<38>Jun  6 14:07:25 teamo sshd[31215]: Accepted publickey for sheb",
+			want: Record{Time: time.Date(2020, 6, 6, 14, 7, 25, 0, time.UTC),
+				Host: "teamo", Program: "sshd", PID: 31215, Message: "Accepted publickey for sheb"},
+		},
+		{
+			location:  time.UTC,
+			reference: time.Date(2020, 6, 6, 15, 0, 0, 0, time.UTC),
+			logLine:   "06/01/2020 14:07:25 teamo sshd[31215]: Accepted publickey for sheb",
+			wantErr:   true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			got, err := NewHeaderParser(tt.location, at(tt.reference)).Split(tt.logLine)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Split() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if !got.Time.Equal(tt.want.Time) {
+				t.Errorf("Split() time = %v, want %v", got.Time, tt.want.Time)
+			}
+			assertField(t, got.Host, tt.want.Host)
+			assertField(t, got.Program, tt.want.Program)
+			assertField(t, got.PID, tt.want.PID)
+			assertField(t, got.Message, tt.want.Message)
+		})
+	}
+}
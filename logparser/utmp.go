@@ -0,0 +1,252 @@
+package logparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+var ErrInvalidUtmp = errors.New("invalid utmp record")
+
+// UtmpKind tells the login records of wtmp from the bad login records of btmp.
+type UtmpKind string
+
+const (
+	UtmpWtmp UtmpKind = "wtmp"
+	UtmpBtmp UtmpKind = "btmp"
+)
+
+// ParserUtmp is the event parser name of the utmp records.
+const ParserUtmp = "utmp"
+
+// ut_type values of the Linux utmp.h.
+const (
+	UtmpLoginProcess int16 = 6
+	UtmpUserProcess  int16 = 7
+	UtmpDeadProcess  int16 = 8
+)
+
+const (
+	// UtmpRecordSize is the size of the Linux struct utmp with the 32-bit ut_tv.
+	UtmpRecordSize = 384
+	// LastlogRecordSize is the size of the Linux struct lastlog.
+	LastlogRecordSize = 292
+
+	utLineSize = 32
+	utIDSize   = 4
+	utUserSize = 32
+	utHostSize = 256
+)
+
+// UtmpRecord is the decoded struct utmp of wtmp or btmp.
+type UtmpRecord struct {
+	Type    int16
+	PID     int32
+	Line    string
+	ID      string
+	User    string
+	Host    string
+	Session int32
+	Time    time.Time
+	Addr    net.IP
+}
+
+// rawUtmp is the Linux struct utmp layout.
+type rawUtmp struct {
+	Type    int16
+	_       int16
+	PID     int32
+	Line    [utLineSize]byte
+	ID      [utIDSize]byte
+	User    [utUserSize]byte
+	Host    [utHostSize]byte
+	Exit    [2]int16
+	Session int32
+	Sec     int32
+	Usec    int32
+	Addr    [16]byte
+	_       [20]byte
+}
+
+// DecodeUtmp decodes one little-endian utmp record.
+func DecodeUtmp(buf []byte) (UtmpRecord, error) {
+	if len(buf) != UtmpRecordSize {
+		return UtmpRecord{}, ErrInvalidUtmp
+	}
+
+	raw := rawUtmp{}
+	if err := binary.Read(bytes.NewReader(buf), binary.LittleEndian, &raw); err != nil {
+		return UtmpRecord{}, err
+	}
+
+	return UtmpRecord{
+		Type:    raw.Type,
+		PID:     raw.PID,
+		Line:    cString(raw.Line[:]),
+		ID:      cString(raw.ID[:]),
+		User:    cString(raw.User[:]),
+		Host:    cString(raw.Host[:]),
+		Session: raw.Session,
+		Time:    time.Unix(int64(raw.Sec), int64(raw.Usec)*int64(time.Microsecond)),
+		Addr:    utmpAddr(raw.Addr, cString(raw.Host[:])),
+	}, nil
+}
+
+// EncodeUtmp is the reverse of DecodeUtmp.
+func EncodeUtmp(rec UtmpRecord) []byte {
+	raw := rawUtmp{
+		Type:    rec.Type,
+		PID:     rec.PID,
+		Session: rec.Session,
+		Sec:     int32(rec.Time.Unix()),
+		Usec:    int32(rec.Time.Nanosecond() / int(time.Microsecond)),
+	}
+	copy(raw.Line[:], rec.Line)
+	copy(raw.ID[:], rec.ID)
+	copy(raw.User[:], rec.User)
+	copy(raw.Host[:], rec.Host)
+	if ip4 := rec.Addr.To4(); ip4 != nil {
+		copy(raw.Addr[:], ip4)
+	} else {
+		copy(raw.Addr[:], rec.Addr.To16())
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, UtmpRecordSize))
+	_ = binary.Write(buf, binary.LittleEndian, &raw)
+	return buf.Bytes()
+}
+
+// utmpAddr returns ut_addr_v6, which holds IPv4 in the first word only,
+// or the host when it is an address.
+func utmpAddr(addr [16]byte, host string) net.IP {
+	switch {
+	case bytes.Equal(addr[4:], make([]byte, 12)) && !bytes.Equal(addr[:4], make([]byte, 4)):
+		return net.IPv4(addr[0], addr[1], addr[2], addr[3])
+	case !bytes.Equal(addr[:], make([]byte, 16)):
+		return net.IP(append([]byte(nil), addr[:]...))
+	}
+
+	// the host may be "addr:display" for X sessions
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	if i := strings.LastIndexByte(host, ':'); i > 0 {
+		return net.ParseIP(host[:i])
+	}
+	return nil
+}
+
+func cString(buf []byte) string {
+	if i := bytes.IndexByte(buf, 0); i >= 0 {
+		buf = buf[:i]
+	}
+	return string(buf)
+}
+
+// UtmpReader reads the records of the wtmp or btmp file.
+type UtmpReader struct {
+	reader io.Reader
+	buf    []byte
+}
+
+func NewUtmpReader(r io.Reader) *UtmpReader {
+	return &UtmpReader{reader: r, buf: make([]byte, UtmpRecordSize)}
+}
+
+// Next returns the next record, io.EOF at the end and io.ErrUnexpectedEOF if the last record is partial.
+func (r *UtmpReader) Next() (UtmpRecord, error) {
+	if _, err := io.ReadFull(r.reader, r.buf); err != nil {
+		return UtmpRecord{}, err
+	}
+	return DecodeUtmp(r.buf)
+}
+
+// UtmpSessions turns utmp records into auth events. The logout records of wtmp
+// carry only the line, so the open sessions are tracked by the line.
+type UtmpSessions struct {
+	kind UtmpKind
+	open map[string]UtmpRecord
+}
+
+func NewUtmpSessions(kind UtmpKind) *UtmpSessions {
+	return &UtmpSessions{kind: kind, open: map[string]UtmpRecord{}}
+}
+
+// Event returns the login, logout or bad login event of the record.
+// Records without the remote address, like console logins or reboots, return ErrUnSupportedStatus.
+func (s *UtmpSessions) Event(rec UtmpRecord) (*Event, error) {
+	var status db.AuthStatus
+	switch {
+	case s.kind == UtmpBtmp && (rec.Type == UtmpLoginProcess || rec.Type == UtmpUserProcess):
+		status = db.AuthFailed
+	case s.kind == UtmpWtmp && rec.Type == UtmpUserProcess:
+		status = db.AuthSessionOpened
+		s.open[rec.Line] = rec
+	case s.kind == UtmpWtmp && rec.Type == UtmpDeadProcess:
+		login, ok := s.open[rec.Line]
+		if !ok {
+			return nil, ErrUnSupportedStatus
+		}
+		delete(s.open, rec.Line)
+
+		status = db.AuthSessionClosed
+		rec.User, rec.Host, rec.Addr = login.User, login.Host, login.Addr
+	default:
+		return nil, ErrUnSupportedStatus
+	}
+
+	if len(rec.Addr) == 0 {
+		return nil, ErrUnSupportedStatus
+	}
+
+	return &Event{
+		Type:   EventAuth,
+		Parser: ParserUtmp,
+		Auth: &db.AuthInfo{
+			Status:     status,
+			Username:   rec.User,
+			RemoteAddr: rec.Addr,
+			TTY:        rec.Line,
+			Date:       rec.Time,
+		},
+	}, nil
+}
+
+// LastlogRecord is the last login of the user, the lastlog file is indexed by the uid.
+type LastlogRecord struct {
+	UID  uint32
+	Time time.Time
+	Line string
+	Host string
+}
+
+// ReadLastlog returns the last login of the uid, nil if the user has never logged in.
+// The file is sparse and may be huge, so only the record of the uid is read.
+func ReadLastlog(r io.ReaderAt, uid uint32) (*LastlogRecord, error) {
+	buf := make([]byte, LastlogRecordSize)
+	_, err := r.ReadAt(buf, int64(uid)*LastlogRecordSize)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sec := binary.LittleEndian.Uint32(buf[:4])
+	if sec == 0 {
+		return nil, nil
+	}
+
+	return &LastlogRecord{
+		UID:  uid,
+		Time: time.Unix(int64(sec), 0),
+		Line: cString(buf[4 : 4+utLineSize]),
+		Host: cString(buf[4+utLineSize:]),
+	}, nil
+}
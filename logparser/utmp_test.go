@@ -0,0 +1,193 @@
+package logparser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+func TestDecodeUtmp(t *testing.T) {
+	tests := []UtmpRecord{
+		{Type: UtmpUserProcess, PID: 31215, Line: "pts/0", ID: "ts/0", User: "sheb",
+			Host: "188.163.50.118", Session: 31215, Time: time.Unix(1578319645, 3000),
+			Addr: net.ParseIP("188.163.50.118")},
+		{Type: UtmpUserProcess, PID: 1, Line: "pts/1", ID: "ts/1", User: "root",
+			Host: "2001:db8::1", Time: time.Unix(1578319645, 0), Addr: net.ParseIP("2001:db8::1")},
+		{Type: UtmpDeadProcess, PID: 31215, Line: "pts/0", ID: "ts/0", Time: time.Unix(1578319700, 0)},
+	}
+
+	for i, want := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			buf := EncodeUtmp(want)
+			if len(buf) != UtmpRecordSize {
+				t.Fatalf("EncodeUtmp() size = %d, want %d", len(buf), UtmpRecordSize)
+			}
+
+			got, err := DecodeUtmp(buf)
+			if err != nil {
+				t.Fatalf("DecodeUtmp() error = %v", err)
+			}
+
+			assertField(t, got.Type, want.Type)
+			assertField(t, got.PID, want.PID)
+			assertField(t, got.Line, want.Line)
+			assertField(t, got.ID, want.ID)
+			assertField(t, got.User, want.User)
+			assertField(t, got.Host, want.Host)
+			assertField(t, got.Session, want.Session)
+			assertField(t, got.Time.Equal(want.Time), true)
+			if !got.Addr.Equal(want.Addr) {
+				t.Errorf("Addr got = %v, want %v", got.Addr, want.Addr)
+			}
+		})
+	}
+
+	if _, err := DecodeUtmp(make([]byte, 10)); err != ErrInvalidUtmp {
+		t.Errorf("DecodeUtmp() error = %v, want %v", err, ErrInvalidUtmp)
+	}
+}
+
+func TestUtmpAddr(t *testing.T) {
+	tests := []struct {
+		host string
+		want net.IP
+	}{
+		{host: "10.0.0.1", want: net.ParseIP("10.0.0.1")},
+		{host: "10.0.0.1:0", want: net.ParseIP("10.0.0.1")},
+		{host: "gateway.local"},
+		{host: ""},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			if got := utmpAddr([16]byte{}, tt.host); !got.Equal(tt.want) {
+				t.Errorf("utmpAddr() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUtmpSessions_Event(t *testing.T) {
+	login := time.Unix(1578319645, 0)
+	addr := net.ParseIP("188.163.50.118")
+
+	tests := []struct {
+		kind    UtmpKind
+		records []UtmpRecord
+		want    []db.AuthStatus
+	}{
+		{
+			kind: UtmpWtmp,
+			records: []UtmpRecord{
+				{Type: UtmpUserProcess, Line: "pts/0", User: "sheb", Host: addr.String(), Time: login, Addr: addr},
+				// console login
+				{Type: UtmpUserProcess, Line: "tty1", User: "root", Time: login},
+				{Type: UtmpDeadProcess, Line: "tty1", Time: login},
+				{Type: UtmpDeadProcess, Line: "pts/0", Time: login.Add(time.Minute)},
+				// logout of the login before the start
+				{Type: UtmpDeadProcess, Line: "pts/1", Time: login.Add(time.Minute)},
+			},
+			want: []db.AuthStatus{db.AuthSessionOpened, "", "", db.AuthSessionClosed, ""},
+		},
+		{
+			kind: UtmpBtmp,
+			records: []UtmpRecord{
+				{Type: UtmpLoginProcess, Line: "ssh:notty", User: "admin", Host: "218.92.0.164",
+					Time: login, Addr: net.ParseIP("218.92.0.164")},
+				{Type: UtmpUserProcess, Line: "ssh:notty", User: "root", Host: "218.92.0.164",
+					Time: login, Addr: net.ParseIP("218.92.0.164")},
+			},
+			want: []db.AuthStatus{db.AuthFailed, db.AuthFailed},
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			sessions := NewUtmpSessions(tt.kind)
+			var opened UtmpRecord
+			for j, rec := range tt.records {
+				event, err := sessions.Event(rec)
+				if tt.want[j] == "" {
+					if err != ErrUnSupportedStatus {
+						t.Errorf("record #%d error = %v, want %v", j, err, ErrUnSupportedStatus)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("record #%d error = %v", j, err)
+				}
+
+				if rec.Type != UtmpDeadProcess {
+					opened = rec
+				}
+				assertField(t, event.Parser, ParserUtmp)
+				assertField(t, event.Auth.Status, tt.want[j])
+				assertField(t, event.Auth.Username, opened.User)
+				assertField(t, event.Auth.TTY, rec.Line)
+				assertField(t, event.Auth.Date, rec.Time)
+				if !event.Auth.RemoteAddr.Equal(opened.Addr) {
+					t.Errorf("RemoteAddr got = %v, want %v", event.Auth.RemoteAddr, opened.Addr)
+				}
+			}
+		})
+	}
+}
+
+func TestUtmpReader_Next(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(EncodeUtmp(UtmpRecord{Type: UtmpUserProcess, User: "sheb"}))
+	buf.Write(EncodeUtmp(UtmpRecord{Type: UtmpDeadProcess})[:100])
+
+	reader := NewUtmpReader(buf)
+	rec, err := reader.Next()
+	if err != nil {
+		t.Fatalf("Next() error = %v", err)
+	}
+	assertField(t, rec.User, "sheb")
+
+	if _, err := reader.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Next() error = %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestReadLastlog(t *testing.T) {
+	file := make([]byte, 3*LastlogRecordSize)
+	record := file[2*LastlogRecordSize:]
+	binary.LittleEndian.PutUint32(record, 1578319645)
+	copy(record[4:], "pts/0")
+	copy(record[4+utLineSize:], "188.163.50.118")
+
+	tests := []struct {
+		uid  uint32
+		want *LastlogRecord
+	}{
+		{uid: 0},
+		{uid: 2, want: &LastlogRecord{UID: 2, Time: time.Unix(1578319645, 0), Line: "pts/0", Host: "188.163.50.118"}},
+		{uid: 1000},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			got, err := ReadLastlog(bytes.NewReader(file), tt.uid)
+			if err != nil {
+				t.Fatalf("ReadLastlog() error = %v", err)
+			}
+			if tt.want == nil || got == nil {
+				if got != tt.want {
+					t.Errorf("ReadLastlog() got = %v, want %v", got, tt.want)
+				}
+				return
+			}
+			assertField(t, got.UID, tt.want.UID)
+			assertField(t, got.Time, tt.want.Time)
+			assertField(t, got.Line, tt.want.Line)
+			assertField(t, got.Host, tt.want.Host)
+		})
+	}
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"flag"
+	"fmt"
+	"os"
 
 	"github.com/lancer-kit/uwe/v2"
+	"github.com/sheb-gregor/uwatch/commands"
 	"github.com/sheb-gregor/uwatch/config"
 	"github.com/sheb-gregor/uwatch/db"
 	"github.com/sheb-gregor/uwatch/workers"
@@ -12,6 +15,27 @@ import (
 
 var configPath = flag.String("config", "./config.json", "path to configuration file")
 
+const usage = `Usage: uwatch [-config path] [command]
+
+Commands:
+	run		watch the logs and send notifications, the default
+	backfill	import the rotated logs, like auth.log.1 and auth.log.2.gz, without notifications
+	events		print the event log, see uwatch events -h
+	crosscheck	report the wtmp logins missing in the auth logs and the lastlog logins missing in wtmp
+	compact		rewrite the database files to reclaim the space freed by the retention, uwatch must be stopped
+	export		print the sessions, keys, connections, escalations, events and Telegram users as JSONL
+	import file	load the export into the database, the stored records are kept and reported as the conflicts
+	backup		write the backup of the database files, see uwatch backup -h; the running uwatch locks the bolt files and backs up by the backup config itself
+	migrate		upgrade the database files to the current schema, uwatch must be stopped; -dry-run lists the pending migrations
+`
+
+func init() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+}
+
 func main() {
 	flag.Parse()
 	cfg := config.GetConfig(*configPath)
@@ -25,20 +49,183 @@ func main() {
 	logger.SetLevel(logLevel)
 	entry := logger.WithField("app", "uwatch")
 
-	storage, err := db.NewStorage(cfg.DB)
+	command := flag.Arg(0)
+	if command == "migrate" {
+		migrateFlags := flag.NewFlagSet("migrate", flag.ExitOnError)
+		dryRun := migrateFlags.Bool("dry-run", false, "list the pending migrations without applying them")
+		_ = migrateFlags.Parse(flag.Args()[1:])
+
+		results, err := db.MigrateStorage(cfg.DB, *dryRun)
+		for _, result := range results {
+			entry.WithFields(logrus.Fields{
+				"file":    result.File,
+				"version": result.Version,
+				"changed": result.Changed,
+				"dry_run": *dryRun,
+			}).Info(result.Description)
+		}
+		if err != nil {
+			entry.WithError(err).Fatal("migration failed")
+			return
+		}
+		entry.WithField("migrations", len(results)).Info("migration finished")
+		return
+	}
+
+	runs := command == "" || command == "run"
+	if command == "compact" || runs && cfg.Retention != nil && cfg.Retention.Compact {
+		compact := db.Compact
+		if cfg.Storage == db.BackendSQLite {
+			compact = db.CompactSQLite
+		}
+		report, err := compact(cfg.DB)
+		compactEntry := entry.WithFields(logrus.Fields{
+			"size_before": report.SizeBefore,
+			"size_after":  report.SizeAfter,
+		})
+		if err != nil {
+			compactEntry.WithError(err).Fatal("compaction failed")
+			return
+		}
+		compactEntry.Info("compaction finished")
+		if command == "compact" {
+			return
+		}
+	}
+
+	storage, err := db.Open(cfg.Storage, cfg.DB)
 	if err != nil {
 		entry.WithError(err).Fatal("unable to init storage")
 		return
 	}
 
+	switch command {
+	case "", "run":
+	case "backfill":
+		report, err := commands.Backfill(cfg, storage, entry)
+		entry = entry.WithFields(logrus.Fields{
+			"files":         report.Files,
+			"skipped_files": report.SkippedFiles,
+			"lines":         report.Lines,
+			"imported":      report.Imported,
+			"skipped":       report.Skipped,
+			"ignored":       report.Ignored,
+			"failed":        report.Failed,
+		})
+		if err != nil {
+			entry.WithError(err).Fatal("backfill failed")
+			return
+		}
+		entry.Info("backfill finished")
+		return
+	case "events":
+		if err := commands.Events(flag.Args()[1:], cfg, storage, os.Stdout); err != nil {
+			entry.WithError(err).Fatal("events query failed")
+		}
+		return
+	case "crosscheck":
+		report, err := commands.CrossCheck(cfg, storage, entry)
+		if err != nil {
+			entry.WithError(err).Fatal("crosscheck failed")
+			return
+		}
+		for _, login := range report.Unconfirmed {
+			entry.WithFields(logrus.Fields{
+				"username":    login.Username,
+				"remote_addr": login.RemoteAddr.String(),
+				"tty":         login.TTY,
+				"date":        login.Date,
+			}).Warn("login is missing in the auth logs")
+		}
+		for _, mismatch := range report.LastlogMismatches {
+			entry.WithFields(logrus.Fields{
+				"username":   mismatch.Username,
+				"lastlog":    mismatch.Lastlog,
+				"last_login": mismatch.LastLogin,
+			}).Warn("last login is missing in wtmp")
+		}
+		entry.WithFields(logrus.Fields{
+			"files":     report.Files,
+			"logins":    report.Logins,
+			"confirmed": report.Confirmed,
+		}).Info("crosscheck finished")
+		return
+	case "backup":
+		backupFlags := flag.NewFlagSet("backup", flag.ExitOnError)
+		backupCfg := config.BackupConfig{}
+		if cfg.Backup != nil {
+			backupCfg = *cfg.Backup
+		}
+		dir := backupFlags.String("dir", backupCfg.Dir, "directory of the backups")
+		gzip := backupFlags.Bool("gzip", backupCfg.Gzip, "compress the copies")
+		keep := backupFlags.Int("keep", backupCfg.Keep, "number of the kept backups, zero keeps all")
+		_ = backupFlags.Parse(flag.Args()[1:])
+		if *dir == "" {
+			entry.Fatal("backup dir is not set")
+			return
+		}
+
+		report, err := db.Backup(storage, *dir, db.BackupOptions{Gzip: *gzip, Keep: *keep})
+		if err != nil {
+			entry.WithError(err).Fatal("backup failed")
+			return
+		}
+		entry.WithFields(logrus.Fields{
+			"path":    report.Path,
+			"files":   report.Files,
+			"size":    report.Size,
+			"removed": report.Removed,
+		}).Info("backup finished")
+		return
+	case "export":
+		report, err := commands.Export(storage, os.Stdout)
+		if err != nil {
+			entry.WithError(err).Fatal("export failed")
+			return
+		}
+		entry.WithField("records", report.Records).Info("export finished")
+		return
+	case "import":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		report, err := commands.Import(flag.Arg(1), storage)
+		for _, conflict := range report.Conflicts {
+			entry.WithFields(logrus.Fields{
+				"line": conflict.Line,
+				"type": conflict.Type,
+				"user": conflict.User,
+				"id":   conflict.ID,
+			}).Warn("record is already stored")
+		}
+		entry = entry.WithFields(logrus.Fields{
+			"imported":  report.Imported,
+			"conflicts": len(report.Conflicts),
+		})
+		if err != nil {
+			entry.WithError(err).Fatal("import failed")
+			return
+		}
+		entry.Info("import finished")
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
 	chief := uwe.NewChief()
 	chief.UseDefaultRecover()
 
 	hub := workers.NewEventHub(10)
 
 	watcherBus := hub.AddWorker(workers.WWatcher)
-	chief.AddWorker(workers.WWatcher,
-		workers.NewWatcher(cfg, storage, watcherBus, entry))
+	watcher, err := workers.NewWatcher(cfg, storage, watcherBus, entry)
+	if err != nil {
+		entry.WithError(err).Fatal("invalid tg sessions rule")
+		return
+	}
+	chief.AddWorker(workers.WWatcher, watcher)
 
 	if cfg.TG != nil {
 		botBus := hub.AddWorker(workers.WTGBot)
@@ -46,6 +233,26 @@ func main() {
 			workers.NewTgBot(*cfg.TG, storage, botBus, entry))
 	}
 
+	if cfg.Detection != nil {
+		detectionBus := hub.AddWorker(workers.WDetection)
+		detection, err := workers.NewDetection(*cfg.Detection, storage, detectionBus, entry)
+		if err != nil {
+			entry.WithError(err).Fatal("invalid detection rules")
+			return
+		}
+		chief.AddWorker(workers.WDetection, detection)
+	}
+
+	if cfg.Retention != nil {
+		chief.AddWorker(workers.WRetention,
+			workers.NewRetention(*cfg.Retention, storage, entry))
+	}
+
+	if cfg.Backup != nil {
+		chief.AddWorker(workers.WBackup,
+			workers.NewBackup(*cfg.Backup, storage, entry))
+	}
+
 	chief.AddWorker(workers.WHub, hub)
 
 	chief.SetEventHandler(func(event uwe.Event) {
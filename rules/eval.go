@@ -0,0 +1,261 @@
+package rules
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+// env is the evaluated event, the values are bool, float64, string and time.Duration.
+type env struct {
+	info    db.AuthInfo
+	history History
+}
+
+type node interface {
+	typ() Type
+	pos() int
+	eval(env *env) (interface{}, error)
+}
+
+// at is the column of the node.
+type at int
+
+func (a at) pos() int {
+	return int(a)
+}
+
+type literalNode struct {
+	at
+	t     Type
+	value interface{}
+}
+
+func (n *literalNode) typ() Type { return n.t }
+
+func (n *literalNode) eval(*env) (interface{}, error) {
+	return n.value, nil
+}
+
+type fieldNode struct {
+	at
+	field field
+}
+
+func (n *fieldNode) typ() Type { return n.field.t }
+
+func (n *fieldNode) eval(env *env) (interface{}, error) {
+	return n.field.get(env.info), nil
+}
+
+// callFunc evaluates the function call.
+type callFunc func(env *env) (interface{}, error)
+
+type callNode struct {
+	at
+	t    Type
+	call callFunc
+}
+
+func (n *callNode) typ() Type { return n.t }
+
+func (n *callNode) eval(env *env) (interface{}, error) {
+	return n.call(env)
+}
+
+type notNode struct {
+	at
+	x node
+}
+
+func (n *notNode) typ() Type { return TypeBool }
+
+func (n *notNode) eval(env *env) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	return !x.(bool), nil
+}
+
+// logicNode is || or && with the short-circuit, the history of the skipped operand is not queried.
+type logicNode struct {
+	at
+	or   bool
+	x, y node
+}
+
+func (n *logicNode) typ() Type { return TypeBool }
+
+func (n *logicNode) eval(env *env) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil || x.(bool) == n.or {
+		return x, err
+	}
+	return n.y.eval(env)
+}
+
+type compareNode struct {
+	at
+	op   tokenKind
+	x, y node
+}
+
+func (n *compareNode) typ() Type { return TypeBool }
+
+func (n *compareNode) eval(env *env) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	y, err := n.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case tokenEq:
+		return x == y, nil
+	case tokenNeq:
+		return x != y, nil
+	}
+
+	// the ordered types are checked by the parser
+	var a, b float64
+	switch x := x.(type) {
+	case float64:
+		a, b = x, y.(float64)
+	case time.Duration:
+		a, b = float64(x), float64(y.(time.Duration))
+	}
+	switch n.op {
+	case tokenLt:
+		return a < b, nil
+	case tokenLe:
+		return a <= b, nil
+	case tokenGt:
+		return a > b, nil
+	default:
+		return a >= b, nil
+	}
+}
+
+type field struct {
+	t   Type
+	get func(info db.AuthInfo) interface{}
+}
+
+// fields are the attributes of the event, the missing ones are the empty string and zero.
+var fields = map[string]field{
+	"status": {t: TypeString, get: func(info db.AuthInfo) interface{} { return string(info.Status) }},
+	"user":   {t: TypeString, get: func(info db.AuthInfo) interface{} { return info.Username }},
+	"method": {t: TypeString, get: func(info db.AuthInfo) interface{} { return info.AuthMethod }},
+	"ip": {t: TypeString, get: func(info db.AuthInfo) interface{} {
+		if len(info.RemoteAddr) == 0 {
+			return ""
+		}
+		return info.RemoteAddr.String()
+	}},
+	"port": {t: TypeNumber, get: func(info db.AuthInfo) interface{} { return float64(info.Port) }},
+	"host": {t: TypeString, get: func(info db.AuthInfo) interface{} { return info.Host }},
+	"country": {t: TypeString, get: func(info db.AuthInfo) interface{} {
+		if info.Geo == nil {
+			return ""
+		}
+		return info.Geo.Country
+	}},
+	"city": {t: TypeString, get: func(info db.AuthInfo) interface{} {
+		if info.Geo == nil {
+			return ""
+		}
+		return info.Geo.City
+	}},
+	"asn": {t: TypeNumber, get: func(info db.AuthInfo) interface{} {
+		if info.Geo == nil {
+			return float64(0)
+		}
+		return float64(info.Geo.ASN)
+	}},
+}
+
+type function struct {
+	params []Type
+	result Type
+	// bind checks the arguments and returns the evaluation of the call.
+	bind func(args []node) (callFunc, error)
+}
+
+var functions = map[string]function{
+	// cidr("10.0.0.0/8") reports whether the address of the event is in the network.
+	"cidr": {params: []Type{TypeString}, result: TypeBool, bind: bindCIDR},
+	// fails_in(10m) is the number of the failed attempts from the address in the window.
+	"fails_in": {params: []Type{TypeDuration}, result: TypeNumber, bind: bindHistory(History.FailsIn)},
+	// logins_in(1h) is the number of the accepted logins of the user in the window.
+	"logins_in": {params: []Type{TypeDuration}, result: TypeNumber, bind: bindHistory(History.LoginsIn)},
+	// first_seen() reports whether the login is the first one from the address, the network or with the method.
+	"first_seen": {result: TypeBool, bind: func([]node) (callFunc, error) {
+		return func(env *env) (interface{}, error) {
+			if env.info.Status != db.AuthAccepted {
+				return false, nil
+			}
+			return env.history.FirstSeen(env.info)
+		}, nil
+	}},
+	// label("host") is the label of the log source.
+	"label": {params: []Type{TypeString}, result: TypeString, bind: func(args []node) (callFunc, error) {
+		return func(env *env) (interface{}, error) {
+			name, err := args[0].eval(env)
+			if err != nil {
+				return nil, err
+			}
+			return env.info.Labels[name.(string)], nil
+		}, nil
+	}},
+}
+
+func bindCIDR(args []node) (callFunc, error) {
+	literal, ok := args[0].(*literalNode)
+	if !ok {
+		return nil, &Error{Pos: args[0].pos(), Msg: "the network of cidr() must be the string literal"}
+	}
+	_, network, err := net.ParseCIDR(literal.value.(string))
+	if err != nil {
+		return nil, &Error{Pos: literal.pos(), Msg: fmt.Sprintf("invalid network %q", literal.value)}
+	}
+	return func(env *env) (interface{}, error) {
+		return len(env.info.RemoteAddr) != 0 && network.Contains(env.info.RemoteAddr), nil
+	}, nil
+}
+
+// bindHistory binds the counter of the history with the window argument.
+func bindHistory(count func(History, db.AuthInfo, time.Duration) (int, error)) func(args []node) (callFunc, error) {
+	return func(args []node) (callFunc, error) {
+		return func(env *env) (interface{}, error) {
+			window, err := args[0].eval(env)
+			if err != nil {
+				return nil, err
+			}
+			n, err := count(env.history, env.info, window.(time.Duration))
+			return float64(n), err
+		}, nil
+	}
+}
+
+func fieldNames() (names []string) {
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func functionNames() (names []string) {
+	for name := range functions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
@@ -0,0 +1,160 @@
+package rules
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/sheb-gregor/uwatch/config"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenDuration
+	tokenLParen
+	tokenRParen
+	tokenComma
+	tokenNot
+	tokenAnd
+	tokenOr
+	tokenEq
+	tokenNeq
+	tokenLt
+	tokenLe
+	tokenGt
+	tokenGe
+)
+
+type token struct {
+	kind tokenKind
+	// pos is the 1-based column of the token.
+	pos  int
+	text string
+	// value is the parsed literal.
+	value interface{}
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// operators are the punctuation tokens, the longer ones go first.
+var operators = []struct {
+	text string
+	kind tokenKind
+}{
+	{"&&", tokenAnd},
+	{"||", tokenOr},
+	{"==", tokenEq},
+	{"!=", tokenNeq},
+	{"<=", tokenLe},
+	{">=", tokenGe},
+	{"<", tokenLt},
+	{">", tokenGt},
+	{"!", tokenNot},
+	{"(", tokenLParen},
+	{")", tokenRParen},
+	{",", tokenComma},
+}
+
+type lexer struct {
+	src string
+	off int
+}
+
+func (l *lexer) next() (tok token, err error) {
+	for l.off < len(l.src) && strings.IndexByte(" \t\r\n", l.src[l.off]) >= 0 {
+		l.off++
+	}
+	start := l.off
+	tok.pos = start + 1
+	if l.off == len(l.src) {
+		return
+	}
+
+	c := l.src[l.off]
+	switch {
+	case isLetter(c):
+		for l.off < len(l.src) && (isLetter(l.src[l.off]) || isDigit(l.src[l.off])) {
+			l.off++
+		}
+		tok.kind, tok.text = tokenIdent, l.src[start:l.off]
+		return
+	case isDigit(c):
+		return l.number(tok)
+	case c == '"':
+		return l.string(tok)
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.off:], op.text) {
+			l.off += len(op.text)
+			tok.kind, tok.text = op.kind, op.text
+			return
+		}
+	}
+	return tok, &Error{Pos: tok.pos, Msg: "unexpected character " + strconv.QuoteRune(rune(c))}
+}
+
+// number scans the number or the duration, the digits followed by the unit letters are the duration.
+func (l *lexer) number(tok token) (token, error) {
+	start := l.off
+	duration := false
+	for l.off < len(l.src) && (isDigit(l.src[l.off]) || l.src[l.off] == '.' || isLetter(l.src[l.off])) {
+		duration = duration || isLetter(l.src[l.off])
+		l.off++
+	}
+	tok.text = l.src[start:l.off]
+
+	if !duration {
+		value, err := strconv.ParseFloat(tok.text, 64)
+		if err != nil {
+			return tok, &Error{Pos: tok.pos, Msg: "invalid number " + strconv.Quote(tok.text)}
+		}
+		tok.kind, tok.value = tokenNumber, value
+		return tok, nil
+	}
+
+	value, err := config.ParseDuration(tok.text)
+	if err != nil {
+		return tok, &Error{Pos: tok.pos, Msg: "invalid duration " + strconv.Quote(tok.text)}
+	}
+	tok.kind, tok.value = tokenDuration, value
+	return tok, nil
+}
+
+func (l *lexer) string(tok token) (token, error) {
+	start := l.off
+	for l.off++; l.off < len(l.src); l.off++ {
+		switch l.src[l.off] {
+		case '\\':
+			l.off++
+			continue
+		case '"':
+			l.off++
+			tok.text = l.src[start:l.off]
+			value, err := strconv.Unquote(tok.text)
+			if err != nil {
+				return tok, &Error{Pos: tok.pos, Msg: "invalid string " + tok.text}
+			}
+			tok.kind, tok.value = tokenString, value
+			return tok, nil
+		}
+	}
+	return tok, &Error{Pos: tok.pos, Msg: "unterminated string"}
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
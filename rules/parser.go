@@ -0,0 +1,232 @@
+package rules
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// parser is the recursive descent parser of the grammar:
+//
+//	or      = and { "||" and }
+//	and     = unary { "&&" unary }
+//	unary   = "!" unary | compare
+//	compare = primary [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" ) primary ]
+//	primary = literal | field | function "(" [ or { "," or } ] ")" | "(" or ")"
+type parser struct {
+	lexer lexer
+	tok   token
+	// windows are the longest duration arguments of the functions.
+	windows map[string]time.Duration
+}
+
+func (p *parser) parse() (node, error) {
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokenEOF {
+		return nil, p.unexpected()
+	}
+	if root.typ() != TypeBool {
+		return nil, &Error{Pos: root.pos(), Msg: fmt.Sprintf("the expression is %s, want bool", root.typ())}
+	}
+	return root, nil
+}
+
+func (p *parser) next() (err error) {
+	p.tok, err = p.lexer.next()
+	return
+}
+
+func (p *parser) unexpected() error {
+	return &Error{Pos: p.tok.pos, Msg: "unexpected " + p.tok.String()}
+}
+
+func (p *parser) or() (node, error) {
+	return p.logic(tokenOr, p.and)
+}
+
+func (p *parser) and() (node, error) {
+	return p.logic(tokenAnd, p.unary)
+}
+
+func (p *parser) logic(kind tokenKind, operand func() (node, error)) (node, error) {
+	x, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.tok.kind == kind {
+		op := p.tok
+		if err = p.next(); err != nil {
+			return nil, err
+		}
+		y, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		if err = checkType(op.text, x, TypeBool); err != nil {
+			return nil, err
+		}
+		if err = checkType(op.text, y, TypeBool); err != nil {
+			return nil, err
+		}
+		x = &logicNode{at: at(x.pos()), or: kind == tokenOr, x: x, y: y}
+	}
+	return x, nil
+}
+
+func (p *parser) unary() (node, error) {
+	if p.tok.kind != tokenNot {
+		return p.compare()
+	}
+	op := p.tok
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	if err = checkType(op.text, x, TypeBool); err != nil {
+		return nil, err
+	}
+	return &notNode{at: at(op.pos), x: x}, nil
+}
+
+func (p *parser) compare() (node, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	op := p.tok
+	switch op.kind {
+	case tokenEq, tokenNeq, tokenLt, tokenLe, tokenGt, tokenGe:
+	default:
+		return x, nil
+	}
+	if err = p.next(); err != nil {
+		return nil, err
+	}
+	y, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+
+	if x.typ() != y.typ() {
+		return nil, &Error{Pos: op.pos, Msg: fmt.Sprintf("mismatched types %s %s %s", x.typ(), op.text, y.typ())}
+	}
+	ordered := op.kind != tokenEq && op.kind != tokenNeq
+	if ordered && x.typ() != TypeNumber && x.typ() != TypeDuration {
+		return nil, &Error{Pos: op.pos, Msg: fmt.Sprintf("operator %s is not defined on %s", op.text, x.typ())}
+	}
+	return &compareNode{at: at(x.pos()), op: op.kind, x: x, y: y}, nil
+}
+
+func (p *parser) primary() (node, error) {
+	tok := p.tok
+	switch tok.kind {
+	case tokenString:
+		return &literalNode{at: at(tok.pos), t: TypeString, value: tok.value}, p.next()
+	case tokenNumber:
+		return &literalNode{at: at(tok.pos), t: TypeNumber, value: tok.value}, p.next()
+	case tokenDuration:
+		return &literalNode{at: at(tok.pos), t: TypeDuration, value: tok.value}, p.next()
+	case tokenLParen:
+		if err := p.next(); err != nil {
+			return nil, err
+		}
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.tok.kind != tokenRParen {
+			return nil, p.unexpected()
+		}
+		return x, p.next()
+	case tokenIdent:
+	default:
+		return nil, p.unexpected()
+	}
+
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	switch tok.text {
+	case "true", "false":
+		return &literalNode{at: at(tok.pos), t: TypeBool, value: tok.text == "true"}, nil
+	}
+	if p.tok.kind == tokenLParen {
+		return p.call(tok)
+	}
+	f, ok := fields[tok.text]
+	if !ok {
+		return nil, &Error{Pos: tok.pos, Msg: fmt.Sprintf("unknown field %q, the fields are %s",
+			tok.text, strings.Join(fieldNames(), ", "))}
+	}
+	return &fieldNode{at: at(tok.pos), field: f}, nil
+}
+
+func (p *parser) call(name token) (node, error) {
+	fn, ok := functions[name.text]
+	if !ok {
+		return nil, &Error{Pos: name.pos, Msg: fmt.Sprintf("unknown function %q, the functions are %s",
+			name.text, strings.Join(functionNames(), ", "))}
+	}
+
+	var args []node
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+	for p.tok.kind != tokenRParen {
+		if len(args) > 0 {
+			if p.tok.kind != tokenComma {
+				return nil, p.unexpected()
+			}
+			if err := p.next(); err != nil {
+				return nil, err
+			}
+		}
+		arg, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	end := p.tok.pos
+	if err := p.next(); err != nil {
+		return nil, err
+	}
+
+	if len(args) != len(fn.params) {
+		return nil, &Error{Pos: end, Msg: fmt.Sprintf("%s() takes %d arguments, got %d", name.text, len(fn.params), len(args))}
+	}
+	for i, arg := range args {
+		if arg.typ() != fn.params[i] {
+			return nil, &Error{Pos: arg.pos(), Msg: fmt.Sprintf("argument %d of %s() is %s, want %s",
+				i+1, name.text, arg.typ(), fn.params[i])}
+		}
+	}
+	call, err := fn.bind(args)
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range args {
+		if literal, ok := arg.(*literalNode); ok && literal.t == TypeDuration {
+			if window := literal.value.(time.Duration); window > p.windows[name.text] {
+				p.windows[name.text] = window
+			}
+		}
+	}
+	return &callNode{at: at(name.pos), t: fn.result, call: call}, nil
+}
+
+func checkType(op string, x node, t Type) error {
+	if x.typ() != t {
+		return &Error{Pos: x.pos(), Msg: fmt.Sprintf("operand of %s is %s, want %s", op, x.typ(), t)}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// Package rules is the small expression language of the alert rules, the expression
+// like `status == "Accepted" && user == "root"` or `fails_in(10m) > 20 && !cidr("10.0.0.0/8")`
+// is evaluated against every auth event.
+//
+// The operators are || and && of the bools, ! of the bool, ==, != of any equal types
+// and <, <=, >, >= of the numbers and the durations. The literals are the double-quoted strings,
+// the numbers, the durations like 90s, 10m, 1h30m or 7d and true, false. The fields and the functions
+// are listed in fields and functions.
+package rules
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+// Type is the static type of the expression, the types are checked at the compile time.
+type Type int
+
+const (
+	TypeBool Type = iota + 1
+	TypeNumber
+	TypeString
+	TypeDuration
+)
+
+func (t Type) String() string {
+	switch t {
+	case TypeBool:
+		return "bool"
+	case TypeNumber:
+		return "number"
+	case TypeString:
+		return "string"
+	case TypeDuration:
+		return "duration"
+	}
+	return "unknown"
+}
+
+// Error is the compile error of the expression, Pos is the 1-based byte column of the error.
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("at %d: %s", e.Pos, e.Msg)
+}
+
+// History is the stored records of the user and the address of the evaluated event.
+// The event may be already stored, the history counts it once anyway.
+type History interface {
+	// FailsIn returns the failed attempts from the address of the event in the window till the event.
+	FailsIn(info db.AuthInfo, window time.Duration) (int, error)
+	// LoginsIn returns the accepted logins of the user of the event in the window till the event.
+	LoginsIn(info db.AuthInfo, window time.Duration) (int, error)
+	// FirstSeen reports whether the accepted login is the first one of the user from the address,
+	// the network or with the auth method.
+	FirstSeen(info db.AuthInfo) (bool, error)
+}
+
+// Expr is the compiled bool expression.
+type Expr struct {
+	src     string
+	root    node
+	windows map[string]time.Duration
+}
+
+// Compile parses and checks the expression, the error is *Error with the position.
+func Compile(src string) (*Expr, error) {
+	p := &parser{lexer: lexer{src: src}, windows: map[string]time.Duration{}}
+	root, err := p.parse()
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{src: src, root: root, windows: p.windows}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Window returns the longest window of the function calls like fails_in(10m), zero if there are none.
+func (e *Expr) Window(function string) time.Duration {
+	return e.windows[function]
+}
+
+// Match evaluates the expression for the event, the history is queried only by the functions
+// of the evaluated operands.
+func (e *Expr) Match(info db.AuthInfo, history History) (bool, error) {
+	value, err := e.root.eval(&env{info: info, history: history})
+	if err != nil {
+		return false, err
+	}
+	return value.(bool), nil
+}
@@ -0,0 +1,173 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/db"
+)
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		src     string
+		wantPos int
+		wantMsg string
+	}{
+		{src: `status == "Accepted" && user == "root"`},
+		{src: `fails_in(10m) > 20 && !cidr("10.0.0.0/8")`},
+		{src: `(port <= 1024 || logins_in(1d) >= 3.5) && first_seen() == true`},
+		{src: `label("env") != "prod" || country == "DE" && asn == 3320`},
+		{src: ``, wantPos: 1, wantMsg: `unexpected end of expression`},
+		{src: `status == "Accepted" && usr == "root"`, wantPos: 25, wantMsg: `unknown field "usr"`},
+		{src: `status == "Accepted`, wantPos: 11, wantMsg: `unterminated string`},
+		{src: `user = "root"`, wantPos: 6, wantMsg: `unexpected character '='`},
+		{src: `user == "root" &&`, wantPos: 18, wantMsg: `unexpected end of expression`},
+		{src: `(user == "root"`, wantPos: 16, wantMsg: `unexpected end of expression`},
+		{src: `user == "root")`, wantPos: 15, wantMsg: `unexpected ")"`},
+		{src: `port == "22"`, wantPos: 6, wantMsg: `mismatched types number == string`},
+		{src: `user > "a"`, wantPos: 6, wantMsg: `operator > is not defined on string`},
+		{src: `fails_in(10) > 20`, wantPos: 10, wantMsg: `argument 1 of fails_in() is number, want duration`},
+		{src: `fails_in(10x) > 20`, wantPos: 10, wantMsg: `invalid duration "10x"`},
+		{src: `fails_in(10m, 1h) > 20`, wantPos: 17, wantMsg: `fails_in() takes 1 arguments, got 2`},
+		{src: `cidr("10.0.0.0/33")`, wantPos: 6, wantMsg: `invalid network "10.0.0.0/33"`},
+		{src: `cidr(user)`, wantPos: 6, wantMsg: `the network of cidr() must be the string literal`},
+		{src: `geoip("DE")`, wantPos: 1, wantMsg: `unknown function "geoip"`},
+		{src: `!port`, wantPos: 2, wantMsg: `operand of ! is number, want bool`},
+		{src: `first_seen() && user`, wantPos: 17, wantMsg: `operand of && is string, want bool`},
+		{src: `user`, wantPos: 1, wantMsg: `the expression is string, want bool`},
+		{src: `port == 22 == true`, wantPos: 12, wantMsg: `unexpected "=="`},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			expr, err := Compile(tt.src)
+			if tt.wantMsg == "" {
+				if err != nil {
+					t.Fatalf("Compile() error = %v", err)
+				}
+				if expr.String() != tt.src {
+					t.Errorf("String() got = %q, want %q", expr.String(), tt.src)
+				}
+				return
+			}
+
+			var compileErr *Error
+			if !errors.As(err, &compileErr) {
+				t.Fatalf("Compile() error = %v, want *Error", err)
+			}
+			if compileErr.Pos != tt.wantPos || !strings.HasPrefix(compileErr.Msg, tt.wantMsg) {
+				t.Errorf("Compile() error = %v, want at %d: %s", err, tt.wantPos, tt.wantMsg)
+			}
+		})
+	}
+}
+
+// history is the fake History, it records the queried functions.
+type history struct {
+	fails, logins int
+	firstSeen     bool
+	err           error
+	calls         []string
+}
+
+func (h *history) FailsIn(info db.AuthInfo, window time.Duration) (int, error) {
+	h.calls = append(h.calls, "fails_in "+window.String())
+	return h.fails, h.err
+}
+
+func (h *history) LoginsIn(info db.AuthInfo, window time.Duration) (int, error) {
+	h.calls = append(h.calls, "logins_in "+window.String())
+	return h.logins, h.err
+}
+
+func (h *history) FirstSeen(info db.AuthInfo) (bool, error) {
+	h.calls = append(h.calls, "first_seen")
+	return h.firstSeen, h.err
+}
+
+func TestExpr_Match(t *testing.T) {
+	accepted := db.AuthInfo{Status: db.AuthAccepted, Username: "root", AuthMethod: "publickey",
+		RemoteAddr: net.ParseIP("81.2.69.142"), Port: 40022, Host: "web", Labels: map[string]string{"env": "prod"},
+		Geo: &db.GeoInfo{Country: "GB", City: "London", ASN: 20712}}
+	failed := db.AuthInfo{Status: db.AuthFailed, Username: "admin", AuthMethod: "password",
+		RemoteAddr: net.ParseIP("10.1.2.3"), Port: 50000}
+
+	tests := []struct {
+		src       string
+		info      db.AuthInfo
+		history   history
+		want      bool
+		wantCalls []string
+		wantErr   bool
+	}{
+		{src: `status == "Accepted" && user == "root"`, info: accepted, want: true},
+		{src: `status == "Accepted" && user == "root"`, info: failed, want: false},
+		{src: `ip == "81.2.69.142" && port > 40000 && method != "password"`, info: accepted, want: true},
+		{src: `country == "GB" && city == "London" && asn == 20712 && host == "web"`, info: accepted, want: true},
+		{src: `country == ""`, info: failed, want: true},
+		{src: `label("env") == "prod"`, info: accepted, want: true},
+		{src: `label("env") == "prod"`, info: failed, want: false},
+		{src: `cidr("10.0.0.0/8")`, info: failed, want: true},
+		{src: `cidr("2001:db8::/32") || cidr("81.2.69.0/24")`, info: accepted, want: true},
+		{src: `cidr("10.0.0.0/8")`, info: db.AuthInfo{Status: db.AuthSessionOpened}, want: false},
+		{src: `fails_in(10m) > 20 && !cidr("10.0.0.0/8")`, info: accepted, history: history{fails: 21},
+			want: true, wantCalls: []string{"fails_in 10m0s"}},
+		{src: `fails_in(10m) > 20 && !cidr("10.0.0.0/8")`, info: failed, history: history{fails: 20},
+			want: false, wantCalls: []string{"fails_in 10m0s"}},
+		// the history is not queried by the short-circuit
+		{src: `status == "Failed" && fails_in(1h) >= 5`, info: accepted, want: false},
+		{src: `user == "root" || logins_in(1d) > 3`, info: accepted, want: true},
+		{src: `logins_in(7d) > 3`, info: accepted, history: history{logins: 4},
+			want: true, wantCalls: []string{"logins_in 168h0m0s"}},
+		{src: `first_seen() && !(user == "admin")`, info: accepted, history: history{firstSeen: true},
+			want: true, wantCalls: []string{"first_seen"}},
+		// only the accepted logins are first seen
+		{src: `first_seen()`, info: failed, want: false},
+		{src: `10m < 1h && 2.5 >= 2 && 1h30m == 90m`, info: failed, want: true},
+		{src: `first_seen() == false`, info: accepted, history: history{err: errors.New("storage failed")},
+			wantCalls: []string{"first_seen"}, wantErr: true},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			expr, err := Compile(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := expr.Match(tt.info, &tt.history)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Match() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Match() got = %v, want %v", got, tt.want)
+			}
+			if !reflect.DeepEqual(tt.history.calls, tt.wantCalls) {
+				t.Errorf("Match() calls got = %v, want %v", tt.history.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestExpr_Window(t *testing.T) {
+	expr, err := Compile(`fails_in(10m) > 20 || fails_in(1h) > 50 && logins_in(1d) > 3`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		function string
+		want     time.Duration
+	}{
+		{function: "fails_in", want: time.Hour},
+		{function: "logins_in", want: 24 * time.Hour},
+		{function: "first_seen", want: 0},
+	}
+	for _, tt := range tests {
+		if got := expr.Window(tt.function); got != tt.want {
+			t.Errorf("Window(%q) got = %v, want %v", tt.function, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package sources
+
+import (
+	"context"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/logparser"
+)
+
+// File follows the syslog file like /var/log/auth.log from the saved position.
+// The rotated files read to the end are marked as imported for the backfill.
+type File struct {
+	path      string
+	headers   *logparser.HeaderParser
+	positions db.PositionStorage
+	imports   db.ImportStorage
+	settings  Settings
+}
+
+func NewFile(path string, headers *logparser.HeaderParser,
+	positions db.PositionStorage, imports db.ImportStorage, settings Settings) *File {
+	return &File{
+		path:      path,
+		headers:   headers,
+		positions: positions,
+		imports:   imports,
+		settings:  settings,
+	}
+}
+
+func (s *File) Run(ctx context.Context, out chan<- *logparser.Event) error {
+	logger := s.settings.Logger
+
+	pos, err := s.positions.GetPosition(s.path)
+	if err != nil {
+		logger.WithError(err).Error("failed to get saved position")
+		return err
+	}
+
+	f := newFollower(s.path, logger)
+	f.finished = func(fileID string) {
+		err := s.imports.SaveImport(db.ImportState{
+			FileID: fileID, Path: s.path, Done: true, UpdatedAt: time.Now()})
+		if err != nil {
+			logger.WithError(err).Error("failed to mark the rotated file as imported")
+		}
+	}
+	if err := f.open(pos); err != nil {
+		logger.WithError(err).Error("failed to open auth log")
+		return err
+	}
+	defer f.close()
+
+	for {
+		line, err := f.next(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.WithError(err).Error("failed to read auth log")
+			return err
+		}
+		logger.Debug("new auth log line")
+
+		rec, err := s.headers.Split(line)
+		if err != nil {
+			logger.WithError(err).Debug("invalid auth log line")
+			continue
+		}
+
+		event, err := s.settings.Parse(rec)
+		if err != nil {
+			logger.WithError(err).Debug("invalid auth log line")
+			continue
+		}
+
+		pos := f.position()
+		event.Position = &pos
+		if !send(ctx, out, event) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package sources
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sirupsen/logrus"
+)
+
+const defaultPollInterval = 250 * time.Millisecond
+
+// follower reads lines of the file from the saved position and follows its rotation and truncation.
+type follower struct {
+	path         string
+	pollInterval time.Duration
+	logger       *logrus.Entry
+
+	file   *os.File
+	reader *bufio.Reader
+	inode  uint64
+	// offset of the next unread line
+	offset int64
+	// partial is the tail of the file without the trailing newline yet
+	partial string
+	// rotated is set when the path points to a new file, the old one is read to the end first
+	rotated bool
+	// finished is called with the content ID of the rotated file read to the end
+	finished func(fileID string)
+}
+
+func newFollower(path string, logger *logrus.Entry) *follower {
+	return &follower{
+		path:         path,
+		pollInterval: defaultPollInterval,
+		logger:       logger,
+	}
+}
+
+// open opens the file at the saved position. When the position belongs to another file,
+// the rotated `<path>.1` with the same inode is read from the position first,
+// otherwise the file is read from the beginning.
+func (f *follower) open(pos *db.FilePosition) error {
+	file, inode, err := openFile(f.path)
+	if err != nil {
+		return err
+	}
+
+	if pos == nil {
+		return f.use(file, inode, 0)
+	}
+
+	if pos.Inode == inode {
+		if size := fileSize(file); pos.Offset > size {
+			f.logger.WithField("offset", pos.Offset).WithField("size", size).
+				Warn("file truncated since the last run, read from the beginning")
+			return f.use(file, inode, 0)
+		}
+		return f.use(file, inode, pos.Offset)
+	}
+
+	rotated, rotatedInode, err := openFile(f.path + ".1")
+	if err == nil && rotatedInode == pos.Inode && pos.Offset <= fileSize(rotated) {
+		f.logger.Info("file rotated since the last run, read the rest of the rotated one")
+		_ = file.Close()
+		f.rotated = true
+		return f.use(rotated, rotatedInode, pos.Offset)
+	}
+	if err == nil {
+		_ = rotated.Close()
+	}
+
+	f.logger.Warn("file replaced since the last run, read from the beginning")
+	return f.use(file, inode, 0)
+}
+
+func (f *follower) use(file *os.File, inode uint64, offset int64) error {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	if f.file != nil && f.file != file {
+		_ = f.file.Close()
+	}
+
+	f.file = file
+	f.reader = bufio.NewReader(file)
+	f.inode = inode
+	f.offset = offset
+	f.partial = ""
+	return nil
+}
+
+func (f *follower) close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// position returns the position after the last returned line.
+func (f *follower) position() db.FilePosition {
+	return db.FilePosition{Path: f.path, Inode: f.inode, Offset: f.offset, UpdatedAt: time.Now()}
+}
+
+// next blocks until the next complete line is written or the ctx is done.
+func (f *follower) next(ctx context.Context) (string, error) {
+	for {
+		chunk, err := f.reader.ReadString('\n')
+		f.partial += chunk
+		if err == nil {
+			line := f.partial
+			f.offset += int64(len(line))
+			f.partial = ""
+			return strings.TrimRight(line, "\r\n"), nil
+		}
+		if err != io.EOF {
+			return "", err
+		}
+
+		switched, err := f.follow()
+		if err != nil {
+			return "", err
+		}
+		if switched {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(f.pollInterval):
+		}
+	}
+}
+
+// follow checks the path at the end of the file and switches to the new file after the rotation,
+// or to the beginning after the truncation.
+func (f *follower) follow() (bool, error) {
+	info, err := os.Stat(f.path)
+	if os.IsNotExist(err) {
+		// rotated but not created yet
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if inode := fileInode(info); inode != f.inode {
+		// the writer may still append to the old file, give it one more poll
+		if !f.rotated {
+			f.rotated = true
+			return false, nil
+		}
+
+		file, inode, err := openFile(f.path)
+		if err != nil {
+			return false, err
+		}
+
+		f.logger.Info("file rotated, follow the new one")
+		f.finish()
+		f.rotated = false
+		return true, f.use(file, inode, 0)
+	}
+
+	if size := info.Size(); size < f.offset+int64(len(f.partial)) {
+		f.logger.Info("file truncated, read from the beginning")
+		return true, f.use(f.file, f.inode, 0)
+	}
+
+	return false, nil
+}
+
+func (f *follower) finish() {
+	if f.finished == nil {
+		return
+	}
+
+	fileID, err := FileID(io.NewSectionReader(f.file, 0, fileIDSize))
+	if err != nil {
+		f.logger.WithError(err).Warn("failed to identify the rotated file")
+		return
+	}
+	f.finished(fileID)
+}
+
+func openFile(path string) (*os.File, uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, err
+	}
+
+	return file, fileInode(info), nil
+}
+
+func fileSize(file *os.File) int64 {
+	info, err := file.Stat()
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
@@ -0,0 +1,113 @@
+package sources
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testFollower(t *testing.T, path string) *follower {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	f := newFollower(path, logrus.NewEntry(logger))
+	f.pollInterval = time.Millisecond
+	return f
+}
+
+func appendFile(t *testing.T, path, data string) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertNext(t *testing.T, f *follower, want string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	got, err := f.next(ctx)
+	if err != nil {
+		t.Fatalf("next() error = %v, want %q", err, want)
+	}
+	if got != want {
+		t.Errorf("next() got = %q, want %q", got, want)
+	}
+}
+
+func TestFollower(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "auth.log")
+
+	appendFile(t, path, "line 1\nline 2\n")
+
+	f := testFollower(t, path)
+	if err := f.open(nil); err != nil {
+		t.Fatal(err)
+	}
+	assertNext(t, f, "line 1")
+	pos := f.position()
+	_ = f.close()
+
+	// resume after the saved line, the partial line is returned once completed
+	appendFile(t, path, "line 3\nline")
+	var finished []string
+	f = testFollower(t, path)
+	f.finished = func(fileID string) { finished = append(finished, fileID) }
+	if err := f.open(&pos); err != nil {
+		t.Fatal(err)
+	}
+	assertNext(t, f, "line 2")
+	assertNext(t, f, "line 3")
+	appendFile(t, path, " 4\n")
+	assertNext(t, f, "line 4")
+
+	// truncation
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "line 5\n")
+	assertNext(t, f, "line 5")
+
+	// rotation, the rest of the old file is read first
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path+".1", "line 6\n")
+	appendFile(t, path, "line 7\n")
+	assertNext(t, f, "line 6")
+	assertNext(t, f, "line 7")
+	if len(finished) != 1 {
+		t.Errorf("finished called %d times, want 1", len(finished))
+	}
+	pos = f.position()
+	_ = f.close()
+
+	// rotated while stopped
+	appendFile(t, path, "line 8\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendFile(t, path, "line 9\n")
+	f = testFollower(t, path)
+	if err := f.open(&pos); err != nil {
+		t.Fatal(err)
+	}
+	defer f.close()
+	assertNext(t, f, "line 8")
+	assertNext(t, f, "line 9")
+}
@@ -0,0 +1,8 @@
+package sources
+
+import "os"
+
+// fileInode is not available on windows, the rotation is detected by the truncation only.
+func fileInode(info os.FileInfo) uint64 {
+	return 0
+}
@@ -0,0 +1,101 @@
+package sources
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/sheb-gregor/uwatch/logparser"
+	"github.com/sirupsen/logrus"
+)
+
+// Journal reads the `journalctl -o export` or `-o json` stream from a file, a named pipe or stdin.
+type Journal struct {
+	path     string
+	format   logparser.JournalFormat
+	units    map[string]struct{}
+	settings Settings
+	logger   *logrus.Entry
+}
+
+func NewJournal(path string, format logparser.JournalFormat, units []string, settings Settings) *Journal {
+	s := &Journal{
+		path:     path,
+		format:   format,
+		units:    map[string]struct{}{},
+		settings: settings,
+		logger:   settings.Logger,
+	}
+	for _, unit := range units {
+		s.units[unit] = struct{}{}
+	}
+
+	return s
+}
+
+func (s *Journal) Run(ctx context.Context, out chan<- *logparser.Event) error {
+	input := os.Stdin
+	if s.path != "-" {
+		var err error
+		input, err = os.Open(s.path)
+		if err != nil {
+			s.logger.WithError(err).Error("failed to open journal")
+			return err
+		}
+	}
+
+	// closing the input unblocks the pending read
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		_ = input.Close()
+	}()
+
+	return s.read(ctx, input, out)
+}
+
+func (s *Journal) read(ctx context.Context, input io.Reader, out chan<- *logparser.Event) error {
+	reader, err := logparser.NewJournalReader(input, s.format)
+	if err != nil {
+		return err
+	}
+
+	for {
+		entry, err := reader.Next()
+		if err == io.EOF {
+			s.logger.Info("journal stream finished")
+			return nil
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			s.logger.WithError(err).Error("failed to read journal")
+			return err
+		}
+
+		if _, ok := s.units[entry.Unit()]; len(s.units) > 0 && !ok {
+			continue
+		}
+
+		rec, err := entry.Record()
+		if err != nil {
+			s.logger.WithError(err).Debug("invalid journal entry")
+			continue
+		}
+
+		event, err := s.settings.Parse(rec)
+		if err != nil {
+			s.logger.WithError(err).Debug("unsupported journal entry")
+			continue
+		}
+
+		if !send(ctx, out, event) {
+			return nil
+		}
+	}
+}
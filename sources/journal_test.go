@@ -0,0 +1,41 @@
+package sources
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/logparser"
+	"github.com/sirupsen/logrus"
+)
+
+func TestJournal_Run(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	source := NewJournal("../logparser/testdata/sshd.export", logparser.JournalExport, []string{"ssh.service"},
+		Settings{Name: "journal", Parsers: logparser.Default(), Logger: logrus.NewEntry(logger)})
+
+	out := make(chan *logparser.Event, 10)
+	if err := source.Run(context.Background(), out); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	close(out)
+
+	var got []db.AuthStatus
+	for event := range out {
+		got = append(got, event.Auth.Status)
+	}
+
+	// the sshd.service entry is filtered out by the unit and the systemd one has no parser
+	want := []db.AuthStatus{db.AuthAccepted, db.AuthInvalidUser}
+	if len(got) != len(want) {
+		t.Fatalf("Run() got events %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Run() event #%d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
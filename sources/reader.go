@@ -0,0 +1,77 @@
+package sources
+
+import (
+	"bufio"
+	"context"
+	"io"
+
+	"github.com/sheb-gregor/uwatch/logparser"
+)
+
+// Reader reads syslog lines from the stream like stdin until its end.
+type Reader struct {
+	input    io.Reader
+	headers  *logparser.HeaderParser
+	settings Settings
+}
+
+func NewReader(input io.Reader, headers *logparser.HeaderParser, settings Settings) *Reader {
+	return &Reader{
+		input:    input,
+		headers:  headers,
+		settings: settings,
+	}
+}
+
+func (s *Reader) Run(ctx context.Context, out chan<- *logparser.Event) error {
+	logger := s.settings.Logger
+
+	lines := make(chan string)
+	scanErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(s.input)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-ctx.Done():
+				return
+			}
+		}
+		scanErr <- scanner.Err()
+	}()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				logger.Info("stream finished")
+				select {
+				case err := <-scanErr:
+					return err
+				default:
+					return nil
+				}
+			}
+
+			rec, err := s.headers.Split(line)
+			if err != nil {
+				logger.WithError(err).Debug("invalid log line")
+				continue
+			}
+
+			event, err := s.settings.Parse(rec)
+			if err != nil {
+				logger.WithError(err).Debug("unsupported log line")
+				continue
+			}
+
+			if !send(ctx, out, event) {
+				return nil
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package sources
+
+import (
+	"context"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sheb-gregor/uwatch/logparser"
+	"github.com/sirupsen/logrus"
+)
+
+func TestReader_Run(t *testing.T) {
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	input := strings.NewReader(`Jan  6 14:07:25 web sshd[31215]: Accepted publickey for sheb from 188.163.50.118 port 11087 ssh2
+Jan  6 14:08:21 web sudo: pam_unix(sudo:session): session closed for user root
+garbage
+Jan  6 14:09:25 web sshd[31216]: Failed password for root from 218.92.0.164 port 26493 ssh2
+`)
+
+	parsers, err := logparser.Default().Subset(logparser.ParserSSHd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labels := map[string]string{"container": "web"}
+	source := NewReader(input, logparser.NewHeaderParser(nil, nil),
+		Settings{Name: "stdin", Labels: labels, Parsers: parsers, Logger: logrus.NewEntry(logger)})
+
+	out := make(chan *logparser.Event, 10)
+	if err := source.Run(context.Background(), out); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	close(out)
+
+	var count int
+	for event := range out {
+		count++
+		if event.Source != "stdin" {
+			t.Errorf("Run() event source = %q, want stdin", event.Source)
+		}
+		if !reflect.DeepEqual(event.Auth.Labels, labels) {
+			t.Errorf("Run() event labels = %v, want %v", event.Auth.Labels, labels)
+		}
+	}
+	if count != 2 {
+		t.Errorf("Run() got %d events, want 2", count)
+	}
+}
@@ -0,0 +1,110 @@
+package sources
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// RotatedFiles returns the rotated copies of the log file, like auth.log.2.gz and auth.log.1, oldest first.
+func RotatedFiles(path string) ([]string, error) {
+	dir, base := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	rotatedReg := regexp.MustCompile(`^` + regexp.QuoteMeta(base) + `\.(\d+)(?:\.gz)?$`)
+	type rotated struct {
+		path  string
+		index int
+	}
+
+	var files []rotated
+	for _, info := range infos {
+		matches := rotatedReg.FindStringSubmatch(info.Name())
+		if matches == nil || info.IsDir() {
+			continue
+		}
+		index, _ := strconv.Atoi(matches[1])
+		files = append(files, rotated{path: filepath.Join(dir, info.Name()), index: index})
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].index > files[j].index })
+
+	paths := make([]string, 0, len(files))
+	for _, file := range files {
+		paths = append(paths, file.path)
+	}
+	return paths, nil
+}
+
+type logFile struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (f *logFile) Close() error {
+	var err error
+	for i := len(f.closers) - 1; i >= 0; i-- {
+		if cErr := f.closers[i].Close(); cErr != nil {
+			err = cErr
+		}
+	}
+	return err
+}
+
+// OpenLog opens the plain or gzipped log file.
+func OpenLog(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(file)
+	magic, _ := reader.Peek(2)
+	if len(magic) < 2 || magic[0] != 0x1f || magic[1] != 0x8b {
+		return &logFile{Reader: reader, closers: []io.Closer{file}}, nil
+	}
+
+	gz, err := gzip.NewReader(reader)
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	return &logFile{Reader: gz, closers: []io.Closer{file, gz}}, nil
+}
+
+// FileInode returns the inode of the file, 0 if it is not supported.
+func FileInode(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return fileInode(info), nil
+}
+
+// fileIDSize is the size of the file head used to identify the log content.
+const fileIDSize = 4096
+
+// FileID hashes the head of the decompressed log, it stays the same after the rename and compression.
+func FileID(log io.Reader) (string, error) {
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, log, fileIDSize); err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,101 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/logparser"
+	"github.com/sirupsen/logrus"
+)
+
+// Source reads log records of one origin and turns them into events.
+type Source interface {
+	// Run reads the source until the ctx is done or the source is exhausted
+	// and sends parsed events to the out channel.
+	Run(ctx context.Context, out chan<- *logparser.Event) error
+}
+
+// Settings are common for all sources.
+type Settings struct {
+	Name    string
+	Labels  map[string]string
+	Parsers *logparser.Registry
+	Logger  *logrus.Entry
+}
+
+// Parse passes the record to the parsers and marks the event with the source name and labels.
+func (s Settings) Parse(rec logparser.Record) (*logparser.Event, error) {
+	event, err := s.Parsers.ParseRecord(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.mark(event), nil
+}
+
+// mark sets the source name and labels of the event.
+func (s Settings) mark(event *logparser.Event) *logparser.Event {
+	event.Source = s.Name
+	event.Labels = s.Labels
+	if event.Auth != nil {
+		event.Auth.Host = event.Host
+		event.Auth.Labels = s.Labels
+	}
+	if event.Escalation != nil {
+		event.Escalation.Host = event.Host
+		event.Escalation.Labels = s.Labels
+	}
+	return event
+}
+
+// New creates the source described by the config.
+func New(cfg config.SourceConfig, location *time.Location,
+	storage db.StorageI, logger *logrus.Entry) (Source, error) {
+	parsers := logparser.Default()
+	if len(cfg.Parsers) > 0 {
+		var err error
+		parsers, err = parsers.Subset(cfg.Parsers...)
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %s", cfg.Name, err)
+		}
+	}
+
+	settings := Settings{
+		Name:    cfg.Name,
+		Labels:  cfg.Labels,
+		Parsers: parsers,
+		Logger:  logger.WithField("source", cfg.Name),
+	}
+
+	switch cfg.Type {
+	case config.SourceFile:
+		headers := logparser.NewHeaderParser(location, time.Now)
+		return NewFile(cfg.Path, headers, storage.Positions(), storage.Imports(), settings), nil
+	case config.SourceJournal:
+		return NewJournal(cfg.Path, logparser.JournalFormat(cfg.Format), cfg.Units, settings), nil
+	case config.SourceStdin:
+		headers := logparser.NewHeaderParser(location, time.Now)
+		return NewReader(os.Stdin, headers, settings), nil
+	case config.SourceSyslog:
+		headers := logparser.NewHeaderParser(location, time.Now)
+		return NewSyslog(cfg.Listen, headers, settings)
+	case config.SourceUtmp:
+		return NewUtmp(cfg.Path, logparser.UtmpKind(cfg.Format), storage.Positions(), settings), nil
+	default:
+		return nil, fmt.Errorf("source %s: unknown type %q", cfg.Name, cfg.Type)
+	}
+}
+
+// send passes the event to out unless the ctx is done.
+func send(ctx context.Context, out chan<- *logparser.Event, event *logparser.Event) bool {
+	select {
+	case out <- event:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
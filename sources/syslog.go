@@ -0,0 +1,241 @@
+package sources
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sheb-gregor/uwatch/logparser"
+)
+
+// maxMessageSize limits the syslog message, RFC5424 receivers must accept 2048 bytes at least.
+const maxMessageSize = 64 * 1024
+
+// Syslog receives RFC3164 and RFC5424 messages over UDP, TCP or a unix socket.
+type Syslog struct {
+	network string
+	address string
+	headers *logparser.HeaderParser
+
+	settings Settings
+}
+
+// NewSyslog creates the receiver for the listen address like "udp://:514", "tcp://0.0.0.0:514",
+// "unix:///run/uwatch.sock" or "unixgram:///run/uwatch.sock".
+func NewSyslog(listen string, headers *logparser.HeaderParser, settings Settings) (*Syslog, error) {
+	i := strings.Index(listen, "://")
+	if i < 0 {
+		return nil, fmt.Errorf("invalid syslog listen address %q", listen)
+	}
+
+	network, address := listen[:i], listen[i+3:]
+	switch network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "unix", "unixgram":
+	default:
+		return nil, fmt.Errorf("unsupported syslog network %q", network)
+	}
+
+	return &Syslog{
+		network:  network,
+		address:  address,
+		headers:  headers,
+		settings: settings,
+	}, nil
+}
+
+func (s *Syslog) Run(ctx context.Context, out chan<- *logparser.Event) error {
+	switch s.network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return s.runStream(ctx, out)
+	default:
+		return s.runPacket(ctx, out)
+	}
+}
+
+func (s *Syslog) runPacket(ctx context.Context, out chan<- *logparser.Event) error {
+	logger := s.settings.Logger
+
+	if s.network == "unixgram" {
+		_ = os.Remove(s.address)
+	}
+	conn, err := net.ListenPacket(s.network, s.address)
+	if err != nil {
+		logger.WithError(err).Error("failed to listen syslog")
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		_ = conn.Close()
+	}()
+
+	logger.WithField("addr", conn.LocalAddr().String()).Info("syslog receiver started")
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, peer, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logger.WithError(err).Error("failed to read syslog message")
+			return err
+		}
+
+		if !s.handle(ctx, out, string(buf[:n]), peerHost(peer)) {
+			return nil
+		}
+	}
+}
+
+func (s *Syslog) runStream(ctx context.Context, out chan<- *logparser.Event) error {
+	logger := s.settings.Logger
+
+	if s.network == "unix" {
+		_ = os.Remove(s.address)
+	}
+	listener, err := net.Listen(s.network, s.address)
+	if err != nil {
+		logger.WithError(err).Error("failed to listen syslog")
+		return err
+	}
+
+	wg := sync.WaitGroup{}
+	conns := map[net.Conn]struct{}{}
+	connsMu := sync.Mutex{}
+	go func() {
+		<-ctx.Done()
+		_ = listener.Close()
+
+		connsMu.Lock()
+		for conn := range conns {
+			_ = conn.Close()
+		}
+		connsMu.Unlock()
+	}()
+
+	logger.WithField("addr", listener.Addr().String()).Info("syslog receiver started")
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				wg.Wait()
+				return nil
+			}
+			logger.WithError(err).Error("failed to accept syslog connection")
+			return err
+		}
+
+		connsMu.Lock()
+		conns[conn] = struct{}{}
+		connsMu.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				connsMu.Lock()
+				delete(conns, conn)
+				connsMu.Unlock()
+				_ = conn.Close()
+			}()
+
+			err := s.readStream(ctx, out, conn)
+			if err != nil && ctx.Err() == nil {
+				logger.WithError(err).Debug("syslog connection closed")
+			}
+		}()
+	}
+}
+
+// readStream reads the octet-counted (RFC6587 3.4.1) or newline-delimited messages.
+func (s *Syslog) readStream(ctx context.Context, out chan<- *logparser.Event, conn net.Conn) error {
+	host := peerHost(conn.RemoteAddr())
+	reader := bufio.NewReaderSize(conn, maxMessageSize)
+	for {
+		msg, err := readFrame(reader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !s.handle(ctx, out, msg, host) {
+			return nil
+		}
+	}
+}
+
+var errInvalidFrame = errors.New("invalid syslog frame")
+
+func readFrame(reader *bufio.Reader) (string, error) {
+	first, err := reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+
+	if first[0] < '0' || first[0] > '9' {
+		line, err := reader.ReadString('\n')
+		if err != nil && (err != io.EOF || line == "") {
+			return "", err
+		}
+		return line, nil
+	}
+
+	length, err := reader.ReadString(' ')
+	if err != nil {
+		return "", err
+	}
+	size, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+	if err != nil || size <= 0 || size > maxMessageSize {
+		return "", errInvalidFrame
+	}
+
+	msg := make([]byte, size)
+	if _, err := io.ReadFull(reader, msg); err != nil {
+		return "", err
+	}
+	return string(msg), nil
+}
+
+func (s *Syslog) handle(ctx context.Context, out chan<- *logparser.Event, msg, host string) bool {
+	logger := s.settings.Logger
+
+	rec, err := s.headers.Split(strings.TrimRight(msg, "\r\n\x00"))
+	if err != nil {
+		logger.WithError(err).Debug("invalid syslog message")
+		return true
+	}
+	if rec.Host == "" {
+		rec.Host = host
+	}
+
+	event, err := s.settings.Parse(rec)
+	if err != nil {
+		logger.WithError(err).Debug("unsupported syslog message")
+		return true
+	}
+
+	return send(ctx, out, event)
+}
+
+// peerHost returns the sender IP, unix sockets have no peer host.
+func peerHost(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		return addr.IP.String()
+	case *net.TCPAddr:
+		return addr.IP.String()
+	}
+	return ""
+}
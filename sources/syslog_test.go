@@ -0,0 +1,151 @@
+package sources
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/logparser"
+	"github.com/sirupsen/logrus"
+)
+
+func TestSyslog_Run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	udpPort, err := freeUDPPort()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const (
+		rfc3164 = "<38>Jan  6 14:07:25 web sshd[31215]: Accepted publickey for sheb from 188.163.50.118 port 11087 ssh2"
+		rfc5424 = "<38>1 2020-01-06T14:07:25.003Z - sshd 31216 - - Failed password for root from 218.92.0.164 port 26493 ssh2"
+	)
+
+	tests := []struct {
+		listen   string
+		network  string
+		address  string
+		payloads []string
+		want     []db.AuthStatus
+		wantHost []string
+	}{
+		{
+			listen:   fmt.Sprintf("udp://127.0.0.1:%d", udpPort),
+			network:  "udp",
+			address:  fmt.Sprintf("127.0.0.1:%d", udpPort),
+			payloads: []string{rfc3164, rfc5424},
+			want:     []db.AuthStatus{db.AuthAccepted, db.AuthFailed},
+			wantHost: []string{"web", "127.0.0.1"},
+		},
+		{
+			listen:  "unix://" + filepath.Join(dir, "stream.sock"),
+			network: "unix",
+			address: filepath.Join(dir, "stream.sock"),
+			// newline-delimited and octet-counted frames
+			payloads: []string{rfc3164 + "\n" + fmt.Sprintf("%d %s", len(rfc5424), rfc5424)},
+			want:     []db.AuthStatus{db.AuthAccepted, db.AuthFailed},
+			wantHost: []string{"web", ""},
+		},
+		{
+			listen:   "unixgram://" + filepath.Join(dir, "dgram.sock"),
+			network:  "unixgram",
+			address:  filepath.Join(dir, "dgram.sock"),
+			payloads: []string{rfc3164},
+			want:     []db.AuthStatus{db.AuthAccepted},
+			wantHost: []string{"web"},
+		},
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			source, err := NewSyslog(tt.listen, logparser.NewHeaderParser(time.UTC, nil),
+				Settings{Name: "syslog", Parsers: logparser.Default(), Logger: logrus.NewEntry(logger)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			out := make(chan *logparser.Event)
+			runErr := make(chan error, 1)
+			go func() { runErr <- source.Run(ctx, out) }()
+
+			conn, err := dialRetry(tt.network, tt.address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			for _, payload := range tt.payloads {
+				if _, err := conn.Write([]byte(payload)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.network == "unix" {
+				_ = conn.Close()
+			}
+
+			for j := range tt.want {
+				select {
+				case event := <-out:
+					assertEqual(t, event.Auth.Status, tt.want[j])
+					assertEqual(t, event.Auth.Host, tt.wantHost[j])
+				case <-ctx.Done():
+					t.Fatalf("event #%d not received", j)
+				}
+			}
+
+			cancel()
+			if err := <-runErr; err != nil {
+				t.Errorf("Run() error = %v", err)
+			}
+		})
+	}
+}
+
+func assertEqual(t *testing.T, got, want interface{}) {
+	if got != want {
+		t.Errorf("got = %v, want %v", got, want)
+	}
+}
+
+func freeUDPPort() (int, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
+
+// dialRetry waits for the receiver to start listening.
+func dialRetry(network, address string) (net.Conn, error) {
+	var err error
+	for i := 0; i < 50; i++ {
+		var conn net.Conn
+		if conn, err = net.Dial(network, address); err == nil {
+			if network == "udp" {
+				// udp dial succeeds before the listener is ready
+				time.Sleep(50 * time.Millisecond)
+			}
+			return conn, nil
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	return nil, err
+}
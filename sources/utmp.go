@@ -0,0 +1,149 @@
+package sources
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/logparser"
+)
+
+// Utmp follows the binary login records of wtmp or the bad login records of btmp.
+// Unlike the text logs, the records are kept by the login programs themselves,
+// so they are the authoritative list of the sessions.
+type Utmp struct {
+	path         string
+	kind         logparser.UtmpKind
+	positions    db.PositionStorage
+	settings     Settings
+	pollInterval time.Duration
+}
+
+func NewUtmp(path string, kind logparser.UtmpKind, positions db.PositionStorage, settings Settings) *Utmp {
+	return &Utmp{
+		path:         path,
+		kind:         kind,
+		positions:    positions,
+		settings:     settings,
+		pollInterval: defaultPollInterval,
+	}
+}
+
+func (s *Utmp) Run(ctx context.Context, out chan<- *logparser.Event) error {
+	logger := s.settings.Logger
+
+	pos, err := s.positions.GetPosition(s.path)
+	if err != nil {
+		logger.WithError(err).Error("failed to get saved position")
+		return err
+	}
+
+	file, inode, err := openFile(s.path)
+	if err != nil {
+		logger.WithError(err).Error("failed to open utmp file")
+		return err
+	}
+	defer func() { _ = file.Close() }()
+
+	var offset int64
+	if pos != nil && pos.Inode == inode && pos.Offset <= fileSize(file) {
+		// a partial record may be written at the moment of the shutdown
+		offset = pos.Offset - pos.Offset%logparser.UtmpRecordSize
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	// the records have no host, they are written on this one
+	host, _ := os.Hostname()
+	sessions := logparser.NewUtmpSessions(s.kind)
+	buf := make([]byte, logparser.UtmpRecordSize)
+	for {
+		n, err := io.ReadFull(file, buf)
+		switch {
+		case err == nil:
+			offset += logparser.UtmpRecordSize
+		case err == io.EOF || err == io.ErrUnexpectedEOF:
+			// wait for the rest of the record
+			if _, err := file.Seek(offset, io.SeekStart); err != nil {
+				return err
+			}
+
+			var switched bool
+			if file, inode, offset, switched, err = s.follow(file, inode, offset+int64(n)); err != nil {
+				logger.WithError(err).Error("failed to follow utmp file")
+				return err
+			}
+			if switched {
+				sessions = logparser.NewUtmpSessions(s.kind)
+				continue
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(s.pollInterval):
+			}
+			continue
+		default:
+			logger.WithError(err).Error("failed to read utmp file")
+			return err
+		}
+
+		rec, err := logparser.DecodeUtmp(buf)
+		if err != nil {
+			logger.WithError(err).Debug("invalid utmp record")
+			continue
+		}
+
+		event, err := sessions.Event(rec)
+		if err != nil {
+			logger.WithField("ut_type", rec.Type).WithField("line", rec.Line).
+				Debug("unsupported utmp record")
+			continue
+		}
+
+		event.Host = host
+		event.Position = &db.FilePosition{Path: s.path, Inode: inode, Offset: offset, UpdatedAt: time.Now()}
+		if !send(ctx, out, s.settings.mark(event)) {
+			return nil
+		}
+	}
+}
+
+// follow switches to the new file after the rotation, or to the beginning after the truncation.
+// The read offset includes the partial record.
+func (s *Utmp) follow(file *os.File, inode uint64, read int64) (*os.File, uint64, int64, bool, error) {
+	offset := read - read%logparser.UtmpRecordSize
+
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		return file, inode, offset, false, nil
+	}
+	if err != nil {
+		return file, inode, offset, false, err
+	}
+
+	if newInode := fileInode(info); newInode != inode {
+		newFile, newInode, err := openFile(s.path)
+		if err != nil {
+			return file, inode, offset, false, err
+		}
+
+		s.settings.Logger.Info("utmp file rotated, follow the new one")
+		_ = file.Close()
+		return newFile, newInode, 0, true, nil
+	}
+
+	if info.Size() < read {
+		s.settings.Logger.Info("utmp file truncated, read from the beginning")
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return file, inode, offset, false, err
+		}
+		return file, inode, 0, true, nil
+	}
+
+	return file, inode, offset, false, nil
+}
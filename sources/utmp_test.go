@@ -0,0 +1,108 @@
+package sources
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/logparser"
+	"github.com/sirupsen/logrus"
+)
+
+type memPositions map[string]db.FilePosition
+
+func (m memPositions) GetPosition(path string) (*db.FilePosition, error) {
+	pos, ok := m[path]
+	if !ok {
+		return nil, nil
+	}
+	return &pos, nil
+}
+
+func (m memPositions) SavePosition(pos db.FilePosition) error {
+	m[pos.Path] = pos
+	return nil
+}
+
+func appendUtmp(t *testing.T, path string, records ...logparser.UtmpRecord) {
+	data := make([]byte, 0, len(records)*logparser.UtmpRecordSize)
+	for _, rec := range records {
+		data = append(data, logparser.EncodeUtmp(rec)...)
+	}
+	appendFile(t, path, string(data))
+}
+
+func TestUtmp_Run(t *testing.T) {
+	dir, err := ioutil.TempDir("", "uwatch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wtmp")
+
+	login := time.Unix(1578319645, 0)
+	addr := net.ParseIP("188.163.50.118")
+	appendUtmp(t, path,
+		logparser.UtmpRecord{Type: 2, Line: "~", User: "reboot", Time: login},
+		logparser.UtmpRecord{Type: logparser.UtmpUserProcess, Line: "pts/0", User: "sheb", Time: login, Addr: addr},
+	)
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	positions := memPositions{}
+	run := func(want ...db.AuthStatus) {
+		source := NewUtmp(path, logparser.UtmpWtmp, positions,
+			Settings{Name: "wtmp", Labels: map[string]string{"host": "main"}, Logger: logrus.NewEntry(logger)})
+		source.pollInterval = time.Millisecond
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		out := make(chan *logparser.Event)
+		runErr := make(chan error, 1)
+		go func() { runErr <- source.Run(ctx, out) }()
+
+		for i, status := range want {
+			select {
+			case event := <-out:
+				assertEqual(t, event.Auth.Status, status)
+				assertEqual(t, event.Auth.Username, "sheb")
+				assertEqual(t, event.Auth.TTY, "pts/0")
+				assertEqual(t, event.Auth.RemoteAddr.Equal(addr), true)
+				assertEqual(t, event.Source, "wtmp")
+				assertEqual(t, event.Auth.Labels["host"], "main")
+				_ = positions.SavePosition(*event.Position)
+			case <-ctx.Done():
+				t.Fatalf("event #%d not received", i)
+			}
+
+			if i == 0 && len(want) > 1 {
+				// the partial record is read once completed
+				rec := logparser.EncodeUtmp(logparser.UtmpRecord{
+					Type: logparser.UtmpDeadProcess, Line: "pts/0", Time: login.Add(time.Minute)})
+				appendFile(t, path, string(rec[:100]))
+				time.Sleep(10 * time.Millisecond)
+				appendFile(t, path, string(rec[100:]))
+			}
+		}
+
+		cancel()
+		if err := <-runErr; err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	}
+
+	run(db.AuthSessionOpened, db.AuthSessionClosed)
+	assertEqual(t, positions[path].Offset, int64(3*logparser.UtmpRecordSize))
+
+	// resume from the saved position
+	appendUtmp(t, path,
+		logparser.UtmpRecord{Type: logparser.UtmpUserProcess, Line: "pts/0", User: "sheb", Time: login, Addr: addr})
+	run(db.AuthSessionOpened)
+	assertEqual(t, positions[path].Offset, int64(4*logparser.UtmpRecordSize))
+}
@@ -0,0 +1,76 @@
+package workers
+
+import (
+	"time"
+
+	"github.com/lancer-kit/uwe/v2"
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sirupsen/logrus"
+)
+
+// Backup periodically writes the backups of the storage while it is in use.
+type Backup struct {
+	config  config.BackupConfig
+	storage db.StorageI
+	logger  *logrus.Entry
+}
+
+func NewBackup(config config.BackupConfig, storage db.StorageI, logger *logrus.Entry) *Backup {
+	return &Backup{
+		config:  config,
+		storage: storage,
+		logger: logger.
+			WithField("appLayer", "workers").
+			WithField("worker", WBackup)}
+}
+
+func (w *Backup) Init() error {
+	return nil
+}
+
+func (w *Backup) Run(ctx uwe.Context) error {
+	// the schedule survives the restarts, the first backup is due an interval after the latest one
+	latest, err := db.LatestBackup(w.config.Dir)
+	if err != nil {
+		w.logger.WithError(err).Error("failed to find the latest backup")
+	}
+	timer := time.NewTimer(nextBackup(latest, w.config.Interval.Duration, time.Now()))
+	defer timer.Stop()
+
+	w.logger.Info("start backup loop")
+	for {
+		select {
+		case <-timer.C:
+			w.backup()
+			timer.Reset(w.config.Interval.Duration)
+		case <-ctx.Done():
+			w.logger.Info("finish backup loop")
+			return nil
+		}
+	}
+}
+
+func (w *Backup) backup() {
+	report, err := db.Backup(w.storage, w.config.Dir, db.BackupOptions{Gzip: w.config.Gzip, Keep: w.config.Keep})
+	if err != nil {
+		w.logger.WithError(err).Error("failed to back up storage")
+		return
+	}
+
+	w.logger.WithFields(logrus.Fields{
+		"path":    report.Path,
+		"files":   report.Files,
+		"size":    report.Size,
+		"removed": report.Removed,
+	}).Info("backup finished")
+}
+
+// nextBackup returns the delay of the backup after the latest one, zero if it is overdue.
+func nextBackup(latest time.Time, interval time.Duration, now time.Time) time.Duration {
+	delay := latest.Add(interval).Sub(now)
+	if delay < 0 {
+		return 0
+	}
+	return delay
+}
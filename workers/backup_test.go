@@ -0,0 +1,27 @@
+package workers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNextBackup(t *testing.T) {
+	now := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	tests := []struct {
+		latest time.Time
+		want   time.Duration
+	}{
+		{latest: time.Time{}, want: 0},
+		{latest: now.Add(-25 * time.Hour), want: 0},
+		{latest: now.Add(-time.Hour), want: 23 * time.Hour},
+		{latest: now, want: 24 * time.Hour},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			if got := nextBackup(tt.latest, 24*time.Hour, now); got != tt.want {
+				t.Errorf("nextBackup() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
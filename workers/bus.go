@@ -72,6 +72,7 @@ func NewEventHub(defaultChanLen int) *EventHub {
 	return &EventHub{
 		ctx:             ctx,
 		cancelFunc:      cancel,
+		defaultChanLen:  defaultChanLen,
 		workersHub:      map[uwe.WorkerName]chan<- *Message{},
 		workersMessages: make(chan *Message, defaultChanLen)}
 }
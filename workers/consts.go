@@ -6,4 +6,8 @@ const (
 	WWatcher uwe.WorkerName = "watcher"
 	WTGBot   uwe.WorkerName = "tg_bot"
 	WHub     uwe.WorkerName = "hub"
+
+	WRetention uwe.WorkerName = "retention"
+	WBackup    uwe.WorkerName = "backup"
+	WDetection uwe.WorkerName = "detection"
 )
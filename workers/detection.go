@@ -0,0 +1,125 @@
+package workers
+
+import (
+	"github.com/lancer-kit/uwe/v2"
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/detect"
+	"github.com/sirupsen/logrus"
+)
+
+// alertsQueueLen is the number of the alerts waiting for the delivery, the alerts above it are dropped.
+const alertsQueueLen = 100
+
+// Detection runs the detectors on the auth events of the watcher and sends the alerts to the bots.
+// The watcher sends all events, the failures ignored by the config are detected too.
+type Detection struct {
+	detectors []detect.Detector
+	hubBus    EventBus
+	logger    *logrus.Entry
+	// alerts are sent to the hub apart from the detection loop,
+	// the hub may wait for the loop to take the next event.
+	alerts chan detect.Alert
+}
+
+// NewDetection returns the error of the invalid rule expression.
+func NewDetection(config config.DetectionConfig, storage db.StorageI, hubBus EventBus, logger *logrus.Entry) (*Detection, error) {
+	w := &Detection{
+		hubBus: hubBus,
+		alerts: make(chan detect.Alert, alertsQueueLen),
+		logger: logger.
+			WithField("appLayer", "workers").
+			WithField("worker", WDetection)}
+
+	if config.BruteForce != nil {
+		w.detectors = append(w.detectors, detect.NewBruteForce(*config.BruteForce))
+	}
+	if config.ImpossibleTravel != nil {
+		w.detectors = append(w.detectors, detect.NewImpossibleTravel(*config.ImpossibleTravel, storage.Auth()))
+	}
+	if len(config.Rules) > 0 {
+		rules, err := detect.NewRules(config.Rules, storage.Auth())
+		if err != nil {
+			return nil, err
+		}
+		w.detectors = append(w.detectors, rules)
+	}
+	return w, nil
+}
+
+func (w *Detection) Init() error {
+	return nil
+}
+
+func (w *Detection) Run(ctx uwe.Context) error {
+	w.logger.Info("start detection loop")
+	go w.deliver(ctx)
+	for {
+		select {
+		case msg := <-w.hubBus.MessageBus():
+			info, ok := msg.Data.(db.AuthInfo)
+			if !ok || msg.Sender != WWatcher {
+				continue
+			}
+			for _, alert := range w.observe(info) {
+				if sendsTo(alert, config.ChannelTG) {
+					w.enqueue(alert)
+				}
+			}
+		case <-ctx.Done():
+			w.logger.Info("finish detection loop")
+			return nil
+		}
+	}
+}
+
+// enqueue never blocks the detection loop, the alert is dropped if the delivery is behind.
+func (w *Detection) enqueue(alert detect.Alert) {
+	select {
+	case w.alerts <- alert:
+	default:
+		w.logger.WithField("kind", alert.Kind).WithField("key", alert.Key).
+			Warn("alert is dropped, the delivery queue is full")
+	}
+}
+
+func (w *Detection) deliver(ctx uwe.Context) {
+	for {
+		select {
+		case alert := <-w.alerts:
+			_ = w.hubBus.SendMessage(WTGBot, alert)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (w *Detection) observe(info db.AuthInfo) (alerts []detect.Alert) {
+	for _, detector := range w.detectors {
+		for _, alert := range detector.Observe(info) {
+			w.logger.WithFields(logrus.Fields{
+				"kind":     alert.Kind,
+				"scope":    alert.Scope,
+				"key":      alert.Key,
+				"count":    alert.Count,
+				"rule":     alert.Rule,
+				"severity": alert.Severity,
+			}).Warn("attack detected")
+			alerts = append(alerts, alert)
+		}
+	}
+	return alerts
+}
+
+// sendsTo reports whether the alert goes to the channel, the alerts without the channels go to tg.
+func sendsTo(alert detect.Alert, channel config.Channel) bool {
+	if len(alert.Channels) == 0 {
+		return channel == config.ChannelTG
+	}
+	for _, c := range alert.Channels {
+		if c == channel {
+			return true
+		}
+	}
+	return false
+}
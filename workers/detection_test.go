@@ -0,0 +1,72 @@
+package workers
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sirupsen/logrus"
+)
+
+func TestDetection_alertsBurst(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	hub := NewEventHub(1)
+	watcherBus := hub.AddWorker(WWatcher)
+	detectionBus := hub.AddWorker(WDetection)
+	tgBus := hub.AddWorker(WTGBot)
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	cfg := config.DetectionConfig{Rules: []config.RuleConfig{{Name: "failed", When: `status == "Failed"`}}}
+	detection, err := NewDetection(cfg, db.NewMemoryStorage(), detectionBus, logrus.NewEntry(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() { _ = hub.Run(ctx) }()
+	go func() { _ = detection.Run(ctx) }()
+
+	// the bot takes the alerts slower than the watcher sends the events
+	alerts := make(chan struct{}, 1)
+	go func() {
+		for {
+			select {
+			case <-tgBus.MessageBus():
+				time.Sleep(time.Millisecond)
+				select {
+				case alerts <- struct{}{}:
+				default:
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// every failure is the alert, the burst is above the delivery queue
+	sent := make(chan struct{})
+	go func() {
+		for i := 0; i < 3*alertsQueueLen; i++ {
+			_ = watcherBus.SendMessage(WDetection, db.AuthInfo{Status: db.AuthFailed, Username: "root",
+				RemoteAddr: net.ParseIP("10.0.0.1"), Date: time.Now()})
+		}
+		close(sent)
+	}()
+
+	select {
+	case <-sent:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the watcher is blocked by the detection alerts")
+	}
+	select {
+	case <-alerts:
+	case <-time.After(5 * time.Second):
+		t.Error("the bot got no alerts")
+	}
+}
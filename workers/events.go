@@ -0,0 +1,53 @@
+package workers
+
+import (
+	"fmt"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/detect"
+	"github.com/sheb-gregor/uwatch/logparser"
+)
+
+// StoreEvent saves the parsed event and returns the message for the notifiers,
+// nil if the event is filtered out by the config.
+func StoreEvent(storage db.StorageI, cfg config.Config, event *logparser.Event) (interface{}, error) {
+	switch event.Type {
+	case logparser.EventAuth:
+		if event.Auth.Status.IsFailure() && cfg.IgnoreFails {
+			return nil, nil
+		}
+
+		// the login is compared with the sessions before it
+		var sessions []db.Session
+		accepted := event.Auth.Status == db.AuthAccepted
+		if accepted {
+			var err error
+			if sessions, err = storage.Auth().GetUserSessions(event.Auth.Username); err != nil {
+				return nil, err
+			}
+		}
+
+		session, err := storage.Auth().UpsetAuthEvent(*event.Auth)
+		if err == db.ErrSessionNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if accepted {
+			firstSeen := detect.FirstSeen(sessions, *event.Auth)
+			session.FirstSeen = &firstSeen
+		}
+		return session, nil
+	case logparser.EventEscalation:
+		// failed escalations are stored and reported regardless of IgnoreFails
+		escalation, err := storage.Auth().AddEscalation(*event.Escalation)
+		if err != nil {
+			return nil, err
+		}
+		return escalation, nil
+	default:
+		return nil, fmt.Errorf("unsupported event type %q", event.Type)
+	}
+}
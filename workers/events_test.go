@@ -0,0 +1,111 @@
+package workers
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/logparser"
+)
+
+func TestStoreEvent(t *testing.T) {
+	date := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	addr := net.ParseIP("10.0.0.1")
+
+	tests := []struct {
+		ignoreFails bool
+		event       *logparser.Event
+		want        interface{}
+		wantEvents  int
+	}{
+		{
+			event: &logparser.Event{Type: logparser.EventAuth, Auth: &db.AuthInfo{Status: db.AuthAccepted,
+				Username: "sheb", AuthMethod: "publickey", RemoteAddr: addr, PID: 100, Date: date}},
+			want:       db.AuthAccepted,
+			wantEvents: 1,
+		},
+		{
+			ignoreFails: true,
+			event: &logparser.Event{Type: logparser.EventAuth, Auth: &db.AuthInfo{Status: db.AuthFailed,
+				Username: "sheb", AuthMethod: "password", RemoteAddr: addr, Date: date}},
+			want: nil,
+		},
+		{
+			event: &logparser.Event{Type: logparser.EventAuth, Auth: &db.AuthInfo{Status: db.AuthFailed,
+				Username: "sheb", AuthMethod: "password", RemoteAddr: addr, Date: date}},
+			want:       db.AuthFailed,
+			wantEvents: 1,
+		},
+		{
+			ignoreFails: true,
+			event: &logparser.Event{Type: logparser.EventEscalation, Escalation: &db.Escalation{Tool: "sudo",
+				Status: db.EscalationFailed, Username: "sheb", TargetUser: "root", Command: "/bin/sh", Date: date}},
+			want: db.EscalationFailed,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			storage := db.NewMemoryStorage()
+			got, err := StoreEvent(storage, config.Config{IgnoreFails: tt.ignoreFails}, tt.event)
+			if err != nil {
+				t.Fatalf("StoreEvent() error = %v", err)
+			}
+
+			var status interface{}
+			switch msg := got.(type) {
+			case db.Session:
+				status = msg.Status
+			case db.Escalation:
+				status = msg.Status
+			}
+			if status != tt.want {
+				t.Errorf("StoreEvent() got = %v, want %v", status, tt.want)
+			}
+
+			page, err := storage.Auth().Events(db.EventFilter{})
+			if err != nil || len(page.Events) != tt.wantEvents {
+				t.Errorf("Events() got = %+v, error = %v, want %d events", page.Events, err, tt.wantEvents)
+			}
+		})
+	}
+}
+
+func TestStoreEvent_firstSeen(t *testing.T) {
+	date := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	event := func(status db.AuthStatus, method, addr string, pid int) *logparser.Event {
+		return &logparser.Event{Type: logparser.EventAuth, Auth: &db.AuthInfo{Status: status, Username: "sheb",
+			AuthMethod: method, RemoteAddr: net.ParseIP(addr), PID: pid, Date: date}}
+	}
+
+	tests := []struct {
+		event *logparser.Event
+		want  *db.FirstSeen
+	}{
+		{event: event(db.AuthFailed, "password", "10.0.0.2", 0)},
+		{
+			event: event(db.AuthAccepted, "publickey", "10.0.0.1", 100),
+			want:  &db.FirstSeen{IP: true, Subnet: true, AuthMethod: true},
+		},
+		{event: event(db.AuthDisconnected, "publickey", "10.0.0.1", 100)},
+		{event: event(db.AuthAccepted, "publickey", "10.0.0.1", 200), want: &db.FirstSeen{}},
+		// the failed attempt before does not make the address known
+		{event: event(db.AuthAccepted, "password", "10.0.0.2", 300), want: &db.FirstSeen{IP: true, AuthMethod: true}},
+	}
+
+	storage := db.NewMemoryStorage()
+	for i, tt := range tests {
+		got, err := StoreEvent(storage, config.Config{}, tt.event)
+		if err != nil {
+			t.Fatalf("#%d StoreEvent() error = %v", i+1, err)
+		}
+		session := got.(db.Session)
+		if !reflect.DeepEqual(session.FirstSeen, tt.want) {
+			t.Errorf("#%d StoreEvent() first seen got = %+v, want %+v", i+1, session.FirstSeen, tt.want)
+		}
+	}
+}
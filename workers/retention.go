@@ -0,0 +1,76 @@
+package workers
+
+import (
+	"time"
+
+	"github.com/lancer-kit/uwe/v2"
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sirupsen/logrus"
+)
+
+// Retention periodically removes the records expired by the retention config.
+type Retention struct {
+	config  config.RetentionConfig
+	storage db.StorageI
+	logger  *logrus.Entry
+}
+
+func NewRetention(config config.RetentionConfig, storage db.StorageI, logger *logrus.Entry) *Retention {
+	return &Retention{
+		config:  config,
+		storage: storage,
+		logger: logger.
+			WithField("appLayer", "workers").
+			WithField("worker", WRetention)}
+}
+
+func (w *Retention) Init() error {
+	return nil
+}
+
+func (w *Retention) Run(ctx uwe.Context) error {
+	ticker := time.NewTicker(w.config.Interval.Duration)
+	defer ticker.Stop()
+
+	w.logger.Info("start pruning loop")
+	w.prune()
+	for {
+		select {
+		case <-ticker.C:
+			w.prune()
+		case <-ctx.Done():
+			w.logger.Info("finish pruning loop")
+			return nil
+		}
+	}
+}
+
+func (w *Retention) prune() {
+	report, err := w.storage.Auth().Prune(RetentionPolicy(w.config, time.Now()))
+	if err != nil {
+		w.logger.WithError(err).Error("failed to prune storage")
+		return
+	}
+
+	w.logger.WithFields(logrus.Fields{
+		"users":       report.Users,
+		"sessions":    report.Sessions,
+		"connections": report.Connections,
+		"keys":        report.Keys,
+		"escalations": report.Escalations,
+		"events":      report.Events,
+	}).Infof("pruned %d records", report.Total())
+}
+
+// RetentionPolicy returns the cutoffs of the config at the time.
+func RetentionPolicy(cfg config.RetentionConfig, now time.Time) db.RetentionPolicy {
+	policy := db.RetentionPolicy{}
+	if cfg.Failed.Duration > 0 {
+		policy.FailedBefore = now.Add(-cfg.Failed.Duration)
+	}
+	if cfg.Accepted.Duration > 0 {
+		policy.AcceptedBefore = now.Add(-cfg.Accepted.Duration)
+	}
+	return policy
+}
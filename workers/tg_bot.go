@@ -3,11 +3,13 @@ package workers
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api"
 	"github.com/lancer-kit/uwe/v2"
 	"github.com/sheb-gregor/uwatch/config"
 	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/detect"
 	"github.com/sirupsen/logrus"
 )
 
@@ -78,50 +80,43 @@ func (tg *TgBot) Run(ctx uwe.Context) error {
 	for {
 		select {
 		case msg := <-tg.hubBus.MessageBus():
-			if msg.Sender != WWatcher {
+			if msg.Sender != WWatcher && msg.Sender != WDetection {
 				continue
 			}
 
 			tg.logger.WithField("msg_data", fmt.Sprintf("%+v", msg.Data)).
 				Debug("got new msg")
 
-			session, ok := msg.Data.(db.Session)
-			if !ok {
+			var text string
+			silent := false
+			switch data := msg.Data.(type) {
+			case db.Session:
+				// the first seen logins are the high priority ones
+				if routine := data.FirstSeen == nil || !data.FirstSeen.IsNew(); routine {
+					if tg.config.RoutineLogins == config.RoutineSkip {
+						continue
+					}
+					silent = tg.config.RoutineLogins != config.RoutineNotify
+				}
+				text = tg.sessionText(data)
+			case db.Escalation:
+				if data.Status == db.EscalationClosed {
+					continue
+				}
+				text = tg.escalationText(data)
+			case detect.Alert:
+				text = tg.alertText(data)
+				silent = data.Severity == config.SeverityInfo
+			default:
 				tg.logger.WithField("msg_data_type", fmt.Sprintf("%T", msg.Data)).
-					Debug("incoming msg not a db.Session")
+					Debug("incoming msg not supported")
 				continue
 			}
-
-			if session.Status != db.AuthAccepted {
-				continue
-			}
-
-			rawSession, err := json.MarshalIndent(session, "", "  ")
-			if err != nil {
-				tg.logger.WithError(err).Error("unable to MarshalIndent session")
+			if text == "" {
 				continue
 			}
 
-			for user, info := range tg.users {
-				if info.Muted {
-					continue
-				}
-
-				text := fmt.Sprintf(
-					"Hi, %s!\n\nWe got new accepted auth at server!\n\nHere details:\n\n```\n%s\n```\n\n",
-					user,
-					string(rawSession),
-				)
-				msg := tgbotapi.NewMessage(info.ChatID, text)
-				if _, err := tg.bot.Send(msg); err != nil {
-					tg.logger.
-						WithError(err).
-						WithField("user", user).
-						Error("unable to send message to user")
-					continue
-				}
-
-			}
+			tg.broadcast(text, silent)
 
 		case update := <-updates:
 			tg.logger.
@@ -143,6 +138,126 @@ func (tg *TgBot) Run(ctx uwe.Context) error {
 	}
 }
 
+// broadcast sends the text with the greeting to all users which are not muted,
+// the silent messages come without the notification sound.
+func (tg *TgBot) broadcast(text string, silent bool) {
+	for user, info := range tg.users {
+		if info.Muted {
+			continue
+		}
+
+		msg := tgbotapi.NewMessage(info.ChatID, fmt.Sprintf("Hi, %s!\n\n%s", user, text))
+		msg.DisableNotification = silent
+		if _, err := tg.bot.Send(msg); err != nil {
+			tg.logger.
+				WithError(err).
+				WithField("user", user).
+				Error("unable to send message to user")
+			continue
+		}
+	}
+}
+
+func (tg *TgBot) sessionText(session db.Session) string {
+	rawSession, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		tg.logger.WithError(err).Error("unable to MarshalIndent session")
+		return ""
+	}
+
+	from := session.RemoteHostPort()
+	if session.Geo != nil {
+		from = fmt.Sprintf("%s (%s)", from, session.Geo)
+	}
+
+	headline := fmt.Sprintf("We got new accepted auth at server from %s!", from)
+	if session.Status != db.AuthAccepted {
+		headline = fmt.Sprintf("We got %s auth event at server from %s!", session.Status, from)
+	}
+	if session.FirstSeen != nil && session.FirstSeen.IsNew() {
+		var firstSeen []string
+		if session.FirstSeen.IP {
+			firstSeen = append(firstSeen, "address")
+		}
+		if session.FirstSeen.Subnet {
+			firstSeen = append(firstSeen, "network")
+		}
+		if session.FirstSeen.AuthMethod {
+			firstSeen = append(firstSeen, "auth method")
+		}
+		headline = fmt.Sprintf("First seen login of %s from %s, new %s!",
+			session.Username, from, strings.Join(firstSeen, ", "))
+	}
+
+	return fmt.Sprintf(
+		"%s\n\nHere details:\n\n```\n%s\n```\n\n",
+		headline,
+		string(rawSession),
+	)
+}
+
+func (tg *TgBot) escalationText(escalation db.Escalation) string {
+	rawEscalation, err := json.MarshalIndent(escalation, "", "  ")
+	if err != nil {
+		tg.logger.WithError(err).Error("unable to MarshalIndent escalation")
+		return ""
+	}
+
+	action := "became"
+	if escalation.Status.IsFailure() {
+		action = "failed to become"
+	}
+
+	return fmt.Sprintf(
+		"User %s %s %s by %s at server!\n\nHere details:\n\n```\n%s\n```\n\n",
+		escalation.Username,
+		action,
+		escalation.TargetUser,
+		escalation.Tool,
+		string(rawEscalation),
+	)
+}
+
+func (tg *TgBot) alertText(alert detect.Alert) string {
+	rawAlert, err := json.MarshalIndent(alert, "", "  ")
+	if err != nil {
+		tg.logger.WithError(err).Error("unable to MarshalIndent alert")
+		return ""
+	}
+
+	var headline string
+	switch alert.Kind {
+	case detect.AlertImpossibleTravel:
+		headline = fmt.Sprintf("Impossible travel of %s: %.0f km from %s to %s in %s, %.0f km/h!",
+			alert.Key, alert.Distance, travelPlace(alert.Sessions[0]), travelPlace(alert.Sessions[1]),
+			alert.Date.Sub(alert.Since), alert.Speed)
+	case detect.AlertRule:
+		headline = fmt.Sprintf("[%s] Rule %s matched: %s of %s", alert.Severity, alert.Rule,
+			alert.Event.Status, alert.Event.Username)
+		if len(alert.Event.RemoteAddr) != 0 {
+			headline += " from " + alert.Event.RemoteAddr.String()
+		}
+		headline += "!"
+	default:
+		headline = fmt.Sprintf("Brute force detected: %d failed attempts by %s %s in %s!",
+			alert.Count, alert.Scope, alert.Key, alert.Window)
+	}
+
+	return fmt.Sprintf(
+		"%s\n\nHere details:\n\n```\n%s\n```\n\n",
+		headline,
+		string(rawAlert),
+	)
+}
+
+// travelPlace returns the address of the session with the location.
+func travelPlace(session db.Session) string {
+	if session.Geo == nil {
+		return session.RemoteAddr.String()
+	}
+	return fmt.Sprintf("%s (%s)", session.RemoteAddr, session.Geo)
+}
+
 func (tg *TgBot) verifyAuth(update tgbotapi.Update) bool {
 	if _, ok := tg.users[update.Message.From.UserName]; ok {
 		return true
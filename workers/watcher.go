@@ -1,11 +1,15 @@
 package workers
 
 import (
-	"github.com/hpcloud/tail"
+	"context"
+
 	"github.com/lancer-kit/uwe/v2"
 	"github.com/sheb-gregor/uwatch/config"
 	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/detect"
+	"github.com/sheb-gregor/uwatch/geoip"
 	"github.com/sheb-gregor/uwatch/logparser"
+	"github.com/sheb-gregor/uwatch/sources"
 	"github.com/sirupsen/logrus"
 )
 
@@ -13,64 +17,137 @@ type Watcher struct {
 	config  config.Config
 	hubBus  EventBus
 	storage db.StorageI
-	logger  *logrus.Entry
+	sources []sources.Source
+	geo     *geoip.Resolver
+	// sessions is the rule of the session notifications, nil without the bot.
+	sessions *detect.Rules
+	logger   *logrus.Entry
 }
 
-func NewWatcher(config config.Config, storage db.StorageI, hubBus EventBus, logger *logrus.Entry) *Watcher {
-	return &Watcher{
+// NewWatcher returns the error of the invalid rule of the session notifications.
+func NewWatcher(config config.Config, storage db.StorageI, hubBus EventBus, logger *logrus.Entry) (*Watcher, error) {
+	w := &Watcher{
 		config:  config,
 		storage: storage,
 		hubBus:  hubBus,
 		logger: logger.
 			WithField("appLayer", "workers").
 			WithField("worker", WWatcher)}
+
+	if config.TG != nil {
+		sessions, err := sessionsRule(*config.TG, storage.Auth())
+		if err != nil {
+			return nil, err
+		}
+		w.sessions = sessions
+	}
+	return w, nil
+}
+
+func sessionsRule(cfg config.TGConfig, auth db.AuthStorage) (*detect.Rules, error) {
+	return detect.NewRules([]config.RuleConfig{{Name: "sessions", When: cfg.Sessions}}, auth)
 }
 
 func (w *Watcher) Init() error {
+	if w.config.GeoIP != nil {
+		geo, err := geoip.Open(*w.config.GeoIP)
+		if err != nil {
+			w.logger.WithError(err).Error("failed to open geoip databases")
+			return err
+		}
+		w.geo = geo
+	}
+
+	w.sources = w.sources[:0]
+	for _, cfg := range w.config.Sources {
+		source, err := sources.New(cfg, w.config.Location, w.storage, w.logger)
+		if err != nil {
+			w.logger.WithError(err).Error("failed to init source")
+			return err
+		}
+
+		w.sources = append(w.sources, source)
+	}
+
 	return nil
 }
 
 func (w *Watcher) Run(ctx uwe.Context) error {
-	t, err := tail.TailFile(w.config.AuthLog, tail.Config{
-		Location:  &tail.SeekInfo{Offset: 0, Whence: 0},
-		MustExist: true, Follow: true, ReOpen: true})
-	if err != nil {
-		w.logger.WithError(err).Error("failed to tail auth log")
-		return err
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// the restarted worker opens the databases again in Init
+	defer func() {
+		if err := w.geo.Close(); err != nil {
+			w.logger.WithError(err).Warn("failed to close geoip databases")
+		}
+		w.geo = nil
+	}()
+
+	events := make(chan *logparser.Event)
+	sourceErr := make(chan error, len(w.sources))
+	for _, source := range w.sources {
+		go func(source sources.Source) {
+			sourceErr <- source.Run(runCtx, events)
+		}(source)
 	}
 
 	w.logger.Info("start event loop")
+	running := len(w.sources)
 	for {
 		select {
 		case <-w.hubBus.MessageBus():
-		case line := <-t.Lines:
-			if line == nil {
-				continue
-			}
-			w.logger.Debug("new auth log line")
-
-			authInfo, err := logparser.ParseLine(line.Text)
-			if err != nil {
-				w.logger.WithError(err).Debug("invalid auth log line")
-				continue
-			}
-
-			if authInfo.Status == db.AuthFailed && w.config.IgnoreFails {
-				continue
-			}
-
-			session, err := w.storage.Auth().UpsetAuthEvent(*authInfo)
+		case event := <-events:
+			w.handleEvent(event)
+			w.savePosition(event)
+		case err := <-sourceErr:
 			if err != nil {
-				w.logger.WithError(err).Error("UpsetAuthEvent failed")
-				continue
+				return err
 			}
-
-			_ = w.hubBus.SendMessage(WTGBot, session)
-			w.logger.Debug("broadcast session to bots")
+			// exhausted sources are not restarted, the worker stays alive until the shutdown
+			running--
+			w.logger.WithField("running_sources", running).Info("source finished")
 		case <-ctx.Done():
 			w.logger.Info("finish event loop")
 			return nil
 		}
+	}
+}
+
+func (w *Watcher) handleEvent(event *logparser.Event) {
+	if event.Type == logparser.EventAuth {
+		if err := w.geo.Enrich(event.Auth); err != nil {
+			w.logger.WithError(err).Warn("failed to look up geoip")
+		}
+	}
+	if event.Type == logparser.EventAuth && w.config.Detection != nil {
+		_ = w.hubBus.SendMessage(WDetection, *event.Auth)
+	}
+	// the rule sees all events, the failures ignored by the config are counted too
+	notify := event.Type != logparser.EventAuth || w.sessions != nil && len(w.sessions.Observe(*event.Auth)) > 0
+
+	msg, err := StoreEvent(w.storage, w.config, event)
+	if err != nil {
+		w.logger.WithError(err).
+			WithField("event_type", event.Type).
+			Error("failed to store event")
+		return
+	}
+	if msg == nil || !notify {
+		return
+	}
+
+	_ = w.hubBus.SendMessage(WTGBot, msg)
+	w.logger.Debug("broadcast session to bots")
+}
+
+// savePosition persists the source position once the event is processed,
+// so the restarted watcher does not replay it.
+func (w *Watcher) savePosition(event *logparser.Event) {
+	if event.Position == nil {
+		return
+	}
 
+	if err := w.storage.Positions().SavePosition(*event.Position); err != nil {
+		w.logger.WithError(err).Error("SavePosition failed")
 	}
 }
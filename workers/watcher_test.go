@@ -0,0 +1,105 @@
+package workers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/lancer-kit/uwe/v2"
+	"github.com/sheb-gregor/uwatch/config"
+	"github.com/sheb-gregor/uwatch/db"
+	"github.com/sheb-gregor/uwatch/logparser"
+	"github.com/sirupsen/logrus"
+)
+
+// recordingBus keeps the sent messages.
+type recordingBus struct {
+	context.Context
+	sent []interface{}
+}
+
+func (b *recordingBus) SendMessage(_ uwe.WorkerName, data interface{}) error {
+	b.sent = append(b.sent, data)
+	return nil
+}
+
+func (b *recordingBus) MessageBus() <-chan *Message {
+	return nil
+}
+
+func TestWatcher_handleEvent(t *testing.T) {
+	date := time.Date(2020, 1, 6, 14, 0, 0, 0, time.UTC)
+	auth := func(status db.AuthStatus, username string) *logparser.Event {
+		return &logparser.Event{Type: logparser.EventAuth, Auth: &db.AuthInfo{Status: status, Username: username,
+			AuthMethod: "password", RemoteAddr: net.ParseIP("10.0.0.1"), Date: date}}
+	}
+	escalation := &logparser.Event{Type: logparser.EventEscalation, Escalation: &db.Escalation{Tool: "sudo",
+		Status: db.EscalationFailed, Username: "sheb", TargetUser: "root", Date: date}}
+
+	tests := []struct {
+		// sessions is the rule of the config, empty is the default one
+		sessions string
+		events   []*logparser.Event
+		// want are the statuses of the sent messages
+		want []string
+	}{
+		{
+			events: []*logparser.Event{auth(db.AuthAccepted, "sheb"), auth(db.AuthFailed, "root"),
+				auth(db.AuthDisconnected, "sheb"), escalation},
+			want: []string{string(db.AuthAccepted), string(db.EscalationFailed)},
+		},
+		{
+			sessions: `user == "root"`,
+			events:   []*logparser.Event{auth(db.AuthAccepted, "sheb"), auth(db.AuthFailed, "root")},
+			want:     []string{string(db.AuthFailed)},
+		},
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(ioutil.Discard)
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("#%d", i+1), func(t *testing.T) {
+			sessions := tt.sessions
+			if sessions == "" {
+				sessions = `status == "Accepted"`
+			}
+			bus := &recordingBus{Context: context.Background()}
+			cfg := config.Config{TG: &config.TGConfig{Sessions: sessions}}
+			w, err := NewWatcher(cfg, db.NewMemoryStorage(), bus, logrus.NewEntry(logger))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, event := range tt.events {
+				w.handleEvent(event)
+			}
+
+			var got []string
+			for _, msg := range bus.sent {
+				switch msg := msg.(type) {
+				case db.Session:
+					got = append(got, string(msg.Status))
+				case db.Escalation:
+					got = append(got, string(msg.Status))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("handleEvent() sent = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewWatcher(t *testing.T) {
+	cfg := config.Config{TG: &config.TGConfig{Sessions: `status == "Accepted" &&`}}
+	_, err := NewWatcher(cfg, db.NewMemoryStorage(), &recordingBus{Context: context.Background()},
+		logrus.NewEntry(logrus.New()))
+	want := `rule "sessions": at 24: unexpected end of expression`
+	if err == nil || err.Error() != want {
+		t.Errorf("NewWatcher() error = %v, want %s", err, want)
+	}
+}